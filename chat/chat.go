@@ -1,11 +1,13 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os/user"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/huihui4754/expertlib/types"
@@ -29,10 +31,16 @@ type Chat struct {
 	llmUrl                 string
 	modelName              string
 	systemPrompt           string
+	stream                 bool // 是否以 2006、2007 流式回复用户
 	llmChatManager         LLMChatWithFunCallManager
 	expertMessageHandler   func(TotalMessage, string)
 	expertMessageInChan    chan *TotalMessage //消息输入通道
 	toExpertMessageOutChan chan *TotalMessage
+	stopChan               chan struct{}   // 关闭信号，Shutdown 时关闭
+	stopOnce               *sync.Once      // 保证关闭信号只发送一次
+	runDone                chan struct{}   // Run 退出后关闭
+	running                atomic.Bool     // Run 是否已经启动
+	handlerWG              *sync.WaitGroup // 正在请求大模型的处理协程
 	// FunctionCalls          []Funcall
 }
 
@@ -42,7 +50,7 @@ type Funcall struct {
 
 func NewChat() *Chat {
 	defalutDataPath := ""
-	currentUser, err := user.Current() // todo 后续支持从配置文件读取配置
+	currentUser, err := user.Current() // 从配置文件读取配置见 config 包
 	if err != nil {
 		fmt.Printf("获取用户信息失败：%v\n", err)
 	} else {
@@ -55,10 +63,19 @@ func NewChat() *Chat {
 		systemPrompt:           "",
 		expertMessageInChan:    make(chan *TotalMessage),
 		toExpertMessageOutChan: make(chan *TotalMessage),
+		stopChan:               make(chan struct{}),
+		stopOnce:               &sync.Once{},
+		runDone:                make(chan struct{}),
+		handlerWG:              &sync.WaitGroup{},
 		llmChatManager: LLMChatWithFunCallManager{
 			SaveIntervalTime: 20 * time.Minute,
+			MaxToolSteps:     defaultMaxToolSteps,
 			llmsMutex:        &sync.Mutex{},
-			LLMChats:         make(map[string]*OpenaiChatLLM),
+			LLMChats:         make(map[string]*LLMChat),
+			providers:        make(map[string]llmProviderEntry),
+			providersMutex:   &sync.RWMutex{},
+			stopChan:         make(chan struct{}),
+			stopOnce:         &sync.Once{},
 		},
 	}
 }
@@ -69,9 +86,10 @@ func (c *Chat) SetDataFilePath(path string) {
 	logger.Info("dataFilePath set to:", path)
 }
 
+// SetLLMUrl 设置 openai 兼容接口的链接，注册为 DefaultLLMProvider
 func (c *Chat) SetLLMUrl(url string) {
 	c.llmUrl = url
-	c.llmChatManager.AIURL = url
+	c.llmChatManager.AddProvider(DefaultLLMProvider, NewOpenAIProvider(url, ""), "")
 	logger.Info("llmUrl set to:", url)
 }
 
@@ -81,6 +99,23 @@ func (c *Chat) SetModelName(model string) {
 	logger.Info("AIModel set to:", model)
 }
 
+// AddLLMProvider 注册名为 name 的大模型接口，model 为使用该接口时的默认模型，为空时使用 SetModelName 设置的模型
+func (c *Chat) AddLLMProvider(name string, provider LLMProvider, model string) {
+	c.llmChatManager.AddProvider(name, provider, model)
+	logger.Info("llm provider added:", name)
+}
+
+// SetLLMProvider 设置 dialog 没有单独选择时使用的大模型接口，默认为 SetLLMUrl 设置的 DefaultLLMProvider
+func (c *Chat) SetLLMProvider(name string) {
+	c.llmChatManager.DefaultProvider = name
+	logger.Info("default llm provider set to:", name)
+}
+
+// SetDialogLLM 设置 dialog 使用的大模型接口和模型，随对话历史保存，provider 为空时使用默认接口，model 为空时使用接口的默认模型
+func (c *Chat) SetDialogLLM(dialogID string, provider string, model string) error {
+	return c.llmChatManager.SetDialogLLM(dialogID, provider, model)
+}
+
 // 设置多轮对话的个性化提示词，不可设置回复内容格式，否者会无法回复。
 func (c *Chat) SetSystemPrompt(prompt string) {
 	c.systemPrompt = prompt
@@ -88,6 +123,12 @@ func (c *Chat) SetSystemPrompt(prompt string) {
 	logger.Info("SystemPrompt set to:", prompt)
 }
 
+// SetStream 设置是否流式回复，开启后大模型的回复以 2006 增量片段交给专家，最后以 2007 返回完整的回复
+func (c *Chat) SetStream(stream bool) {
+	c.stream = stream
+	logger.Info("stream set to:", stream)
+}
+
 func (c *Chat) HandleExpertRequestMessage(message any) {
 	var messagePointer *TotalMessage
 	var err error
@@ -125,10 +166,22 @@ func (c *Chat) HandleExpertRequestMessage(message any) {
 		logger.Error("不支持的消息结构")
 	}
 	if c.expertMessageInChan != nil && messagePointer != nil && err == nil {
-		c.expertMessageInChan <- messagePointer
+		select {
+		case <-c.stopChan:
+			logger.Warnf("Chat 已关闭，丢弃消息 dialog: %s", messagePointer.DialogID)
+		case c.expertMessageInChan <- messagePointer:
+		}
 	}
 }
 
+// SetMaxToolSteps 设置每条消息最多调用工具的轮数，大模型持续返回工具调用时最多循环这么多轮，默认 5 轮，
+// 小于等于 0 时不调用工具
+func (c *Chat) SetMaxToolSteps(steps int) {
+	c.llmChatManager.MaxToolSteps = steps
+	logger.Info("MaxToolSteps set to:", steps)
+}
+
+// SetCallFunctionHandler 设置调用工具的函数，同一轮的多个工具调用会并行执行，函数需要支持并发调用
 func (c *Chat) SetCallFunctionHandler(callFuncHandler func(call *FunctionCall) (string, error)) {
 	c.llmChatManager.SetCallFuncHandler(callFuncHandler)
 }
@@ -143,13 +196,23 @@ func (c *Chat) SetSaveIntervalTime(interval time.Duration) {
 	logger.Info("Save interval time set to:", interval)
 }
 
+// Run 前台占用启动多轮对话实例，调用 Shutdown 后等待正在进行的大模型请求完成再返回
 func (c *Chat) Run() {
 
 	// Start the chat instance here
-	if c.llmUrl == "" || c.modelName == "" {
-		panic("你必须在执行 Run 前设置 大模型链接和模型名称")
+	if _, model, err := c.llmChatManager.resolveProvider("", ""); err != nil || model == "" {
+		panic("你必须在执行 Run 前设置 大模型链接（或默认的大模型接口）和模型名称")
 	}
 
+	select {
+	case <-c.stopChan:
+		logger.Warn("Chat has been shut down, Run ignored.")
+		return
+	default:
+	}
+	c.running.Store(true)
+	defer close(c.runDone)
+
 	if c.dataFilePath != "" {
 		go c.llmChatManager.PeriodicSave()
 	}
@@ -159,19 +222,73 @@ func (c *Chat) Run() {
 	for {
 		select {
 		case expertMsg := <-c.expertMessageInChan:
-			go c.handleFromExpertMessage(expertMsg)
+			c.handlerWG.Add(1)
+			go func() {
+				defer c.handlerWG.Done()
+				c.handleFromExpertMessage(expertMsg)
+			}()
 		case toExpertMsg := <-c.toExpertMessageOutChan:
+			c.handlerWG.Add(1)
 			go func() {
-				toExpertMessage := *toExpertMsg
-				msg, err := json.Marshal(toExpertMessage)
-				if err != nil {
-					logger.Error("Failed to marshal chat message: %v", err)
-				}
-				c.expertMessageHandler(toExpertMessage, string(msg))
+				defer c.handlerWG.Done()
+				c.forwardToExpert(toExpertMsg)
 			}()
+		case <-c.stopChan:
+			// 等待正在处理的请求结束，期间继续转发它们产生的回复
+			handlersDone := make(chan struct{})
+			go func() {
+				c.handlerWG.Wait()
+				close(handlersDone)
+			}()
+			for {
+				select {
+				case toExpertMsg := <-c.toExpertMessageOutChan:
+					c.forwardToExpert(toExpertMsg)
+				case <-handlersDone:
+					logger.Info("Chat instance stopped")
+					return
+				}
+			}
+		}
+	}
+
+}
+
+func (c *Chat) forwardToExpert(toExpertMsg *TotalMessage) {
+	toExpertMessage := *toExpertMsg
+	msg, err := json.Marshal(toExpertMessage)
+	if err != nil {
+		logger.Error("Failed to marshal chat message: %v", err)
+	}
+	if c.expertMessageHandler != nil {
+		c.expertMessageHandler(toExpertMessage, string(msg))
+	}
+}
+
+// Shutdown 停止接收新消息，等待正在进行的大模型请求完成后将所有对话历史写入磁盘。
+// ctx 超时后仍会尝试保存数据，并返回 ctx 的错误。
+func (c *Chat) Shutdown(ctx context.Context) error {
+	logger.Info("Chat instance shutting down")
+	c.stopOnce.Do(func() {
+		close(c.stopChan)
+	})
+
+	var waitErr error
+	if c.running.Load() {
+		select {
+		case <-c.runDone:
+		case <-ctx.Done():
+			waitErr = ctx.Err()
+			logger.Warnf("Chat shutdown wait interrupted: %v, flushing data anyway.", waitErr)
 		}
 	}
 
+	c.llmChatManager.StopPeriodicSave()
+	if c.dataFilePath != "" {
+		c.llmChatManager.saveAllDialogs()
+	}
+	logger.Info("Chat instance shut down")
+	return waitErr
 }
 
 func (c *Chat) handleFromExpertMessage(message *TotalMessage) {
@@ -179,7 +296,12 @@ func (c *Chat) handleFromExpertMessage(message *TotalMessage) {
 	switch message.EventType {
 	case 1001:
 		logger.Debug("专家发送消息")
-		res := c.llmChatManager.ChatLLM(message)
+		var onDelta func(*TotalMessage)
+		if c.stream {
+			// 增量片段在当前协程按顺序直接交给专家，不经过 toExpertMessageOutChan
+			onDelta = c.forwardToExpert
+		}
+		res := c.llmChatManager.ChatLLM(message, onDelta)
 		if res != nil {
 			c.toExpertMessageOutChan <- res
 		}
@@ -0,0 +1,208 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/huihui4754/expertlib/types"
+	"github.com/openai/openai-go/v3"
+)
+
+type ChatAIMessage = types.ChatAIMessage
+
+// LLMChat 一个 dialog 和大模型的对话，对话历史使用 openai 的格式保存，请求时由 provider 转换成各自接口的格式
+type LLMChat struct {
+	DialogID               string                                   `json:"dialog_id"`
+	Messages               []openai.ChatCompletionMessageParamUnion `json:"messages"`           // 不包括系统提示词
+	Provider               string                                   `json:"provider,omitempty"` // dialog 选择的大模型接口，为空时使用默认接口
+	Model                  string                                   `json:"model,omitempty"`    // dialog 选择的模型，为空时使用接口的默认模型
+	ExpertChatSystemPrompt string                                   `json:"-"`                  // 专家返回必须的内置系统提示词
+	SystemPrompt           string                                   `json:"-"`                  //用户设置的个性化系统提示词
+	MessagesLenLimit       int                                      `json:"-"`
+	MaxToolSteps           int                                      `json:"-"` // 每条消息最多调用工具的轮数
+	LastSavedContentMd5    string                                   `json:"-"`
+	callFuctionCall        func(call *FunctionCall) (string, error) `json:"-"`
+	Relpying               bool                                     `json:"-"`
+	mutex                  *sync.Mutex                              `json:"-"` // 保护 Messages、Provider 和 Model，和管理器的 llmsMutex 是同一把锁
+}
+
+func (l *LLMChat) deleteOldMessage() {
+	if len(l.Messages) > l.MessagesLenLimit {
+		for len(l.Messages) > l.MessagesLenLimit {
+			l.Messages = l.Messages[len(l.Messages)-l.MessagesLenLimit:]
+		}
+		// tool 消息必须跟在对应的工具调用之后，删除开头失去工具调用的 tool 消息
+		for len(l.Messages) > 0 && l.Messages[0].OfTool != nil {
+			l.Messages = l.Messages[1:]
+		}
+	}
+}
+
+// appendMessages 把消息加入对话历史并删除超出长度的旧消息，返回加入后对话历史的副本
+func (l *LLMChat) appendMessages(messages ...openai.ChatCompletionMessageParamUnion) []openai.ChatCompletionMessageParamUnion {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.Messages = append(l.Messages, messages...)
+	l.deleteOldMessage()
+	history := make([]openai.ChatCompletionMessageParamUnion, len(l.Messages))
+	copy(history, l.Messages)
+	return history
+}
+
+// 第一轮使用用户设置的提示词查找是否需要使用工具，如果需要就调用，并将结果传给大模型并带上专家的系统提示词。
+// provider 和 model 为本次请求使用的大模型接口和模型
+func (l *LLMChat) Chat(provider LLMProvider, model string, question string, tools []openai.ChatCompletionToolUnionParam) (string, error) {
+	return l.chat(provider, model, question, tools, nil)
+}
+
+// ChatStream 和 Chat 相同，最后一轮带专家系统提示词的请求以流式返回，每收到一段内容调用一次 onDelta，
+// 返回完整的内容
+func (l *LLMChat) ChatStream(provider LLMProvider, model string, question string, tools []openai.ChatCompletionToolUnionParam, onDelta func(string)) (string, error) {
+	return l.chat(provider, model, question, tools, onDelta)
+}
+
+func (l *LLMChat) chat(provider LLMProvider, model string, question string, tools []openai.ChatCompletionToolUnionParam, onDelta func(string)) (string, error) {
+	if provider == nil {
+		return "请求大模型失败", errors.New("没有可用的大模型接口")
+	}
+
+	if l.Relpying {
+		return "当前dialog llm 还未回复完", errors.New("当前dialog llm 还未回复完")
+	}
+
+	ctx := context.Background()
+	if l.MaxToolSteps <= 0 {
+		// 不调用工具时最后一轮请求也不带工具，避免大模型返回无法执行的工具调用
+		tools = nil
+	}
+
+	history := l.appendMessages(openai.UserMessage(question))
+
+	history, toolReply, err := l.runTools(ctx, provider, model, history, tools)
+	if err != nil {
+		logger.Errorf("chat with llm provider err: %v", err)
+		return "请求大模型失败", err
+	}
+
+	messages := history
+	if toolReply != "" {
+		logger.Debugf("工具初步调用结果 ： %v", toolReply)
+		messages = append(messages, openai.AssistantMessage(toolReply))
+	}
+
+	logger.Debugf("tool len : %v", len(tools))
+
+	req := LLMRequest{
+		Model:    model,
+		System:   l.ExpertChatSystemPrompt,
+		Messages: messages,
+		Tools:    tools,
+	}
+	data, err := json.Marshal(req)
+	if err == nil {
+		logger.Debugf("requset parm : %v", string(data))
+	}
+
+	response, err := provider.Chat(ctx, req, onDelta)
+	if err != nil {
+		logger.Errorf("chat with llm provider err: %v", err)
+		return "请求大模型失败", err
+	}
+
+	l.appendMessages(openai.AssistantMessage(response.Content))
+	return response.Content, nil
+}
+
+// runTools 使用用户设置的提示词循环请求大模型，大模型返回工具调用时并行调用工具，把结果作为 tool 消息继续请求，
+// 直到大模型不再调用工具或者达到 MaxToolSteps 轮。工具调用和结果保存到 Messages 中，
+// 返回加入工具调用后的对话历史和大模型根据工具结果给出的回复，没有调用工具时回复为空字符串
+func (l *LLMChat) runTools(ctx context.Context, provider LLMProvider, model string, history []openai.ChatCompletionMessageParamUnion, tools []openai.ChatCompletionToolUnionParam) ([]openai.ChatCompletionMessageParamUnion, string, error) {
+	if len(tools) == 0 || l.MaxToolSteps <= 0 {
+		// MaxToolSteps 小于等于 0 时不调用工具，也不发送带工具的请求
+		logger.Debug("no need call tool ")
+		return history, "", nil
+	}
+
+	toolMessages := make([]openai.ChatCompletionMessageParamUnion, 0)
+	for step := 0; ; step++ {
+		messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(history)+len(toolMessages))
+		messages = append(messages, history...)
+		messages = append(messages, toolMessages...)
+		req := LLMRequest{
+			Model:    model,
+			System:   l.SystemPrompt,
+			Messages: messages,
+			Tools:    tools,
+		}
+
+		reqData, err := json.Marshal(req)
+		if err == nil {
+			logger.Debugf("tool step %d request: %v", step, string(reqData))
+		}
+
+		response, err := provider.Chat(ctx, req, nil)
+		if err != nil {
+			return history, "", err
+		}
+
+		logger.Debugf("tool step %d toolCalls len : %v", step, len(response.ToolCalls))
+		if len(response.ToolCalls) == 0 || step >= l.MaxToolSteps {
+			if len(response.ToolCalls) > 0 {
+				logger.Warnf("dialog %s 工具调用达到 %d 轮上限，不再调用工具", l.DialogID, l.MaxToolSteps)
+			}
+			if len(toolMessages) == 0 {
+				logger.Debug("no need call tool ")
+				return history, "", nil
+			}
+			return l.appendMessages(toolMessages...), response.Content, nil
+		}
+
+		toolMessages = append(toolMessages, response.toParam())
+		toolMessages = append(toolMessages, l.callTools(response.ToolCalls)...)
+	}
+}
+
+// callTools 并行调用大模型要求的工具，按调用顺序返回 tool 消息，参数错误或调用失败时把错误作为 tool 消息的内容交给大模型
+func (l *LLMChat) callTools(toolCalls []LLMToolCall) []openai.ChatCompletionMessageParamUnion {
+	results := make([]openai.ChatCompletionMessageParamUnion, len(toolCalls))
+	wg := &sync.WaitGroup{}
+	for idx, toolCall := range toolCalls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[idx] = openai.ToolMessage(l.callTool(toolCall.Name, toolCall.Arguments), toolCall.ID)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (l *LLMChat) callTool(name string, arguments string) string {
+	if l.callFuctionCall == nil {
+		logger.Errorf("call tool %s err: no call function handler", name)
+		return fmt.Sprintf("调用工具 %s 失败: 没有设置工具调用处理函数", name)
+	}
+	args := make(map[string]interface{})
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			logger.Errorf("get tool %s arguments err: %v", name, err)
+			return fmt.Sprintf("调用工具 %s 失败: 参数不是合法的 json: %v", name, err)
+		}
+	}
+	result, err := l.callFuctionCall(&FunctionCall{
+		Name:      name,
+		Arguments: args,
+	})
+	if err != nil {
+		logger.Errorf("call tool %s err: %v", name, err)
+		return fmt.Sprintf("调用工具 %s 失败: %v", name, err)
+	}
+	return result
+}
+
+func (l *LLMChat) SetCallFuncHandler(callFuncHandler func(call *FunctionCall) (string, error)) {
+	l.callFuctionCall = callFuncHandler
+}
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+)
+
+func testTools() []openai.ChatCompletionToolUnionParam {
+	return []openai.ChatCompletionToolUnionParam{
+		openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
+			Name:        "weather",
+			Description: openai.String("查询天气"),
+		}),
+	}
+}
+
+func newTestLLMChat(maxToolSteps int) *LLMChat {
+	return &LLMChat{
+		DialogID:         "d1",
+		Messages:         make([]openai.ChatCompletionMessageParamUnion, 0),
+		mutex:            &sync.Mutex{},
+		MessagesLenLimit: 20,
+		MaxToolSteps:     maxToolSteps,
+		callFuctionCall: func(call *FunctionCall) (string, error) {
+			return "晴", nil
+		},
+	}
+}
+
+func TestChatToolSteps(t *testing.T) {
+	weatherCall := LLMResponse{ToolCalls: []LLMToolCall{{ID: "call_1", Name: "weather", Arguments: `{"city":"深圳"}`}}}
+	tests := []struct {
+		name          string
+		maxToolSteps  int
+		replies       []LLMResponse
+		wantRequests  int
+		wantToolsSent []bool // 每次请求是否带工具
+	}{
+		{
+			name:          "tools disabled",
+			maxToolSteps:  0,
+			replies:       []LLMResponse{{Content: "你好"}},
+			wantRequests:  1,
+			wantToolsSent: []bool{false},
+		},
+		{
+			name:          "negative steps disabled",
+			maxToolSteps:  -1,
+			replies:       []LLMResponse{{Content: "你好"}},
+			wantRequests:  1,
+			wantToolsSent: []bool{false},
+		},
+		{
+			name:          "no tool call",
+			maxToolSteps:  5,
+			replies:       []LLMResponse{{Content: ""}, {Content: "你好"}},
+			wantRequests:  2,
+			wantToolsSent: []bool{true, true},
+		},
+		{
+			name:          "one tool call",
+			maxToolSteps:  5,
+			replies:       []LLMResponse{weatherCall, {Content: "深圳晴"}, {Content: "深圳今天晴"}},
+			wantRequests:  3,
+			wantToolsSent: []bool{true, true, true},
+		},
+		{
+			name:          "stop at max steps",
+			maxToolSteps:  2,
+			replies:       []LLMResponse{weatherCall, weatherCall, weatherCall, {Content: "深圳今天晴"}},
+			wantRequests:  4,
+			wantToolsSent: []bool{true, true, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewFakeProvider(tt.replies...)
+			llmChat := newTestLLMChat(tt.maxToolSteps)
+			if _, err := llmChat.Chat(provider, "test-model", "深圳天气怎么样", testTools()); err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			requests := provider.Requests()
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("sent %d requests, want %d", len(requests), tt.wantRequests)
+			}
+			for idx, req := range requests {
+				if sent := len(req.Tools) > 0; sent != tt.wantToolsSent[idx] {
+					t.Errorf("request %d sent tools = %v, want %v", idx, sent, tt.wantToolsSent[idx])
+				}
+			}
+		})
+	}
+}
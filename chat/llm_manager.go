@@ -20,6 +20,8 @@ type LLMCallableTool = types.LLMCallableTool
 type InputSchema = types.InputSchema
 type ModelContextFunctionTool = types.ModelContextFunctionTool
 
+const defaultMaxToolSteps = 5
+
 var (
 	messagesLenLimit = 30
 	systemChatPrompt = `# 我是意图识别器，用户说的话经过意图识别器后会判断用户的意图发给你，你是专家，你会收到用户说的话，意图识别概率，和对话历史，你需要判断用户意图是否是我判断的意图，如果是就返回给我，我会交给自动构建程序库处理，如果不是就返回空意图。你只需要返回json 格式的字符串，不能有任何多余的内容，
@@ -31,11 +33,12 @@ var (
 				"intent_name": "checkautostatus",
 				"intent_description": "这是用于检查自动构建当前的状态",
 				"probability": 0.7
-			} ], 对话历史：["User: 帮我触发上次自动构建的编译",
-			"Progarm: 请确认发布仓地址和tag是否正确：\nhttps://git.ipanel.cn/git/release_extend/main_front_vue.release.git\ndev-v1.0\n请回复\"是\"或\"确认\"继续，或直接输入新的地址和tag进行修改",
-			"User: 是",
-			"Progarm: 马上帮你处理，请稍候",
-			"Progarm: 对 https://git.ipanel.cn/git/release_extend/main_front_vue.release.git 的 dev-v1.0 执行操作完成:\n- “trigger” 操作成功"]
+			} ], 对话历史：
+			[06-12 10:02:03] 用户: 帮我触发上次自动构建的编译
+			[06-12 10:02:04] 程序库(triggerAutoBuild): 请确认发布仓地址和tag是否正确：https://git.ipanel.cn/git/release_extend/main_front_vue.release.git dev-v1.0 请回复"是"或"确认"继续，或直接输入新的地址和tag进行修改
+			[06-12 10:02:10] 用户: 是
+			[06-12 10:02:11] 程序库(triggerAutoBuild): 马上帮你处理，请稍候
+			[06-12 10:03:20] 程序库(triggerAutoBuild): 对 https://git.ipanel.cn/git/release_extend/main_front_vue.release.git 的 dev-v1.0 执行操作完成: “trigger” 操作成功
 		专家：{"intent":"checkautostatus","demand":"查看发布仓的状态 发布仓 为 https://dex.xx.com/dac.release.git  tag 为 x64-v2.0"}
 
 		用户：深圳今天天气怎么样？
@@ -46,22 +49,21 @@ var (
 	`
 )
 
-// type LLMChatWithFunCallInter interface {   // 定义和大模型聊天并调用接口，如果后续需要用户定制，可以考虑放开此接口，并增加相关定制接口
-// 	Chat(string, []openai.ChatCompletionToolUnionParam) string //和用户聊天并返回的接口
-// 	SetCallFuncHandler(func(call *FunctionCall) (string, error))
-// }
-
 type LLMChatWithFunCallManager struct {
-	AIURL            string                                   // 大模型url
-	AIModel          string                                   // 模型名称
+	AIModel          string                                   // 默认模型名称，接口没有设置默认模型时使用
+	DefaultProvider  string                                   // dialog 没有选择大模型接口时使用的接口，为空时使用 DefaultLLMProvider
+	providers        map[string]llmProviderEntry              // 注册的大模型接口
+	providersMutex   *sync.RWMutex                            // 保护 providers
 	SystemPrompt     string                                   // 个性系统提示词
 	Tools            []openai.ChatCompletionToolUnionParam    //openai 定义聊天中可以调用的工具
 	DataPath         string                                   //文件保存路径
 	llmsMutex        *sync.Mutex                              //读写锁
 	callFuncHandler  func(call *FunctionCall) (string, error) // 调用function tool 接口
 	SaveIntervalTime time.Duration
-	LLMChats         map[string]*OpenaiChatLLM
-	// LLMChats        map[string]LLMChatWithFunCallInter
+	MaxToolSteps     int // 每条消息最多调用工具的轮数
+	LLMChats         map[string]*LLMChat
+	stopChan         chan struct{} // 停止定期保存的信号
+	stopOnce         *sync.Once
 }
 
 func (l *LLMChatWithFunCallManager) GetOpenaiChatCompletionToolUnionParam() []openai.ChatCompletionToolUnionParam {
@@ -87,16 +89,15 @@ func (l *LLMChatWithFunCallManager) SetOpenaiChatCompletionToolUnionParam(openai
 	l.Tools = openaiTool
 }
 
-// func (l *LLMChatWithFunCallManager) newLLMChat(dialogID string) LLMChatWithFunCallInter {
-func (l *LLMChatWithFunCallManager) newLLMChat(dialogID string) *OpenaiChatLLM {
-	llmChat := &OpenaiChatLLM{
+func (l *LLMChatWithFunCallManager) newLLMChat(dialogID string) *LLMChat {
+	llmChat := &LLMChat{
 		DialogID:               dialogID,
 		Messages:               make([]openai.ChatCompletionMessageParamUnion, 0, messagesLenLimit+2),
 		SystemPrompt:           l.SystemPrompt,
 		ExpertChatSystemPrompt: systemChatPrompt,
-		AIModel:                l.AIModel,
-		AIURL:                  l.AIURL,
 		MessagesLenLimit:       messagesLenLimit,
+		MaxToolSteps:           l.MaxToolSteps,
+		mutex:                  l.llmsMutex,
 	}
 	if l.callFuncHandler != nil {
 		llmChat.SetCallFuncHandler(l.callFuncHandler)
@@ -105,24 +106,26 @@ func (l *LLMChatWithFunCallManager) newLLMChat(dialogID string) *OpenaiChatLLM {
 	return llmChat
 }
 
-func (l *LLMChatWithFunCallManager) getLLMChatFormCache(dialogID string) *OpenaiChatLLM {
+// getLLMChatFormCache 从文件加载 dialog 的对话，调用时需要持有 llmsMutex
+func (l *LLMChatWithFunCallManager) getLLMChatFormCache(dialogID string) *LLMChat {
 	fileName := filepath.Join(l.DataPath, fmt.Sprintf("%s.json", dialogID))
 	if _, err := os.Stat(fileName); err == nil {
 		data, err := os.ReadFile(fileName)
 		if err == nil {
-			var llm OpenaiChatLLM
+			var llm LLMChat
 			if json.Unmarshal(data, &llm) == nil {
 				hash := md5.Sum(data)
 				llm.LastSavedContentMd5 = hex.EncodeToString(hash[:])
-				llm.AIURL = l.AIURL
-				llm.AIModel = l.AIModel
-				llm.SystemPrompt = l.SystemPrompt + systemChatPrompt
+				llm.SystemPrompt = l.SystemPrompt
+				llm.ExpertChatSystemPrompt = systemChatPrompt
 				llm.MessagesLenLimit = messagesLenLimit
+				llm.MaxToolSteps = l.MaxToolSteps
+				llm.mutex = l.llmsMutex
+				if l.callFuncHandler != nil {
+					llm.SetCallFuncHandler(l.callFuncHandler)
+				}
 
-				l.llmsMutex.Lock()
 				l.LLMChats[dialogID] = &llm
-				l.llmsMutex.Unlock()
-
 				return &llm
 			}
 		}
@@ -131,7 +134,9 @@ func (l *LLMChatWithFunCallManager) getLLMChatFormCache(dialogID string) *Openai
 }
 
 // GetLocalLLMByID通过dialog_id获取LocalLLM实例的函数。
-func (l *LLMChatWithFunCallManager) getLLMChatByID(dialogID string) *OpenaiChatLLM {
+func (l *LLMChatWithFunCallManager) getLLMChatByID(dialogID string) *LLMChat {
+	l.llmsMutex.Lock()
+	defer l.llmsMutex.Unlock()
 
 	if llm, ok := l.LLMChats[dialogID]; ok {
 		return llm
@@ -143,12 +148,59 @@ func (l *LLMChatWithFunCallManager) getLLMChatByID(dialogID string) *OpenaiChatL
 	}
 
 	newLLM := l.newLLMChat(dialogID)
-	l.llmsMutex.Lock()
 	l.LLMChats[dialogID] = newLLM
-	l.llmsMutex.Unlock()
 	return newLLM
 }
 
+// AddProvider 注册名为 name 的大模型接口，model 为使用该接口时的默认模型，已有同名接口时替换
+func (l *LLMChatWithFunCallManager) AddProvider(name string, provider LLMProvider, model string) {
+	l.providersMutex.Lock()
+	l.providers[name] = llmProviderEntry{provider: provider, model: model}
+	l.providersMutex.Unlock()
+}
+
+// resolveProvider 返回 dialog 选择的大模型接口和模型，providerName 为空时使用默认接口，
+// 模型依次使用 dialog 选择的、接口默认的和 AIModel
+func (l *LLMChatWithFunCallManager) resolveProvider(providerName string, model string) (LLMProvider, string, error) {
+	if providerName == "" {
+		providerName = l.DefaultProvider
+	}
+	if providerName == "" {
+		providerName = DefaultLLMProvider
+	}
+	l.providersMutex.RLock()
+	entry, ok := l.providers[providerName]
+	l.providersMutex.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("llm provider %q not found", providerName)
+	}
+	if model == "" {
+		model = entry.model
+	}
+	if model == "" {
+		model = l.AIModel
+	}
+	return entry.provider, model, nil
+}
+
+// SetDialogLLM 设置 dialog 使用的大模型接口和模型，随对话历史保存，providerName 和 model 为空时使用默认值
+func (l *LLMChatWithFunCallManager) SetDialogLLM(dialogID string, providerName string, model string) error {
+	if providerName != "" {
+		l.providersMutex.RLock()
+		_, ok := l.providers[providerName]
+		l.providersMutex.RUnlock()
+		if !ok {
+			return fmt.Errorf("llm provider %q not found", providerName)
+		}
+	}
+	llmChat := l.getLLMChatByID(dialogID)
+	l.llmsMutex.Lock()
+	llmChat.Provider = providerName
+	llmChat.Model = model
+	l.llmsMutex.Unlock()
+	return nil
+}
+
 func (l *LLMChatWithFunCallManager) SetCallFuncHandler(callHandler func(call *FunctionCall) (string, error)) {
 	l.callFuncHandler = callHandler
 }
@@ -160,9 +212,17 @@ func (l *LLMChatWithFunCallManager) saveAllDialogs() {
 		return
 	}
 
+	l.llmsMutex.Lock()
+	llms := make(map[string]*LLMChat, len(l.LLMChats))
 	for id, llm := range l.LLMChats {
+		llms[id] = llm
+	}
+	l.llmsMutex.Unlock()
+
+	for id, llm := range llms {
 		l.llmsMutex.Lock()
 		data, err := json.MarshalIndent(*llm, "", "  ")
+		lastSavedMd5 := llm.LastSavedContentMd5
 		l.llmsMutex.Unlock()
 		if err != nil {
 			logger.Errorf("Failed to marshal dialog %s: %v", id, err)
@@ -171,7 +231,7 @@ func (l *LLMChatWithFunCallManager) saveAllDialogs() {
 		hash := md5.Sum(data)
 		currentMd5 := hex.EncodeToString(hash[:])
 
-		if llm.LastSavedContentMd5 == currentMd5 {
+		if lastSavedMd5 == currentMd5 {
 			continue
 		}
 
@@ -179,7 +239,9 @@ func (l *LLMChatWithFunCallManager) saveAllDialogs() {
 		if err := os.WriteFile(fileName, data, 0644); err != nil {
 			logger.Errorf("Failed to write dialog file %s: %v", fileName, err)
 		} else {
+			l.llmsMutex.Lock()
 			llm.LastSavedContentMd5 = currentMd5
+			l.llmsMutex.Unlock()
 		}
 	}
 }
@@ -188,81 +250,117 @@ func (l *LLMChatWithFunCallManager) PeriodicSave() {
 	ticker := time.NewTicker(l.SaveIntervalTime)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		logger.Debug("Periodic save check")
-		l.saveAllDialogs()
+	for {
+		select {
+		case <-ticker.C:
+			logger.Debug("Periodic save check")
+			l.saveAllDialogs()
+		case <-l.stopChan:
+			return
+		}
 	}
 }
 
+// StopPeriodicSave 停止定期保存，可以重复调用。
+func (l *LLMChatWithFunCallManager) StopPeriodicSave() {
+	l.stopOnce.Do(func() {
+		close(l.stopChan)
+	})
+}
+
 type LLMResponeMessage struct {
 	Intent string `json:"intent"`
 	Demand string `json:"demand"`
 }
 
-func (l *LLMChatWithFunCallManager) ChatLLM(message *TotalMessage) *TotalMessage {
+// ChatLLM 请求大模型并按返回的 json 生成给专家的消息。onDelta 不为空时流式请求，intent 为空时 demand 的增量片段
+// 以 2006 交给 onDelta，结束后返回完整内容的 2007，同一次回复的消息 message_id 相同
+func (l *LLMChatWithFunCallManager) ChatLLM(message *TotalMessage, onDelta func(*TotalMessage)) *TotalMessage {
 	dialogid := message.DialogID
 	llmChat := l.getLLMChatByID(dialogid)
+	l.llmsMutex.Lock()
+	providerName, modelName := llmChat.Provider, llmChat.Model
+	l.llmsMutex.Unlock()
+	// 解析结果只用于本次请求，不保存到 llmChat，同一 dialog 的并发请求互不影响
+	provider, model, err := l.resolveProvider(providerName, modelName)
+	if err != nil {
+		logger.Errorf("dialog %s 获取大模型接口失败: %v", dialogid, err)
+		return nil
+	}
 	var chatMessage string
 	chatMessage = message.Messages.Content
-	if message.PossibleIntentions != nil && message.Messages.History != nil {
+	history := message.Messages.HistoryEntries
+	if len(history) == 0 {
+		// 旧版专家只发送字符串格式的历史消息
+		history = types.ParseLegacyHistory(message.Messages.History)
+	}
+	if message.PossibleIntentions != nil && len(history) > 0 {
 		// 前置判断开始
 		intentionsJSON, err := json.Marshal(message.PossibleIntentions)
 		if err != nil {
 			logger.Errorf("序列化PossibleIntentions失败: %v", err)
 			return nil
 		}
-		history, err := json.Marshal(message.Messages.History)
-		if err != nil {
-			logger.Errorf("序列化 messageEvent.Messages.History 失败: %v", err)
-			return nil
-		}
-		chatMessage = chatMessage + "。 前置意图识别：" + string(intentionsJSON) + "。 对话历史：" + string(history)
+		chatMessage = chatMessage + "。 前置意图识别：" + string(intentionsJSON) + "。 对话历史：\n" + types.RenderHistory(history)
 		// 前置判断结束
 	}
-	llmRespone, err := llmChat.Chat(chatMessage, l.Tools)
-	if err != nil {
-		return nil
-	}
 
-	var jsonData LLMResponeMessage
-	if err := json.Unmarshal([]byte(llmRespone), &jsonData); err == nil {
-		if jsonData.Intent == "" {
-
-			replyMsg := TotalMessage{
-				EventType: 2001, // 返回给用户的消息
-				DialogID:  message.DialogID,
-				MessageID: uuid.New().String(),
-				UserId:    message.UserId,
-				Messages: struct {
-					Content     string       `json:"content"`
-					Attachments []Attachment `json:"attachments"`
-					History     []string     `json:"history,omitempty"`
-				}{
-					Content:     jsonData.Demand,
-					Attachments: message.Messages.Attachments,
-				},
+	replyEvent := types.EventServerMessage
+	replyID := uuid.New().String()
+	var llmRespone string
+	var demand *demandStream
+	if onDelta != nil {
+		replyEvent = types.EventChatDone
+		demand = &demandStream{}
+		llmRespone, err = llmChat.ChatStream(provider, model, chatMessage, l.Tools, func(delta string) {
+			piece := demand.write(delta)
+			if piece == "" {
+				return
 			}
-			return &replyMsg
-		} else {
-			replyMsg := TotalMessage{
-				EventType: 1001, // 返回给程序库的消息
+			deltaMsg := TotalMessage{
+				EventType: types.EventChatDelta,
 				DialogID:  message.DialogID,
-				MessageID: message.MessageID,
+				MessageID: replyID,
 				UserId:    message.UserId,
-				Intention: jsonData.Intent,
-				Messages: struct {
-					Content     string       `json:"content"`
-					Attachments []Attachment `json:"attachments"`
-					History     []string     `json:"history,omitempty"`
-				}{
-					Content:     jsonData.Demand,
-					Attachments: message.Messages.Attachments,
-				},
 			}
-			return &replyMsg
+			deltaMsg.Messages.Content = piece
+			onDelta(&deltaMsg)
+		})
+	} else {
+		llmRespone, err = llmChat.Chat(provider, model, chatMessage, l.Tools)
+	}
+
+	var jsonData LLMResponeMessage
+	if err != nil || json.Unmarshal([]byte(llmRespone), &jsonData) != nil {
+		if demand != nil && demand.streamed() {
+			// 已经有片段发给了用户，用已经解析到的内容结束本次回复
+			logger.Warnf("dialog %s 流式回复没有正常结束: %v", message.DialogID, err)
+			jsonData = LLMResponeMessage{Demand: demand.demand}
+		} else {
+			return nil
 		}
 	}
 
-	return nil
+	if jsonData.Intent == "" {
+		replyMsg := TotalMessage{
+			EventType: replyEvent, // 返回给用户的消息
+			DialogID:  message.DialogID,
+			MessageID: replyID,
+			UserId:    message.UserId,
+		}
+		replyMsg.Messages.Content = jsonData.Demand
+		replyMsg.Messages.Attachments = message.Messages.Attachments
+		return &replyMsg
+	}
 
+	replyMsg := TotalMessage{
+		EventType: 1001, // 返回给程序库的消息
+		DialogID:  message.DialogID,
+		MessageID: message.MessageID,
+		UserId:    message.UserId,
+		Intention: jsonData.Intent,
+	}
+	replyMsg.Messages.Content = jsonData.Demand
+	replyMsg.Messages.Attachments = message.Messages.Attachments
+	return &replyMsg
 }
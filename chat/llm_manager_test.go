@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+func newTestManager(dataPath string) *LLMChatWithFunCallManager {
+	return &LLMChatWithFunCallManager{
+		AIModel:        "global-model",
+		DataPath:       dataPath,
+		llmsMutex:      &sync.Mutex{},
+		LLMChats:       make(map[string]*LLMChat),
+		providers:      make(map[string]llmProviderEntry),
+		providersMutex: &sync.RWMutex{},
+		stopChan:       make(chan struct{}),
+		stopOnce:       &sync.Once{},
+	}
+}
+
+func userMessage(dialogID string, content string) *TotalMessage {
+	message := &TotalMessage{EventType: types.EventUserMessage, DialogID: dialogID, UserId: "user-1", MessageID: "m1"}
+	message.Messages.Content = content
+	return message
+}
+
+func TestResolveProvider(t *testing.T) {
+	openaiProvider := NewFakeProvider()
+	ollamaProvider := NewFakeProvider()
+	providers := map[string]LLMProvider{DefaultLLMProvider: openaiProvider, "ollama": ollamaProvider}
+
+	tests := []struct {
+		name            string
+		defaultProvider string
+		provider        string
+		model           string
+		wantProvider    string
+		wantModel       string
+		wantErr         bool
+	}{
+		{name: "default provider and its model", wantProvider: DefaultLLMProvider, wantModel: "gpt-4o"},
+		{name: "manager default provider", defaultProvider: "ollama", wantProvider: "ollama", wantModel: "global-model"},
+		{name: "dialog model", model: "gpt-4o-mini", wantProvider: DefaultLLMProvider, wantModel: "gpt-4o-mini"},
+		{name: "dialog provider", defaultProvider: DefaultLLMProvider, provider: "ollama", wantProvider: "ollama", wantModel: "global-model"},
+		{name: "dialog provider and model", provider: "ollama", model: "qwen", wantProvider: "ollama", wantModel: "qwen"},
+		{name: "unknown dialog provider", provider: "missing", wantErr: true},
+		{name: "unknown default provider", defaultProvider: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t.TempDir())
+			manager.AddProvider(DefaultLLMProvider, openaiProvider, "gpt-4o")
+			manager.AddProvider("ollama", ollamaProvider, "")
+			manager.DefaultProvider = tt.defaultProvider
+
+			provider, model, err := manager.resolveProvider(tt.provider, tt.model)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolveProvider() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveProvider() error = %v", err)
+			}
+			if provider != providers[tt.wantProvider] {
+				t.Errorf("resolveProvider() provider is not %s", tt.wantProvider)
+			}
+			if model != tt.wantModel {
+				t.Errorf("resolveProvider() model = %q, want %q", model, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestChatLLMDialogOverride(t *testing.T) {
+	manager := newTestManager(t.TempDir())
+	openaiProvider := NewFakeProvider()
+	ollamaProvider := NewFakeProvider()
+	manager.AddProvider(DefaultLLMProvider, openaiProvider, "gpt-4o")
+	manager.AddProvider("ollama", ollamaProvider, "llama3")
+
+	if err := manager.SetDialogLLM("d2", "missing", ""); err == nil {
+		t.Error("SetDialogLLM() with an unknown provider error = nil, want error")
+	}
+	if err := manager.SetDialogLLM("d2", "ollama", "qwen"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dialogID := range []string{"d1", "d2"} {
+		reply := manager.ChatLLM(userMessage(dialogID, "你好"), nil)
+		if reply == nil || reply.Messages.Content != "你好" {
+			t.Fatalf("ChatLLM(%s) = %+v, want reply 你好", dialogID, reply)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		provider  *FakeProvider
+		wantModel string
+	}{
+		{"default dialog", openaiProvider, "gpt-4o"},
+		{"override dialog", ollamaProvider, "qwen"},
+	}
+	for _, tt := range tests {
+		requests := tt.provider.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("%s: provider got %d requests, want 1", tt.name, len(requests))
+		}
+		if requests[0].Model != tt.wantModel {
+			t.Errorf("%s: request model = %q, want %q", tt.name, requests[0].Model, tt.wantModel)
+		}
+	}
+
+	// 清空选择后恢复默认接口
+	if err := manager.SetDialogLLM("d2", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	manager.ChatLLM(userMessage("d2", "再见"), nil)
+	if got := len(openaiProvider.Requests()); got != 2 {
+		t.Errorf("default provider got %d requests after reset, want 2", got)
+	}
+}
+
+func TestDialogLLMPersistence(t *testing.T) {
+	dataPath := t.TempDir()
+	manager := newTestManager(dataPath)
+	manager.AddProvider(DefaultLLMProvider, NewFakeProvider(), "gpt-4o")
+	manager.AddProvider("ollama", NewFakeProvider(), "")
+	if err := manager.SetDialogLLM("d1", "ollama", "qwen"); err != nil {
+		t.Fatal(err)
+	}
+	manager.saveAllDialogs()
+
+	// 重启后从文件加载 dialog 的选择
+	restarted := newTestManager(dataPath)
+	openaiProvider := NewFakeProvider()
+	ollamaProvider := NewFakeProvider()
+	restarted.AddProvider(DefaultLLMProvider, openaiProvider, "gpt-4o")
+	restarted.AddProvider("ollama", ollamaProvider, "")
+
+	llmChat := restarted.getLLMChatByID("d1")
+	if llmChat.Provider != "ollama" || llmChat.Model != "qwen" {
+		t.Fatalf("loaded dialog provider = %q model = %q, want ollama qwen", llmChat.Provider, llmChat.Model)
+	}
+	if reply := restarted.ChatLLM(userMessage("d1", "你好"), nil); reply == nil {
+		t.Fatal("ChatLLM() = nil")
+	}
+	requests := ollamaProvider.Requests()
+	if len(requests) != 1 || requests[0].Model != "qwen" {
+		t.Errorf("ollama requests = %+v, want one request with model qwen", requests)
+	}
+	if got := len(openaiProvider.Requests()); got != 0 {
+		t.Errorf("default provider got %d requests, want 0", got)
+	}
+}
+
+func TestChatLLMConcurrent(t *testing.T) {
+	manager := newTestManager(t.TempDir())
+	manager.AddProvider(DefaultLLMProvider, NewFakeProvider(), "gpt-4o")
+	manager.AddProvider("ollama", NewFakeProvider(), "")
+
+	// 同一 dialog 并发请求、切换接口和保存，使用 -race 检查
+	wg := &sync.WaitGroup{}
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			manager.ChatLLM(userMessage("d1", "你好"), nil)
+		}()
+		go func() {
+			defer wg.Done()
+			provider := ""
+			if idx%2 == 0 {
+				provider = "ollama"
+			}
+			manager.SetDialogLLM("d1", provider, "")
+		}()
+		go func() {
+			defer wg.Done()
+			manager.saveAllDialogs()
+		}()
+	}
+	wg.Wait()
+
+	llmChat := manager.getLLMChatByID("d1")
+	if got := len(llmChat.Messages); got != 16 {
+		t.Errorf("dialog has %d messages, want 16", got)
+	}
+}
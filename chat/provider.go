@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// DefaultLLMProvider SetLLMUrl 设置的 openai 兼容接口注册的名称，没有调用 SetLLMProvider 时对话使用该接口
+const DefaultLLMProvider = "default"
+
+// LLMRequest 一次大模型请求。对话历史和工具统一使用 openai 的格式保存，由各个 LLMProvider 转换成自己的接口格式
+type LLMRequest struct {
+	Model    string
+	System   string                                   // 系统提示词
+	Messages []openai.ChatCompletionMessageParamUnion // 不包括系统提示词
+	Tools    []openai.ChatCompletionToolUnionParam
+}
+
+// LLMToolCall 大模型要求的一次工具调用
+type LLMToolCall struct {
+	ID        string
+	Name      string
+	Arguments string // json 格式的参数
+}
+
+// LLMResponse 大模型的一条回复
+type LLMResponse struct {
+	Content   string
+	ToolCalls []LLMToolCall
+}
+
+// LLMProvider 大模型接口，onDelta 不为空时以流式请求，每收到一段回复内容调用一次 onDelta，
+// 返回的 Content 仍然是完整的内容
+type LLMProvider interface {
+	Chat(ctx context.Context, req LLMRequest, onDelta func(string)) (LLMResponse, error)
+}
+
+// llmProviderEntry 注册的大模型接口和它的默认模型
+type llmProviderEntry struct {
+	provider LLMProvider
+	model    string
+}
+
+// toParam 把大模型的回复转换成 openai 格式的 assistant 消息，用于保存到对话历史
+func (r LLMResponse) toParam() openai.ChatCompletionMessageParamUnion {
+	if len(r.ToolCalls) == 0 {
+		return openai.AssistantMessage(r.Content)
+	}
+	assistant := openai.ChatCompletionAssistantMessageParam{}
+	if r.Content != "" {
+		assistant.Content.OfString = openai.String(r.Content)
+	}
+	for _, toolCall := range r.ToolCalls {
+		assistant.ToolCalls = append(assistant.ToolCalls, openai.ChatCompletionMessageToolCallUnionParam{
+			OfFunction: &openai.ChatCompletionMessageFunctionToolCallParam{
+				ID: toolCall.ID,
+				Function: openai.ChatCompletionMessageFunctionToolCallFunctionParam{
+					Name:      toolCall.Name,
+					Arguments: toolCall.Arguments,
+				},
+			},
+		})
+	}
+	return openai.ChatCompletionMessageParamUnion{OfAssistant: &assistant}
+}
+
+// plainMessage 从 openai 格式的消息中取出各个接口都需要的字段
+type plainMessage struct {
+	Role       string
+	Content    string
+	ToolCalls  []LLMToolCall
+	ToolCallID string
+}
+
+// toPlainMessages 把 openai 格式的消息转换成 plainMessage，多段内容拼接成一个字符串
+func toPlainMessages(messages []openai.ChatCompletionMessageParamUnion) ([]plainMessage, error) {
+	plains := make([]plainMessage, 0, len(messages))
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		var raw struct {
+			Role      string          `json:"role"`
+			Content   json.RawMessage `json:"content"`
+			ToolCalls []struct {
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+			ToolCallID string `json:"tool_call_id"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		plain := plainMessage{
+			Role:       raw.Role,
+			Content:    plainContent(raw.Content),
+			ToolCallID: raw.ToolCallID,
+		}
+		if plain.Role == "developer" {
+			plain.Role = "system"
+		}
+		for _, toolCall := range raw.ToolCalls {
+			plain.ToolCalls = append(plain.ToolCalls, LLMToolCall{
+				ID:        toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+		plains = append(plains, plain)
+	}
+	return plains, nil
+}
+
+// plainContent 消息内容是字符串时直接返回，是多段内容时拼接其中的文本
+func plainContent(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(content, &parts) != nil {
+		return ""
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// plainTool openai 格式的函数工具定义
+type plainTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// toPlainTools 取出 openai 格式工具中的函数定义，忽略其他类型的工具
+func toPlainTools(tools []openai.ChatCompletionToolUnionParam) ([]plainTool, error) {
+	plains := make([]plainTool, 0, len(tools))
+	for _, tool := range tools {
+		data, err := json.Marshal(tool)
+		if err != nil {
+			return nil, err
+		}
+		var raw struct {
+			Type     string    `json:"type"`
+			Function plainTool `json:"function"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		if raw.Type != "function" {
+			continue
+		}
+		plains = append(plains, raw.Function)
+	}
+	return plains, nil
+}
+
+// toolArguments 把 json 字符串格式的参数转换成对象，不是合法的 json 对象时返回空对象
+func toolArguments(arguments string) json.RawMessage {
+	var args map[string]any
+	if json.Unmarshal([]byte(arguments), &args) != nil || args == nil {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+// providerURL 拼接接口地址，baseURL 已经以 suffix 的前缀路径结尾时不重复添加，例如 https://api.anthropic.com/v1 + /v1/messages
+func providerURL(baseURL string, suffix string) string {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if idx := strings.LastIndexByte(suffix, '/'); idx > 0 && strings.HasSuffix(baseURL, suffix[:idx]) {
+		return baseURL + suffix[idx:]
+	}
+	return baseURL + suffix
+}
+
+func providerHTTPError(provider string, status string, body []byte) error {
+	return fmt.Errorf("%s request failed: %s: %s", provider, status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,276 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicVersion          = "2023-06-01"
+	defaultAnthropicMaxTokens = 4096
+)
+
+// AnthropicProvider anthropic 风格的 /v1/messages 接口
+type AnthropicProvider struct {
+	url       string
+	apiKey    string
+	maxTokens int
+	client    *http.Client
+}
+
+// NewAnthropicProvider baseURL 例如 https://api.anthropic.com，maxTokens 为每次回复的最大 token 数，小于等于 0 时为 4096
+func NewAnthropicProvider(baseURL string, apiKey string, maxTokens int) *AnthropicProvider {
+	if maxTokens <= 0 {
+		maxTokens = defaultAnthropicMaxTokens
+	}
+	return &AnthropicProvider{
+		url:       providerURL(baseURL, "/v1/messages"),
+		apiKey:    apiKey,
+		maxTokens: maxTokens,
+		client:    &http.Client{},
+	}
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Stream    bool               `json:"stream,omitempty"`
+}
+
+// anthropicEvent 流式返回的事件，只解析用到的字段
+type anthropicEvent struct {
+	Type         string         `json:"type"`
+	Index        int            `json:"index"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *AnthropicProvider) Chat(ctx context.Context, req LLMRequest, onDelta func(string)) (LLMResponse, error) {
+	body, err := p.request(req, onDelta != nil)
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	if p.apiKey != "" {
+		httpReq.Header.Set("x-api-key", p.apiKey)
+	}
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return LLMResponse{}, providerHTTPError("anthropic", resp.Status, data)
+	}
+
+	if onDelta == nil {
+		var message struct {
+			Content []anthropicBlock `json:"content"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+			return LLMResponse{}, err
+		}
+		response := anthropicResponse(message.Content)
+		logger.Debugf("anthropic completion : %v", response.Content)
+		return response, nil
+	}
+	return p.readStream(resp.Body, onDelta)
+}
+
+// readStream 解析 sse 事件，文本片段交给 onDelta，工具调用的参数按片段拼接
+func (p *AnthropicProvider) readStream(body io.Reader, onDelta func(string)) (LLMResponse, error) {
+	blocks := make([]anthropicBlock, 0)
+	inputs := make(map[int]*strings.Builder) // content_block 的 index 对应的工具参数
+	positions := make(map[int]int)           // content_block 的 index 对应 blocks 中的位置
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			return anthropicResponse(blocks), err
+		}
+		switch event.Type {
+		case "content_block_start":
+			positions[event.Index] = len(blocks)
+			blocks = append(blocks, event.ContentBlock)
+			if event.ContentBlock.Type == "tool_use" {
+				inputs[event.Index] = &strings.Builder{}
+			}
+		case "content_block_delta":
+			position, ok := positions[event.Index]
+			if !ok {
+				continue
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				blocks[position].Text += event.Delta.Text
+				onDelta(event.Delta.Text)
+			case "input_json_delta":
+				if input, ok := inputs[event.Index]; ok {
+					input.WriteString(event.Delta.PartialJSON)
+				}
+			}
+		case "error":
+			return anthropicResponse(blocks), errors.New(event.Error.Type + ": " + event.Error.Message)
+		}
+	}
+	for index, input := range inputs {
+		if input.Len() > 0 {
+			blocks[positions[index]].Input = json.RawMessage(input.String())
+		}
+	}
+	response := anthropicResponse(blocks)
+	if err := scanner.Err(); err != nil {
+		return response, err
+	}
+	logger.Debugf("anthropic completion stream : %v", response.Content)
+	return response, nil
+}
+
+func anthropicResponse(blocks []anthropicBlock) LLMResponse {
+	response := LLMResponse{}
+	var content strings.Builder
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			arguments := "{}"
+			if len(block.Input) > 0 {
+				arguments = string(block.Input)
+			}
+			response.ToolCalls = append(response.ToolCalls, LLMToolCall{
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: arguments,
+			})
+		}
+	}
+	response.Content = content.String()
+	return response
+}
+
+func (p *AnthropicProvider) request(req LLMRequest, stream bool) ([]byte, error) {
+	plains, err := toPlainMessages(req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	tools, err := toPlainTools(req.Tools)
+	if err != nil {
+		return nil, err
+	}
+
+	anthropicReq := anthropicRequest{
+		Model:     req.Model,
+		MaxTokens: p.maxTokens,
+		Messages:  make([]anthropicMessage, 0, len(plains)),
+		Stream:    stream,
+	}
+	systems := make([]string, 0)
+	if req.System != "" {
+		systems = append(systems, req.System)
+	}
+	for _, plain := range plains {
+		role := plain.Role
+		blocks := make([]anthropicBlock, 0, len(plain.ToolCalls)+1)
+		switch plain.Role {
+		case "system":
+			if plain.Content != "" {
+				systems = append(systems, plain.Content)
+			}
+			continue
+		case "tool":
+			// 工具结果放在 user 消息中
+			role = "user"
+			blocks = append(blocks, anthropicBlock{Type: "tool_result", ToolUseID: plain.ToolCallID, Content: plain.Content})
+		default:
+			if plain.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: plain.Content})
+			}
+			for _, toolCall := range plain.ToolCalls {
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    toolCall.ID,
+					Name:  toolCall.Name,
+					Input: toolArguments(toolCall.Arguments),
+				})
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		// 相同角色的连续消息合并成一条，例如同一轮的多个工具结果
+		if last := len(anthropicReq.Messages) - 1; last >= 0 && anthropicReq.Messages[last].Role == role {
+			anthropicReq.Messages[last].Content = append(anthropicReq.Messages[last].Content, blocks...)
+			continue
+		}
+		anthropicReq.Messages = append(anthropicReq.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	anthropicReq.System = strings.Join(systems, "\n\n")
+	// 对话历史被截断后可能以 assistant 消息或失去工具调用的工具结果开头
+	for len(anthropicReq.Messages) > 0 && (anthropicReq.Messages[0].Role != "user" || anthropicReq.Messages[0].Content[0].Type == "tool_result") {
+		anthropicReq.Messages = anthropicReq.Messages[1:]
+	}
+
+	for _, tool := range tools {
+		schema := tool.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object"}
+		}
+		anthropicReq.Tools = append(anthropicReq.Tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: schema,
+		})
+	}
+	return json.Marshal(anthropicReq)
+}
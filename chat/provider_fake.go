@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// FakeProvider 用于测试的大模型，不发出任何请求：按顺序返回设置的回复，用完后把最后一条用户消息作为
+// {"intent":"","demand":...} 返回。流式请求时每个字符调用一次 onDelta，收到的请求可以用 Requests 查看
+type FakeProvider struct {
+	mu       *sync.Mutex
+	replies  []LLMResponse
+	requests []LLMRequest
+}
+
+func NewFakeProvider(replies ...LLMResponse) *FakeProvider {
+	return &FakeProvider{
+		mu:      &sync.Mutex{},
+		replies: replies,
+	}
+}
+
+func (p *FakeProvider) Chat(ctx context.Context, req LLMRequest, onDelta func(string)) (LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return LLMResponse{}, err
+	}
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	var response LLMResponse
+	if len(p.replies) > 0 {
+		response = p.replies[0]
+		p.replies = p.replies[1:]
+	} else {
+		response = fakeEcho(req)
+	}
+	p.mu.Unlock()
+
+	if onDelta != nil {
+		for _, char := range response.Content {
+			onDelta(string(char))
+		}
+	}
+	return response, nil
+}
+
+// Requests 返回收到的所有请求
+func (p *FakeProvider) Requests() []LLMRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	requests := make([]LLMRequest, len(p.requests))
+	copy(requests, p.requests)
+	return requests
+}
+
+func fakeEcho(req LLMRequest) LLMResponse {
+	question := ""
+	plains, err := toPlainMessages(req.Messages)
+	if err == nil {
+		for idx := len(plains) - 1; idx >= 0; idx-- {
+			if plains[idx].Role == "user" {
+				question = plains[idx].Content
+				break
+			}
+		}
+	}
+	data, _ := json.Marshal(LLMResponeMessage{Demand: question})
+	return LLMResponse{Content: string(data)}
+}
@@ -0,0 +1,164 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// OllamaProvider ollama 原生的 /api/chat 接口
+type OllamaProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewOllamaProvider baseURL 例如 http://127.0.0.1:11434
+func NewOllamaProvider(baseURL string) *OllamaProvider {
+	return &OllamaProvider{
+		url:    providerURL(baseURL, "/api/chat"),
+		client: &http.Client{},
+	}
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string    `json:"type"`
+	Function plainTool `json:"function"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+}
+
+type ollamaResponse struct {
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error"`
+}
+
+func (p *OllamaProvider) Chat(ctx context.Context, req LLMRequest, onDelta func(string)) (LLMResponse, error) {
+	body, err := p.request(req, onDelta != nil)
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return LLMResponse{}, providerHTTPError("ollama", resp.Status, data)
+	}
+
+	// 非流式时只有一行，流式时每行一段回复，最后一行 done 为 true
+	response := LLMResponse{}
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			response.Content = content.String()
+			return response, err
+		}
+		if chunk.Error != "" {
+			response.Content = content.String()
+			return response, errors.New(chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				onDelta(chunk.Message.Content)
+			}
+		}
+		for _, toolCall := range chunk.Message.ToolCalls {
+			response.ToolCalls = append(response.ToolCalls, LLMToolCall{
+				// ollama 的工具调用没有 id，生成一个用于在对话历史中对应工具结果
+				ID:        "call_" + uuid.New().String(),
+				Name:      toolCall.Function.Name,
+				Arguments: string(toolCall.Function.Arguments),
+			})
+		}
+		if chunk.Done {
+			break
+		}
+	}
+	response.Content = content.String()
+	if err := scanner.Err(); err != nil {
+		return response, err
+	}
+	logger.Debugf("ollama completion : %v", response.Content)
+	return response, nil
+}
+
+func (p *OllamaProvider) request(req LLMRequest, stream bool) ([]byte, error) {
+	plains, err := toPlainMessages(req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	tools, err := toPlainTools(req.Tools)
+	if err != nil {
+		return nil, err
+	}
+
+	ollamaReq := ollamaRequest{
+		Model:    req.Model,
+		Messages: make([]ollamaMessage, 0, len(plains)+1),
+		Stream:   stream,
+	}
+	if req.System != "" {
+		ollamaReq.Messages = append(ollamaReq.Messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	toolNames := make(map[string]string) // 工具调用 id 对应的工具名称，ollama 的工具结果按名称对应
+	for _, plain := range plains {
+		message := ollamaMessage{Role: plain.Role, Content: plain.Content}
+		for _, toolCall := range plain.ToolCalls {
+			toolNames[toolCall.ID] = toolCall.Name
+			call := ollamaToolCall{}
+			call.Function.Name = toolCall.Name
+			call.Function.Arguments = toolArguments(toolCall.Arguments)
+			message.ToolCalls = append(message.ToolCalls, call)
+		}
+		if plain.Role == "tool" {
+			message.ToolName = toolNames[plain.ToolCallID]
+		}
+		ollamaReq.Messages = append(ollamaReq.Messages, message)
+	}
+	for _, tool := range tools {
+		ollamaReq.Tools = append(ollamaReq.Tools, ollamaTool{Type: "function", Function: tool})
+	}
+	return json.Marshal(ollamaReq)
+}
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// OpenAIProvider openai 兼容的 chat completions 接口，例如 vllm、openai
+type OpenAIProvider struct {
+	client openai.Client
+}
+
+// NewOpenAIProvider baseURL 例如 http://127.0.0.1:8010/v1，apiKey 为空时使用环境变量 OPENAI_API_KEY
+func NewOpenAIProvider(baseURL string, apiKey string, opts ...option.RequestOption) *OpenAIProvider {
+	clientOpts := []option.RequestOption{option.WithBaseURL(baseURL)}
+	if apiKey != "" {
+		clientOpts = append(clientOpts, option.WithAPIKey(apiKey))
+	}
+	clientOpts = append(clientOpts, opts...)
+	return &OpenAIProvider{client: openai.NewClient(clientOpts...)}
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, req LLMRequest, onDelta func(string)) (LLMResponse, error) {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(req.Messages)+1)
+	messages = append(messages, openai.SystemMessage(req.System))
+	messages = append(messages, req.Messages...)
+	params := openai.ChatCompletionNewParams{
+		Messages: messages,
+		Tools:    req.Tools,
+		Model:    req.Model,
+	}
+
+	if onDelta == nil {
+		completion, err := p.client.Chat.Completions.New(ctx, params)
+		if err != nil {
+			return LLMResponse{}, err
+		}
+		data, err := json.Marshal(completion)
+		if err == nil {
+			logger.Debugf("completion : %v", string(data))
+		}
+		if len(completion.Choices) == 0 {
+			return LLMResponse{}, errors.New("openai completion has no choices")
+		}
+		return openaiResponse(completion.Choices[0].Message), nil
+	}
+
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	acc := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			onDelta(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		response := LLMResponse{}
+		if len(acc.Choices) > 0 {
+			response.Content = acc.Choices[0].Message.Content
+		}
+		return response, err
+	}
+	if len(acc.Choices) == 0 {
+		return LLMResponse{}, errors.New("openai completion stream has no choices")
+	}
+	logger.Debugf("completion stream : %v", acc.Choices[0].Message.Content)
+	return openaiResponse(acc.Choices[0].Message), nil
+}
+
+func openaiResponse(message openai.ChatCompletionMessage) LLMResponse {
+	response := LLMResponse{Content: message.Content}
+	for _, toolCall := range message.ToolCalls {
+		response.ToolCalls = append(response.ToolCalls, LLMToolCall{
+			ID:        toolCall.ID,
+			Name:      toolCall.Function.Name,
+			Arguments: toolCall.Function.Arguments,
+		})
+	}
+	return response
+}
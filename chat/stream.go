@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// demandStream 从大模型流式返回的 json 中增量解析 demand，intent 确定为空字符串后才输出，
+// intent 不为空时 demand 是交给程序库的需求，不能发给用户
+type demandStream struct {
+	buf    strings.Builder
+	demand string // 目前解析到的 demand
+	sent   int    // 已经输出的 demand 字节数
+}
+
+// write 追加一段大模型的增量内容，返回可以发给用户的新的 demand 片段
+func (d *demandStream) write(delta string) string {
+	d.buf.WriteString(delta)
+	fields := partialStringFields(d.buf.String())
+	intent, ok := fields["intent"]
+	if !ok || !intent.complete || intent.value != "" {
+		return ""
+	}
+	d.demand = fields["demand"].value
+	if len(d.demand) <= d.sent {
+		return ""
+	}
+	piece := d.demand[d.sent:]
+	d.sent = len(d.demand)
+	return piece
+}
+
+// streamed 是否已经有片段发给了用户
+func (d *demandStream) streamed() bool {
+	return d.sent > 0
+}
+
+type partialField struct {
+	value    string
+	complete bool
+}
+
+// partialStringFields 解析可能还不完整的 json 对象中字符串类型的字段，遇到其他类型的值或格式错误时停止，
+// 返回已经解析到的字段，最后一个字段的值可能不完整
+func partialStringFields(s string) map[string]partialField {
+	fields := make(map[string]partialField)
+	idx := strings.IndexByte(s, '{')
+	if idx < 0 {
+		return fields
+	}
+	idx++
+	for {
+		idx = skipSpace(s, idx)
+		if idx >= len(s) || s[idx] != '"' {
+			return fields
+		}
+		key, next, complete := scanString(s, idx+1)
+		if !complete {
+			return fields
+		}
+		idx = skipSpace(s, next)
+		if idx >= len(s) || s[idx] != ':' {
+			return fields
+		}
+		idx = skipSpace(s, idx+1)
+		if idx >= len(s) || s[idx] != '"' {
+			return fields
+		}
+		value, next, complete := scanString(s, idx+1)
+		fields[key] = partialField{value: value, complete: complete}
+		if !complete {
+			return fields
+		}
+		idx = skipSpace(s, next)
+		if idx >= len(s) || s[idx] != ',' {
+			return fields
+		}
+		idx++
+	}
+}
+
+func skipSpace(s string, idx int) int {
+	for idx < len(s) && strings.IndexByte(" \t\r\n", s[idx]) >= 0 {
+		idx++
+	}
+	return idx
+}
+
+// scanString 从 json 字符串开引号之后的位置开始解码，返回解码的内容、闭引号之后的位置和字符串是否完整。
+// 末尾不完整的转义序列不解码，等下一段内容到达后再解析
+func scanString(s string, idx int) (string, int, bool) {
+	var value strings.Builder
+	for idx < len(s) {
+		switch s[idx] {
+		case '"':
+			return value.String(), idx + 1, true
+		case '\\':
+			size := escapeSize(s[idx:])
+			if size == 0 || idx+size > len(s) {
+				return value.String(), idx, false
+			}
+			var unescaped string
+			if err := json.Unmarshal([]byte(`"`+s[idx:idx+size]+`"`), &unescaped); err != nil {
+				return value.String(), idx, false
+			}
+			value.WriteString(unescaped)
+			idx += size
+		default:
+			value.WriteByte(s[idx])
+			idx++
+		}
+	}
+	return value.String(), idx, false
+}
+
+// escapeSize 转义序列的长度，\u 高位代理需要连同后面的低位代理一起解码，长度还不能确定时返回 0
+func escapeSize(s string) int {
+	if len(s) < 2 {
+		return 0
+	}
+	if s[1] != 'u' {
+		return 2
+	}
+	if len(s) < 6 {
+		return 0
+	}
+	code, err := strconv.ParseUint(s[2:6], 16, 16)
+	if err == nil && code >= 0xD800 && code < 0xDC00 {
+		return 12
+	}
+	return 6
+}
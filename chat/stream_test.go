@@ -0,0 +1,199 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+func TestDemandStreamWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []string
+		wantPieces []string // 每段内容返回的片段
+		wantDemand string
+	}{
+		{
+			name:       "intent first",
+			chunks:     []string{`{"intent":"","de`, `mand":"你`, `好"}`},
+			wantPieces: []string{"", "你", "好"},
+			wantDemand: "你好",
+		},
+		{
+			name:       "text before object",
+			chunks:     []string{"```json\n", `{"intent": "", "demand": "好的"}`, "\n```"},
+			wantPieces: []string{"", "好的", ""},
+			wantDemand: "好的",
+		},
+		{
+			name:       "intent split",
+			chunks:     []string{`{"intent":"`, `","demand":"hi"}`},
+			wantPieces: []string{"", "hi"},
+			wantDemand: "hi",
+		},
+		{
+			name:       "escape split",
+			chunks:     []string{`{"intent":"","demand":"第一行\`, `n第二行\"引号\`, `"\\"}`},
+			wantPieces: []string{"第一行", "\n第二行\"引号", "\"\\"},
+			wantDemand: "第一行\n第二行\"引号\"\\",
+		},
+		{
+			name:       "unicode escape split",
+			chunks:     []string{`{"intent":"","demand":"caf\u00`, `e9"}`},
+			wantPieces: []string{"caf", "é"},
+			wantDemand: "café",
+		},
+		{
+			name:       "surrogate pair split",
+			chunks:     []string{`{"intent":"","demand":"笑\uD83D`, `\uDE`, `00!"}`},
+			wantPieces: []string{"笑", "", "😀!"},
+			wantDemand: "笑😀!",
+		},
+		{
+			name:       "demand before intent",
+			chunks:     []string{`{"demand":"你`, `好",`, `"intent":""}`},
+			wantPieces: []string{"", "", "你好"},
+			wantDemand: "你好",
+		},
+		{
+			name:       "intent suppresses demand",
+			chunks:     []string{`{"intent":"checkAutoStatus",`, `"demand":"查看`, `状态"}`},
+			wantPieces: []string{"", "", ""},
+		},
+		{
+			name:       "intent suppresses demand before it",
+			chunks:     []string{`{"demand":"查看状态",`, `"intent":"check`, `AutoStatus"}`},
+			wantPieces: []string{"", "", ""},
+		},
+		{
+			name:       "non string value stops parsing",
+			chunks:     []string{`{"intent":null,"demand":"你好"}`},
+			wantPieces: []string{""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			demand := &demandStream{}
+			pieces := make([]string, 0, len(tt.chunks))
+			for _, chunk := range tt.chunks {
+				pieces = append(pieces, demand.write(chunk))
+			}
+			if !reflect.DeepEqual(pieces, tt.wantPieces) {
+				t.Errorf("pieces = %q, want %q", pieces, tt.wantPieces)
+			}
+			if demand.demand != tt.wantDemand {
+				t.Errorf("demand = %q, want %q", demand.demand, tt.wantDemand)
+			}
+			if demand.streamed() != (tt.wantDemand != "") {
+				t.Errorf("streamed() = %v, want %v", demand.streamed(), tt.wantDemand != "")
+			}
+		})
+	}
+}
+
+func TestScanString(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string // 开引号之后的内容
+		wantValue    string
+		wantNext     int
+		wantComplete bool
+	}{
+		{"complete", `abc",`, "abc", 4, true},
+		{"incomplete", `abc`, "abc", 3, false},
+		{"escaped quote", `a\"b"`, `a"b`, 5, true},
+		{"trailing backslash", `ab\`, "ab", 2, false},
+		{"short unicode escape", `ab\u4f`, "ab", 2, false},
+		{"unicode escape", `\u4f60"`, "你", 7, true},
+		{"high surrogate only", `\uD83D\uDE`, "", 0, false},
+		{"surrogate pair", `\uD83D\uDE00"`, "😀", 13, true},
+		{"invalid escape", `a\x"`, "a", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, next, complete := scanString(tt.input, 0)
+			if value != tt.wantValue || next != tt.wantNext || complete != tt.wantComplete {
+				t.Errorf("scanString(%q) = %q, %d, %v, want %q, %d, %v", tt.input, value, next, complete, tt.wantValue, tt.wantNext, tt.wantComplete)
+			}
+		})
+	}
+}
+
+// truncatedProvider 流式返回 chunks 后连接中断
+type truncatedProvider struct {
+	chunks []string
+}
+
+func (p *truncatedProvider) Chat(ctx context.Context, req LLMRequest, onDelta func(string)) (LLMResponse, error) {
+	for _, chunk := range p.chunks {
+		onDelta(chunk)
+	}
+	return LLMResponse{}, errors.New("unexpected EOF")
+}
+
+func TestChatLLMStreamTruncated(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []string
+		wantDeltas []string
+		wantReply  string // 为空时不返回消息
+	}{
+		{
+			name:       "demand streamed",
+			chunks:     []string{`{"intent":"","demand":"你好，`, `请问`},
+			wantDeltas: []string{"你好，", "请问"},
+			wantReply:  "你好，请问",
+		},
+		{
+			name:   "nothing streamed",
+			chunks: []string{`{"intent":"","dem`},
+		},
+		{
+			name:   "intent not empty",
+			chunks: []string{`{"intent":"checkAutoStatus","demand":"查看`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t.TempDir())
+			manager.AddProvider(DefaultLLMProvider, &truncatedProvider{chunks: tt.chunks}, "gpt-4o")
+			deltas := make([]*TotalMessage, 0)
+			reply := manager.ChatLLM(userMessage("d1", "你好"), func(delta *TotalMessage) {
+				deltas = append(deltas, delta)
+			})
+
+			contents := make([]string, 0, len(deltas))
+			for _, delta := range deltas {
+				if delta.EventType != types.EventChatDelta {
+					t.Errorf("delta event = %d, want %d", delta.EventType, types.EventChatDelta)
+				}
+				contents = append(contents, delta.Messages.Content)
+			}
+			if len(contents) != len(tt.wantDeltas) || (len(contents) > 0 && !reflect.DeepEqual(contents, tt.wantDeltas)) {
+				t.Errorf("deltas = %q, want %q", contents, tt.wantDeltas)
+			}
+
+			if tt.wantReply == "" {
+				if reply != nil {
+					t.Errorf("ChatLLM() = %+v, want nil", reply)
+				}
+				return
+			}
+			if reply == nil {
+				t.Fatal("ChatLLM() = nil, want reply with the streamed demand")
+			}
+			if reply.EventType != types.EventChatDone || reply.Messages.Content != tt.wantReply {
+				t.Errorf("ChatLLM() = event %d content %q, want %d %q", reply.EventType, reply.Messages.Content, types.EventChatDone, tt.wantReply)
+			}
+			// 同一次回复的片段和结束消息 message_id 相同
+			for _, delta := range deltas {
+				if delta.MessageID != reply.MessageID {
+					t.Errorf("delta message_id = %s, want %s", delta.MessageID, reply.MessageID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/huihui4754/expertlib/experts"
+	"github.com/huihui4754/expertlib/types"
+)
+
+const (
+	clientBufferSize   = 64
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+	replyQuietWindow   = 2 * time.Second // 收到回复后多久没有新消息就认为本轮回复结束
+	adminPrefix        = "/admin"
+)
+
+type server struct {
+	expert     *experts.Expert
+	hub        *hub
+	ready      atomic.Bool
+	closing    chan struct{} // 关闭时结束持续推送的 sse 连接
+	upgrader   websocket.Upgrader
+	adminToken string // 为空时不提供 dialog 管理接口
+}
+
+func newServer(expertx *experts.Expert, h *hub, adminToken string) *server {
+	return &server{
+		expert:     expertx,
+		hub:        h,
+		adminToken: adminToken,
+		closing:    make(chan struct{}),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
+	}
+}
+
+func (s *server) routes(prefix string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("GET "+prefix+"/opendialog", s.requireReady(http.HandlerFunc(s.handleWebSocket)))
+	mux.Handle("POST "+prefix+"/dialogs/{id}/messages", s.requireReady(http.HandlerFunc(s.handlePostMessage)))
+	mux.Handle("GET "+prefix+"/dialogs/{id}/events", s.requireReady(http.HandlerFunc(s.handleEvents)))
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	if s.adminToken != "" {
+		mux.Handle(adminPrefix+"/", s.requireReady(s.requireAdminToken(s.expert.DialogAdminHandler(adminPrefix))))
+	}
+	return mux
+}
+
+// requireReady 专家完成初始化前和开始关闭后返回 503，初始化期间 dialog 存储等还在设置
+func (s *server) requireReady(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdminToken 管理接口需要 Authorization: Bearer <admin-token>
+func (s *server) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleWebSocket 每条用户消息的 dialog_id 都会订阅到当前连接，之后该 dialog 的回复都发给这个连接。
+// dialog 属于第一条消息的 user_id，没有 user_id 时属于当前连接，其他用户的消息会被拒绝
+func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("升级连接失败:", err)
+		return
+	}
+
+	c := newClient(clientBufferSize)
+	connOwner := "conn:" + uuid.New().String()
+	defer func() {
+		s.hub.unsubscribeAll(c)
+		c.close()
+		conn.Close()
+	}()
+
+	go func() {
+		for {
+			select {
+			case data := <-c.send:
+				if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+					logger.Error("发送消息失败:", err)
+					conn.Close()
+					return
+				}
+			case <-c.done:
+				// 连接处理太慢被 hub 断开时结束读循环
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	subscribed := make(map[string]bool)
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			logger.Debug("websocket 连接关闭:", err)
+			return
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var message types.TotalMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			logger.Errorf("无法解析 websocket 消息: %v", err)
+			continue
+		}
+		if message.DialogID == "" {
+			logger.Error("websocket 消息缺少 dialog_id")
+			continue
+		}
+		owner := message.UserId
+		if owner == "" {
+			owner = connOwner
+		}
+		if !s.hub.claim(message.DialogID, owner) {
+			logger.Warnf("dialog %s 属于其他用户，拒绝 websocket 消息", message.DialogID)
+			continue
+		}
+		if !subscribed[message.DialogID] {
+			s.hub.subscribe(message.DialogID, c)
+			subscribed[message.DialogID] = true
+		}
+		if message.UserAgent == "" {
+			message.UserAgent = r.UserAgent()
+		}
+		if err := s.expert.HandleUserRequestMessage(message); err != nil {
+			logger.Warnf("dialog %s 消息未被接收: %v", message.DialogID, err)
+		}
+	}
+}
+
+// postMessageRequest POST /dialogs/{id}/messages 的请求体
+type postMessageRequest struct {
+	EventType   int                `json:"event_type,omitempty"` // 默认为 1001，传 1002 终止对话
+	UserID      string             `json:"user_id"`
+	MessageID   string             `json:"message_id,omitempty"`
+	Platform    string             `json:"platform,omitempty"` // 客户端平台，例如 lark、web
+	Content     string             `json:"content"`
+	Attachments []types.Attachment `json:"attachments,omitempty"`
+}
+
+// handlePostMessage 提交一条用户消息，并等待本轮回复。dialog 属于第一个使用它的 user_id，其他用户返回 403。
+// Accept: text/event-stream 时以 sse 推送每条回复，否则长轮询返回收集到的回复。
+// 可以通过 ?timeout=30s 设置等待第一条回复的最长时间。
+func (s *server) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	dialogID := r.PathValue("id")
+	var req postMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if !s.hub.claim(dialogID, req.UserID) {
+		http.Error(w, "dialog belongs to another user", http.StatusForbidden)
+		return
+	}
+	if req.EventType == 0 {
+		req.EventType = types.EventUserMessage
+	}
+	if req.MessageID == "" {
+		req.MessageID = uuid.New().String()
+	}
+	timeout, err := parseWaitTimeout(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message := types.TotalMessage{
+		EventType: req.EventType,
+		DialogID:  dialogID,
+		UserId:    req.UserID,
+		MessageID: req.MessageID,
+		Platform:  req.Platform,
+		UserAgent: r.UserAgent(),
+	}
+	message.Messages.Content = req.Content
+	message.Messages.Attachments = req.Attachments
+
+	// 先订阅再提交，避免错过很快返回的回复
+	c := newClient(clientBufferSize)
+	s.hub.subscribe(dialogID, c)
+	defer func() {
+		s.hub.unsubscribe(dialogID, c)
+		c.close()
+	}()
+	if err := s.expert.HandleUserRequestMessage(message); err != nil {
+		// 队列已满或专家正在关闭，让客户端稍后重试
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if req.EventType == types.EventClientTerminate {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if r.Header.Get("Accept") == "text/event-stream" {
+		s.streamReplies(w, r, c, timeout, true)
+		return
+	}
+
+	replies := make([]json.RawMessage, 0)
+	waitTimer := time.NewTimer(timeout)
+	defer waitTimer.Stop()
+	for {
+		select {
+		case data := <-c.send:
+			if isChatDelta(data) {
+				// 非流式请求不返回增量片段，完整的回复在 2007 中
+				waitTimer.Reset(replyQuietWindow)
+				continue
+			}
+			replies = append(replies, json.RawMessage(data))
+			if isTurnEnd(data) {
+				writeReplies(w, replies)
+				return
+			}
+			waitTimer.Reset(replyQuietWindow)
+		case <-waitTimer.C:
+			writeReplies(w, replies)
+			return
+		case <-c.done:
+			writeReplies(w, replies)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleEvents 以 sse 持续推送某个 dialog 的所有回复，直到客户端断开。
+// 需要通过 ?user_id= 传入 dialog 所属的用户（浏览器的 EventSource 不能设置请求头）
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	dialogID := r.PathValue("id")
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if !s.hub.claim(dialogID, userID) {
+		http.Error(w, "dialog belongs to another user", http.StatusForbidden)
+		return
+	}
+	c := newClient(clientBufferSize)
+	s.hub.subscribe(dialogID, c)
+	defer func() {
+		s.hub.unsubscribe(dialogID, c)
+		c.close()
+	}()
+	s.streamReplies(w, r, c, 0, false)
+}
+
+// streamReplies 以 sse 格式写出回复，untilTurnEnd 为 true 时本轮回复结束后返回
+func (s *server) streamReplies(w http.ResponseWriter, r *http.Request, c *client, timeout time.Duration, untilTurnEnd bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var waitC <-chan time.Time
+	var waitTimer *time.Timer
+	if untilTurnEnd {
+		waitTimer = time.NewTimer(timeout)
+		defer waitTimer.Stop()
+		waitC = waitTimer.C
+	}
+
+	for {
+		select {
+		case data := <-c.send:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+			if untilTurnEnd {
+				if isTurnEnd(data) {
+					return
+				}
+				waitTimer.Reset(replyQuietWindow)
+			}
+		case <-waitC:
+			return
+		case <-c.done:
+			return
+		case <-s.closing:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// beginShutdown 标记服务不再就绪并结束持续推送的连接
+func (s *server) beginShutdown() {
+	s.ready.Store(false)
+	close(s.closing)
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// handleReady 模块启动完成且没有在关闭时返回 200
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready"))
+}
+
+func parseWaitTimeout(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("timeout")
+	if value == "" {
+		return defaultWaitTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid timeout %q", value)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout <= 0 || timeout > maxWaitTimeout {
+		return 0, fmt.Errorf("timeout must be between 0 and %s", maxWaitTimeout)
+	}
+	return timeout, nil
+}
+
+// isTurnEnd 程序结束、交还前台、找不到程序或多轮对话流式回复结束时本轮回复不会再有新消息
+func isTurnEnd(data []byte) bool {
+	var message types.TotalMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return false
+	}
+	switch message.EventType {
+	case types.EventToolFinish, types.EventToolNotFound, types.EventToolBackground:
+		return !message.Background
+	case types.EventChatDone:
+		return true
+	}
+	return false
+}
+
+// isChatDelta 多轮对话流式回复的增量片段
+func isChatDelta(data []byte) bool {
+	var message types.TotalMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return false
+	}
+	return message.EventType == types.EventChatDelta
+}
+
+func writeReplies(w http.ResponseWriter, replies []json.RawMessage) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"messages": replies,
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/huihui4754/expertlib/experts"
+)
+
+func TestRequireReady(t *testing.T) {
+	srv := newServer(experts.NewExpert(), newHub(), "secret")
+	mux := srv.routes("/api")
+	tests := []struct {
+		name   string
+		ready  bool
+		method string
+		path   string
+		want   int
+	}{
+		{"healthz while starting", false, http.MethodGet, "/healthz", http.StatusOK},
+		{"readyz while starting", false, http.MethodGet, "/readyz", http.StatusServiceUnavailable},
+		{"events while starting", false, http.MethodGet, "/api/dialogs/d1/events", http.StatusServiceUnavailable},
+		{"admin while starting", false, http.MethodGet, "/admin/dialogs", http.StatusServiceUnavailable},
+		{"readyz when ready", true, http.MethodGet, "/readyz", http.StatusOK},
+		{"events when ready", true, http.MethodGet, "/api/dialogs/d1/events", http.StatusBadRequest},
+		{"admin when ready", true, http.MethodGet, "/admin/dialogs", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.ready.Store(tt.ready)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
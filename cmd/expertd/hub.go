@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+// client 一个接收回复的连接（websocket、sse 或一次长轮询），可以同时订阅多个 dialog
+type client struct {
+	send chan []byte
+	once sync.Once
+	done chan struct{}
+}
+
+func newClient(buffer int) *client {
+	return &client{
+		send: make(chan []byte, buffer),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+// dialogOwner dialog 的归属和最后一次使用的时间
+type dialogOwner struct {
+	owner    string
+	lastSeen time.Time
+}
+
+// hub 按 dialog_id 把专家返回给用户的消息路由到订阅了该 dialog 的连接
+type hub struct {
+	mu     sync.RWMutex
+	subs   map[string]map[*client]struct{}
+	owners map[string]*dialogOwner // dialog_id 对应第一个使用它的用户，只有该用户可以发送消息和订阅回复，dialog 删除或空闲超时后解除
+}
+
+func newHub() *hub {
+	return &hub{
+		subs:   make(map[string]map[*client]struct{}),
+		owners: make(map[string]*dialogOwner),
+	}
+}
+
+// claim dialog 没有归属时绑定到 owner，返回 owner 是否可以使用该 dialog，可以使用时刷新最后使用时间
+func (h *hub) claim(dialogID string, owner string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	current, ok := h.owners[dialogID]
+	if !ok {
+		h.owners[dialogID] = &dialogOwner{owner: owner, lastSeen: time.Now()}
+		return true
+	}
+	if current.owner != owner {
+		return false
+	}
+	current.lastSeen = time.Now()
+	return true
+}
+
+// release 解除 dialog 的归属，作为 Expert.SetDialogDeletedHandler 的处理函数
+func (h *hub) release(dialogID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.owners, dialogID)
+}
+
+// pruneOwners 解除超过 idle 没有使用且没有连接订阅的 dialog 的归属
+func (h *hub) pruneOwners(idle time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for dialogID, current := range h.owners {
+		if _, subscribed := h.subs[dialogID]; subscribed {
+			continue
+		}
+		if time.Since(current.lastSeen) >= idle {
+			delete(h.owners, dialogID)
+		}
+	}
+}
+
+// periodicPruneOwners 每隔 idle 的一半清理一次空闲的归属，stop 关闭后退出
+func (h *hub) periodicPruneOwners(idle time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.pruneOwners(idle)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (h *hub) subscribe(dialogID string, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients, ok := h.subs[dialogID]
+	if !ok {
+		clients = make(map[*client]struct{})
+		h.subs[dialogID] = clients
+	}
+	clients[c] = struct{}{}
+}
+
+func (h *hub) unsubscribe(dialogID string, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients, ok := h.subs[dialogID]
+	if !ok {
+		return
+	}
+	delete(clients, c)
+	if len(clients) == 0 {
+		delete(h.subs, dialogID)
+	}
+}
+
+// unsubscribeAll 连接断开时取消它的所有订阅
+func (h *hub) unsubscribeAll(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for dialogID, clients := range h.subs {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.subs, dialogID)
+		}
+	}
+}
+
+// publish 作为 Expert.SetToUserMessageHandler 的处理函数，连接处理不过来时断开该连接而不是阻塞专家，
+// 避免客户端在不知情的情况下漏掉回复
+func (h *hub) publish(message types.TotalMessage, raw string) {
+	h.mu.RLock()
+	clients := h.subs[message.DialogID]
+	if len(clients) == 0 {
+		h.mu.RUnlock()
+		logger.Warnf("No connection for dialog %s, drop message event: %d", message.DialogID, message.EventType)
+		return
+	}
+	slow := make([]*client, 0)
+	for c := range clients {
+		select {
+		case c.send <- []byte(raw):
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range slow {
+		logger.Warnf("Connection buffer full for dialog %s, close slow connection, event: %d", message.DialogID, message.EventType)
+		h.unsubscribeAll(c)
+		c.close()
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+func TestHubClaim(t *testing.T) {
+	h := newHub()
+	if !h.claim("d1", "alice") {
+		t.Fatal("claim() of a new dialog = false, want true")
+	}
+	if !h.claim("d1", "alice") {
+		t.Error("claim() by the owner = false, want true")
+	}
+	if h.claim("d1", "bob") {
+		t.Error("claim() by another user = true, want false")
+	}
+	if !h.claim("d2", "bob") {
+		t.Error("claim() of another dialog = false, want true")
+	}
+}
+
+func TestHubPublishClosesSlowClient(t *testing.T) {
+	h := newHub()
+	fast := newClient(2)
+	slow := newClient(1)
+	h.subscribe("d1", fast)
+	h.subscribe("d1", slow)
+
+	message := types.TotalMessage{DialogID: "d1", EventType: types.EventServerMessage}
+	h.publish(message, "first")
+	<-fast.send
+	h.publish(message, "second")
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("slow client not closed after its buffer was full")
+	}
+	select {
+	case <-fast.done:
+		t.Fatal("fast client closed")
+	default:
+	}
+	if data := <-fast.send; string(data) != "second" {
+		t.Errorf("fast client got %q, want second", data)
+	}
+
+	h.mu.RLock()
+	_, subscribed := h.subs["d1"][slow]
+	h.mu.RUnlock()
+	if subscribed {
+		t.Error("slow client still subscribed")
+	}
+}
+
+func TestHubReleaseOwners(t *testing.T) {
+	h := newHub()
+	h.claim("d1", "alice")
+	h.release("d1")
+	if !h.claim("d1", "bob") {
+		t.Error("claim() after release = false, want true")
+	}
+
+	h.claim("idle", "alice")
+	h.claim("active", "alice")
+	h.claim("subscribed", "alice")
+	h.subscribe("subscribed", newClient(1))
+	h.mu.Lock()
+	h.owners["idle"].lastSeen = time.Now().Add(-2 * time.Hour)
+	h.owners["subscribed"].lastSeen = time.Now().Add(-2 * time.Hour)
+	h.mu.Unlock()
+
+	h.pruneOwners(time.Hour)
+
+	tests := []struct {
+		dialogID string
+		owned    bool
+	}{
+		{"idle", false},
+		{"active", true},
+		{"subscribed", true},
+	}
+	for _, tt := range tests {
+		h.mu.RLock()
+		_, owned := h.owners[tt.dialogID]
+		h.mu.RUnlock()
+		if owned != tt.owned {
+			t.Errorf("dialog %s owned = %v, want %v", tt.dialogID, owned, tt.owned)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// expertd 将专家、多轮对话和程序库组装成一个服务，通过 websocket 和 http 对外提供对话接口。
+//
+//	expertd -config config.yaml -addr 0.0.0.0:8085 -prefix /api
+//
+// 接口：
+//
+//	GET  /api/opendialog              websocket，发送 TotalMessage，按 dialog_id 收到回复
+//	POST /api/dialogs/{id}/messages   提交用户消息，长轮询返回回复，Accept: text/event-stream 时以 sse 推送
+//	GET  /api/dialogs/{id}/events     sse 持续推送该 dialog 的所有回复，需要 ?user_id=
+//	GET  /healthz                     存活检查
+//	GET  /readyz                      就绪检查，专家加载完模型和 dialog 存储后返回 200，在这之前对话和管理接口返回 503
+//	/admin/dialogs...                 设置 -admin-token 后提供 dialog 管理接口，见 experts.Expert.DialogAdminHandler
+//
+// dialog 属于第一个使用它的 user_id（websocket 消息没有 user_id 时属于该连接），其他用户不能发送消息和订阅回复。
+// dialog 被删除或者超过 -owner-idle 没有使用且没有连接订阅时解除归属。
+// 这只是防止不同用户误用同一个 dialog_id，不是身份认证：user_id 由客户端自己填写，知道 user_id 的人就能读取
+// 该用户的回复，需要认证时应该部署在校验身份并填写 user_id 的网关之后。
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/huihui4754/expertlib/config"
+	"github.com/huihui4754/loglevel"
+)
+
+var (
+	logger = loglevel.NewLog(loglevel.Debug)
+)
+
+func main() {
+	configPath := flag.String("config", "", "配置文件路径（yaml/toml/json），为空时只读取 EXPERTLIB_ 环境变量")
+	listenAddr := flag.String("addr", "0.0.0.0:8085", "http 监听地址")
+	serverPrefix := flag.String("prefix", "/api", "对话接口路径前缀")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "关闭时等待处理中消息的最长时间")
+	ownerIdle := flag.Duration("owner-idle", 24*time.Hour, "dialog 多久没有使用后解除和用户的绑定，0 为只在 dialog 删除时解除")
+	adminToken := flag.String("admin-token", os.Getenv(config.EnvPrefix+"ADMIN_TOKEN"), "dialog 管理接口的 Bearer token，为空时不提供管理接口，默认读取 EXPERTLIB_ADMIN_TOKEN")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.LogLevel != "" {
+		level, _ := cfg.Level()
+		logger.SetLevel(level)
+	}
+	pipeline, err := config.Build(cfg)
+	if err != nil {
+		logger.Fatalf("Failed to build pipeline: %v", err)
+	}
+
+	h := newHub()
+	pipeline.Expert.SetToUserMessageHandler(h.publish)
+	pipeline.Expert.SetDialogDeletedHandler(h.release)
+
+	srv := newServer(pipeline.Expert, h, *adminToken)
+	if *ownerIdle > 0 {
+		go h.periodicPruneOwners(*ownerIdle, srv.closing)
+	}
+	httpServer := &http.Server{
+		Addr:    *listenAddr,
+		Handler: srv.routes(*serverPrefix),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 先绑定端口，启动期间 /healthz 可以访问，对话和管理接口在就绪前返回 503
+	listener, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		logger.Fatalf("Failed to listen on %s: %v", *listenAddr, err)
+	}
+	go func() {
+		logger.Infof("expertd listening on %s", listener.Addr())
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	pipeline.Start()
+	select {
+	case <-pipeline.Expert.Ready():
+		srv.ready.Store(true)
+		logger.Info("expertd ready")
+		<-ctx.Done()
+	case <-ctx.Done():
+	}
+
+	logger.Info("expertd shutting down...")
+	srv.beginShutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Warnf("http server shutdown: %v", err)
+	}
+	if err := pipeline.Shutdown(shutdownCtx); err != nil {
+		logger.Warnf("pipeline shutdown: %v", err)
+	}
+	logger.Info("expertd stopped")
+}
@@ -0,0 +1,48 @@
+// exporttrain 从专家的数据目录导出 rnn 意图识别模型的训练数据。
+//
+//	exporttrain -data ~/expert/dialog -out ./trainingdata [-jieba-user-dict user.dict.utf8]
+//
+// 每个意图生成 <out>/<意图名称>/positive.jsonl、negative.jsonl 和 vocab_rnn.json，
+// 需要专家开启 SetRecordTrainingSamples 记录程序库处理结果。专家设置了 jieba 自定义词典时需要用 -jieba-user-dict
+// 指定同一个词典，分词结果才和线上一致。
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/huihui4754/expertlib/experts"
+	"github.com/huihui4754/loglevel"
+)
+
+var (
+	logger = loglevel.NewLog(loglevel.Info)
+)
+
+func main() {
+	dataDir := flag.String("data", "", "专家的数据目录，即 SetDataFilePath 设置的路径")
+	outDir := flag.String("out", "trainingdata", "导出目录")
+	jiebaUserDict := flag.String("jieba-user-dict", "", "jieba 自定义词典路径，和专家的 SetJiebaUserDict（配置 expert.jieba_user_dict）相同")
+	flag.Parse()
+
+	if *dataDir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	experts.SetLogger(loglevel.Info)
+	if *jiebaUserDict != "" {
+		if _, err := os.Stat(*jiebaUserDict); err != nil {
+			logger.Fatalf("Jieba user dict not available: %v", err)
+		}
+		experts.SetJiebaUserDict(*jiebaUserDict)
+	}
+
+	report, err := experts.ExportTrainingData(*dataDir, *outDir)
+	if err != nil {
+		logger.Fatalf("Failed to export training data: %v", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
@@ -0,0 +1,367 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/huihui4754/expertlib/experts"
+	"github.com/huihui4754/loglevel"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix 环境变量覆盖配置时使用的前缀，例如 EXPERTLIB_CHAT_LLM_URL
+const EnvPrefix = "EXPERTLIB_"
+
+// Duration 支持在配置文件中使用 "30s"、"10m" 这种写法的时间间隔
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(strings.TrimSpace(string(text)))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", string(text), err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Config 整个处理流程（专家、多轮对话、程序库）的配置，零值字段表示沿用各模块的默认值
+type Config struct {
+	LogLevel string        `json:"log_level" yaml:"log_level" toml:"log_level"` // debug, info, warn, error
+	Expert   ExpertConfig  `json:"expert" yaml:"expert" toml:"expert"`
+	Chat     ChatConfig    `json:"chat" yaml:"chat" toml:"chat"`
+	Program  ProgramConfig `json:"program" yaml:"program" toml:"program"`
+}
+
+// ExpertConfig 专家模块配置
+type ExpertConfig struct {
+	DataPath         string   `json:"data_path" yaml:"data_path" toml:"data_path"`                            // dialog 信息和意图缓存保存目录
+	RNNModelPath     string   `json:"rnn_model_path" yaml:"rnn_model_path" toml:"rnn_model_path"`             // 本地 rnn 意图识别模型目录
+	ONNXLibPath      string   `json:"onnx_lib_path" yaml:"onnx_lib_path" toml:"onnx_lib_path"`                // onnxruntime 动态库路径
+	WatchRNNModel    bool     `json:"watch_rnn_model" yaml:"watch_rnn_model" toml:"watch_rnn_model"`          // 监听 rnn 模型目录自动重新加载
+	JiebaUserDict    string   `json:"jieba_user_dict" yaml:"jieba_user_dict" toml:"jieba_user_dict"`          // rnn 意图分词使用的 jieba 自定义词典
+	RNNConcurrency   int      `json:"rnn_concurrency" yaml:"rnn_concurrency" toml:"rnn_concurrency"`          // rnn 意图同时推理的数量上限
+	CommandFirst     bool     `json:"command_first" yaml:"command_first" toml:"command_first"`                // 多轮对话中命令优先
+	SaveInterval     Duration `json:"save_interval" yaml:"save_interval" toml:"save_interval"`                // 保存dialog 信息和意图缓存的间隔
+	ChatHistoryLimit int      `json:"chat_history_limit" yaml:"chat_history_limit" toml:"chat_history_limit"` // 每个dialog 保存的历史消息条数
+
+	IntentMinScore   float64            `json:"intent_min_score" yaml:"intent_min_score" toml:"intent_min_score"`                         // 意图匹配的全局最低分数
+	IntentMinMargin  float64            `json:"intent_min_margin" yaml:"intent_min_margin" toml:"intent_min_margin"`                      // 第一名和第二名意图之间的最小分差
+	IntentClarifyMin float64            `json:"intent_clarify_min_score" yaml:"intent_clarify_min_score" toml:"intent_clarify_min_score"` // 向用户确认意图的最低分数
+	IntentThresholds map[string]float64 `json:"intent_thresholds" yaml:"intent_thresholds" toml:"intent_thresholds"`                      // 每个意图单独的最低分数
+	IntentWeights    map[string]float64 `json:"intent_weights" yaml:"intent_weights" toml:"intent_weights"`                               // 每个意图的分数权重
+
+	IntentCacheMaxSize int      `json:"intent_cache_max_size" yaml:"intent_cache_max_size" toml:"intent_cache_max_size"` // 意图缓存最大条数
+	IntentCacheTTL     Duration `json:"intent_cache_ttl" yaml:"intent_cache_ttl" toml:"intent_cache_ttl"`                // 意图缓存有效期
+
+	IntentLearnAutoApprove float64 `json:"intent_learn_auto_approve" yaml:"intent_learn_auto_approve" toml:"intent_learn_auto_approve"` // 多轮对话学习到的意图自动通过审核的分数
+	RecordTrainingSamples  bool    `json:"record_training_samples" yaml:"record_training_samples" toml:"record_training_samples"`       // 记录程序库处理结果用于导出训练数据
+
+	RuleIntentPath string `json:"rule_intent_path" yaml:"rule_intent_path" toml:"rule_intent_path"` // 规则意图文件或目录
+
+	EmbeddingModelPath string                           `json:"embedding_model_path" yaml:"embedding_model_path" toml:"embedding_model_path"` // 句向量模型目录
+	EmbeddingIntents   map[string]EmbeddingIntentConfig `json:"embedding_intents" yaml:"embedding_intents" toml:"embedding_intents"`          // 用示例话术定义的意图，意图名称到描述和示例的映射
+
+	DialogStore       DialogStoreConfig `json:"dialog_store" yaml:"dialog_store" toml:"dialog_store"`                      // dialog 状态存储
+	DialogTTL         Duration          `json:"dialog_ttl" yaml:"dialog_ttl" toml:"dialog_ttl"`                            // dialog 在存储中的有效期
+	DialogIdleTimeout Duration          `json:"dialog_idle_timeout" yaml:"dialog_idle_timeout" toml:"dialog_idle_timeout"` // dialog 空闲多久后移出内存
+	DialogResetAfter  Duration          `json:"dialog_reset_after" yaml:"dialog_reset_after" toml:"dialog_reset_after"`    // dialog 空闲多久后重置
+	DialogDeleteAfter Duration          `json:"dialog_delete_after" yaml:"dialog_delete_after" toml:"dialog_delete_after"` // dialog 空闲多久后删除
+
+	DispatchShards    int `json:"dispatch_shards" yaml:"dispatch_shards" toml:"dispatch_shards"`             // 消息队列分片数量
+	DispatchQueueSize int `json:"dispatch_queue_size" yaml:"dispatch_queue_size" toml:"dispatch_queue_size"` // 每个分片的队列长度
+
+	Fallback          FallbackConfig            `json:"fallback" yaml:"fallback" toml:"fallback"`                               // 识别不到意图时的默认处理策略
+	PlatformFallbacks map[string]FallbackConfig `json:"platform_fallbacks" yaml:"platform_fallbacks" toml:"platform_fallbacks"` // 每个平台单独的处理策略，平台名称到策略的映射
+}
+
+// FallbackConfig 识别不到意图时的处理策略
+type FallbackConfig struct {
+	Type    string `json:"type" yaml:"type" toml:"type"`          // chat（默认）、program、reply、human
+	Program string `json:"program" yaml:"program" toml:"program"` // program 类型交给的程序库
+	Reply   string `json:"reply" yaml:"reply" toml:"reply"`       // reply 类型回复的话，也用于对应模块没有设置时
+}
+
+// Policy 转换为 experts.FallbackPolicy
+func (f FallbackConfig) Policy() experts.FallbackPolicy {
+	return experts.FallbackPolicy{Type: f.Type, Program: f.Program, Reply: f.Reply}
+}
+
+// DialogStoreConfig dialog 状态存储配置
+type DialogStoreConfig struct {
+	Type     string `json:"type" yaml:"type" toml:"type"`             // file（默认）、sqlite、redis
+	Path     string `json:"path" yaml:"path" toml:"path"`             // file 为目录，sqlite 为数据库文件，为空时保存在 expert.data_path 中
+	Addr     string `json:"addr" yaml:"addr" toml:"addr"`             // redis 地址 host:port
+	Username string `json:"username" yaml:"username" toml:"username"` // redis 用户名
+	Password string `json:"password" yaml:"password" toml:"password"` // redis 密码
+	DB       int    `json:"db" yaml:"db" toml:"db"`                   // redis 数据库编号
+	Prefix   string `json:"prefix" yaml:"prefix" toml:"prefix"`       // redis key 前缀，默认 expertlib:dialog:
+}
+
+// EmbeddingIntentConfig 一个 embedding 意图的描述和示例话术
+type EmbeddingIntentConfig struct {
+	Description string   `json:"description" yaml:"description" toml:"description"`
+	Examples    []string `json:"examples" yaml:"examples" toml:"examples"`
+}
+
+// ChatConfig 多轮对话模块配置
+type ChatConfig struct {
+	DataPath     string   `json:"data_path" yaml:"data_path" toml:"data_path"`
+	LLMURL       string   `json:"llm_url" yaml:"llm_url" toml:"llm_url"`
+	Model        string   `json:"model" yaml:"model" toml:"model"`
+	SystemPrompt string   `json:"system_prompt" yaml:"system_prompt" toml:"system_prompt"`
+	SaveInterval Duration `json:"save_interval" yaml:"save_interval" toml:"save_interval"`
+	Stream       bool     `json:"stream" yaml:"stream" toml:"stream"`                         // 以 2006 增量片段和 2007 完整回复流式回复用户
+	MaxToolSteps int      `json:"max_tool_steps" yaml:"max_tool_steps" toml:"max_tool_steps"` // 每条消息最多调用工具的轮数，默认 5
+	Provider     string   `json:"provider" yaml:"provider" toml:"provider"`                   // 默认使用的大模型接口名称，为空时使用 llm_url
+	// Providers 可供选择的大模型接口，名称到接口配置的映射
+	Providers map[string]LLMProviderConfig `json:"providers" yaml:"providers" toml:"providers"`
+}
+
+// LLMProviderConfig 大模型接口配置
+type LLMProviderConfig struct {
+	Type      string `json:"type" yaml:"type" toml:"type"`                   // openai（默认）、ollama、anthropic、fake
+	URL       string `json:"url" yaml:"url" toml:"url"`                      // 接口链接，fake 类型不需要
+	APIKey    string `json:"api_key" yaml:"api_key" toml:"api_key"`          // openai 为空时使用环境变量 OPENAI_API_KEY
+	Model     string `json:"model" yaml:"model" toml:"model"`                // 默认模型，为空时使用 chat.model
+	MaxTokens int    `json:"max_tokens" yaml:"max_tokens" toml:"max_tokens"` // anthropic 每次回复的最大 token 数，默认 4096
+}
+
+// ProgramConfig 程序库模块配置
+type ProgramConfig struct {
+	DataPath        string   `json:"data_path" yaml:"data_path" toml:"data_path"`
+	ProgramPath     string   `json:"program_path" yaml:"program_path" toml:"program_path"` // 本地 js 程序库目录
+	SaveInterval    Duration `json:"save_interval" yaml:"save_interval" toml:"save_interval"`
+	StoragePort     string   `json:"storage_port" yaml:"storage_port" toml:"storage_port"`             // 给 nodejs 程序提供数据存储的 http 端口
+	StorageDisabled bool     `json:"storage_disabled" yaml:"storage_disabled" toml:"storage_disabled"` // 不启动数据存储 http 服务
+	IdleTimeout     Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout"`             // nodejs 程序空闲多久后关闭
+}
+
+// Load 读取配置文件，根据扩展名（.yaml .yml .toml .json）选择格式，然后应用环境变量覆盖。
+// path 为空时只使用环境变量。
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+		}
+		if err := Unmarshal(filepath.Ext(path), data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file '%s': %w", path, err)
+		}
+	}
+	if err := cfg.ApplyEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Unmarshal 按扩展名解析配置内容
+func Unmarshal(ext string, data []byte, cfg *Config) error {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "yaml", "yml":
+		return yaml.Unmarshal(data, cfg)
+	case "toml":
+		return toml.Unmarshal(data, cfg)
+	case "json":
+		return json.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config format %q", ext)
+	}
+}
+
+// envBinding 环境变量名和对应的配置字段
+type envBinding struct {
+	name  string
+	apply func(c *Config, value string) error
+}
+
+func stringEnv(field func(c *Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func boolEnv(field func(c *Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func intEnv(field func(c *Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func floatEnv(field func(c *Config) *float64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func durationEnv(field func(c *Config) *Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		return field(c).UnmarshalText([]byte(value))
+	}
+}
+
+var envBindings = []envBinding{
+	{"LOG_LEVEL", stringEnv(func(c *Config) *string { return &c.LogLevel })},
+
+	{"EXPERT_DATA_PATH", stringEnv(func(c *Config) *string { return &c.Expert.DataPath })},
+	{"EXPERT_RNN_MODEL_PATH", stringEnv(func(c *Config) *string { return &c.Expert.RNNModelPath })},
+	{"EXPERT_ONNX_LIB_PATH", stringEnv(func(c *Config) *string { return &c.Expert.ONNXLibPath })},
+	{"EXPERT_WATCH_RNN_MODEL", boolEnv(func(c *Config) *bool { return &c.Expert.WatchRNNModel })},
+	{"EXPERT_JIEBA_USER_DICT", stringEnv(func(c *Config) *string { return &c.Expert.JiebaUserDict })},
+	{"EXPERT_RNN_CONCURRENCY", intEnv(func(c *Config) *int { return &c.Expert.RNNConcurrency })},
+	{"EXPERT_COMMAND_FIRST", boolEnv(func(c *Config) *bool { return &c.Expert.CommandFirst })},
+	{"EXPERT_SAVE_INTERVAL", durationEnv(func(c *Config) *Duration { return &c.Expert.SaveInterval })},
+	{"EXPERT_CHAT_HISTORY_LIMIT", intEnv(func(c *Config) *int { return &c.Expert.ChatHistoryLimit })},
+	{"EXPERT_INTENT_MIN_SCORE", floatEnv(func(c *Config) *float64 { return &c.Expert.IntentMinScore })},
+	{"EXPERT_INTENT_MIN_MARGIN", floatEnv(func(c *Config) *float64 { return &c.Expert.IntentMinMargin })},
+	{"EXPERT_INTENT_CLARIFY_MIN_SCORE", floatEnv(func(c *Config) *float64 { return &c.Expert.IntentClarifyMin })},
+	{"EXPERT_INTENT_CACHE_MAX_SIZE", intEnv(func(c *Config) *int { return &c.Expert.IntentCacheMaxSize })},
+	{"EXPERT_INTENT_CACHE_TTL", durationEnv(func(c *Config) *Duration { return &c.Expert.IntentCacheTTL })},
+	{"EXPERT_INTENT_LEARN_AUTO_APPROVE", floatEnv(func(c *Config) *float64 { return &c.Expert.IntentLearnAutoApprove })},
+	{"EXPERT_RECORD_TRAINING_SAMPLES", boolEnv(func(c *Config) *bool { return &c.Expert.RecordTrainingSamples })},
+	{"EXPERT_RULE_INTENT_PATH", stringEnv(func(c *Config) *string { return &c.Expert.RuleIntentPath })},
+	{"EXPERT_EMBEDDING_MODEL_PATH", stringEnv(func(c *Config) *string { return &c.Expert.EmbeddingModelPath })},
+	{"EXPERT_DIALOG_STORE_TYPE", stringEnv(func(c *Config) *string { return &c.Expert.DialogStore.Type })},
+	{"EXPERT_DIALOG_STORE_PATH", stringEnv(func(c *Config) *string { return &c.Expert.DialogStore.Path })},
+	{"EXPERT_DIALOG_STORE_ADDR", stringEnv(func(c *Config) *string { return &c.Expert.DialogStore.Addr })},
+	{"EXPERT_DIALOG_STORE_USERNAME", stringEnv(func(c *Config) *string { return &c.Expert.DialogStore.Username })},
+	{"EXPERT_DIALOG_STORE_PASSWORD", stringEnv(func(c *Config) *string { return &c.Expert.DialogStore.Password })},
+	{"EXPERT_DIALOG_STORE_DB", intEnv(func(c *Config) *int { return &c.Expert.DialogStore.DB })},
+	{"EXPERT_DIALOG_STORE_PREFIX", stringEnv(func(c *Config) *string { return &c.Expert.DialogStore.Prefix })},
+	{"EXPERT_DIALOG_TTL", durationEnv(func(c *Config) *Duration { return &c.Expert.DialogTTL })},
+	{"EXPERT_DIALOG_IDLE_TIMEOUT", durationEnv(func(c *Config) *Duration { return &c.Expert.DialogIdleTimeout })},
+	{"EXPERT_DIALOG_RESET_AFTER", durationEnv(func(c *Config) *Duration { return &c.Expert.DialogResetAfter })},
+	{"EXPERT_DIALOG_DELETE_AFTER", durationEnv(func(c *Config) *Duration { return &c.Expert.DialogDeleteAfter })},
+	{"EXPERT_DISPATCH_SHARDS", intEnv(func(c *Config) *int { return &c.Expert.DispatchShards })},
+	{"EXPERT_DISPATCH_QUEUE_SIZE", intEnv(func(c *Config) *int { return &c.Expert.DispatchQueueSize })},
+	{"EXPERT_FALLBACK_TYPE", stringEnv(func(c *Config) *string { return &c.Expert.Fallback.Type })},
+	{"EXPERT_FALLBACK_PROGRAM", stringEnv(func(c *Config) *string { return &c.Expert.Fallback.Program })},
+	{"EXPERT_FALLBACK_REPLY", stringEnv(func(c *Config) *string { return &c.Expert.Fallback.Reply })},
+
+	{"CHAT_DATA_PATH", stringEnv(func(c *Config) *string { return &c.Chat.DataPath })},
+	{"CHAT_LLM_URL", stringEnv(func(c *Config) *string { return &c.Chat.LLMURL })},
+	{"CHAT_MODEL", stringEnv(func(c *Config) *string { return &c.Chat.Model })},
+	{"CHAT_SYSTEM_PROMPT", stringEnv(func(c *Config) *string { return &c.Chat.SystemPrompt })},
+	{"CHAT_SAVE_INTERVAL", durationEnv(func(c *Config) *Duration { return &c.Chat.SaveInterval })},
+	{"CHAT_STREAM", boolEnv(func(c *Config) *bool { return &c.Chat.Stream })},
+	{"CHAT_MAX_TOOL_STEPS", intEnv(func(c *Config) *int { return &c.Chat.MaxToolSteps })},
+	{"CHAT_PROVIDER", stringEnv(func(c *Config) *string { return &c.Chat.Provider })},
+
+	{"PROGRAM_DATA_PATH", stringEnv(func(c *Config) *string { return &c.Program.DataPath })},
+	{"PROGRAM_PROGRAM_PATH", stringEnv(func(c *Config) *string { return &c.Program.ProgramPath })},
+	{"PROGRAM_SAVE_INTERVAL", durationEnv(func(c *Config) *Duration { return &c.Program.SaveInterval })},
+	{"PROGRAM_STORAGE_PORT", stringEnv(func(c *Config) *string { return &c.Program.StoragePort })},
+	{"PROGRAM_STORAGE_DISABLED", boolEnv(func(c *Config) *bool { return &c.Program.StorageDisabled })},
+	{"PROGRAM_IDLE_TIMEOUT", durationEnv(func(c *Config) *Duration { return &c.Program.IdleTimeout })},
+}
+
+// ApplyEnv 使用环境变量覆盖配置，getenv 通常传入 os.Getenv
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	for _, binding := range envBindings {
+		name := EnvPrefix + binding.name
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		if err := binding.apply(c, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate 检查启动流程必须的配置
+func (c *Config) Validate() error {
+	if c.Chat.Provider == "" {
+		if c.Chat.LLMURL == "" || c.Chat.Model == "" {
+			return fmt.Errorf("chat.llm_url and chat.model are required")
+		}
+	} else {
+		provider, ok := c.Chat.Providers[c.Chat.Provider]
+		if !ok {
+			return fmt.Errorf("chat.provider %q is not in chat.providers", c.Chat.Provider)
+		}
+		if provider.Model == "" && c.Chat.Model == "" {
+			return fmt.Errorf("chat.providers.%s.model or chat.model is required", c.Chat.Provider)
+		}
+	}
+	for name, provider := range c.Chat.Providers {
+		switch provider.Type {
+		case "", "openai", "ollama", "anthropic":
+			if provider.URL == "" {
+				return fmt.Errorf("chat.providers.%s.url is required", name)
+			}
+		case "fake":
+		default:
+			return fmt.Errorf("unknown chat.providers.%s.type %q", name, provider.Type)
+		}
+	}
+	if _, err := c.Level(); err != nil {
+		return err
+	}
+	switch c.Expert.DialogStore.Type {
+	case "", "file", "sqlite":
+	case "redis":
+		if c.Expert.DialogStore.Addr == "" {
+			return fmt.Errorf("expert.dialog_store.addr is required for redis dialog store")
+		}
+	default:
+		return fmt.Errorf("unknown expert.dialog_store.type %q", c.Expert.DialogStore.Type)
+	}
+	if err := c.Expert.Fallback.Policy().Validate(); err != nil {
+		return fmt.Errorf("expert.fallback: %w", err)
+	}
+	for platform, fallback := range c.Expert.PlatformFallbacks {
+		if err := fallback.Policy().Validate(); err != nil {
+			return fmt.Errorf("expert.platform_fallbacks.%s: %w", platform, err)
+		}
+	}
+	return nil
+}
+
+// Level 将配置中的日志级别转换为 loglevel.Level，为空时使用 Info
+func (c *Config) Level() (loglevel.Level, error) {
+	switch strings.ToLower(c.LogLevel) {
+	case "debug":
+		return loglevel.Debug, nil
+	case "", "info":
+		return loglevel.Info, nil
+	case "warn", "warning":
+		return loglevel.Warn, nil
+	case "error":
+		return loglevel.Error, nil
+	default:
+		return loglevel.Info, fmt.Errorf("unknown log level %q", c.LogLevel)
+	}
+}
@@ -0,0 +1,400 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const yamlConfig = `
+log_level: debug
+expert:
+  data_path: ./data/expert
+  chat_history_limit: 20
+  save_interval: 30s
+  intent_min_score: 0.5
+  dialog_store:
+    type: sqlite
+    path: ./data/dialogs.db
+  fallback:
+    type: reply
+    reply: 没听懂
+chat:
+  llm_url: http://127.0.0.1:8010/v1
+  model: qwen
+  stream: true
+  max_tool_steps: 3
+  provider: local
+  providers:
+    local:
+      type: ollama
+      url: http://127.0.0.1:11434
+      model: qwen2
+program:
+  program_path: ./programs
+  idle_timeout: 10m
+`
+
+const tomlConfig = `
+log_level = "debug"
+
+[expert]
+data_path = "./data/expert"
+chat_history_limit = 20
+save_interval = "30s"
+intent_min_score = 0.5
+
+[expert.dialog_store]
+type = "sqlite"
+path = "./data/dialogs.db"
+
+[expert.fallback]
+type = "reply"
+reply = "没听懂"
+
+[chat]
+llm_url = "http://127.0.0.1:8010/v1"
+model = "qwen"
+stream = true
+max_tool_steps = 3
+provider = "local"
+
+[chat.providers.local]
+type = "ollama"
+url = "http://127.0.0.1:11434"
+model = "qwen2"
+
+[program]
+program_path = "./programs"
+idle_timeout = "10m"
+`
+
+const jsonConfig = `{
+  "log_level": "debug",
+  "expert": {
+    "data_path": "./data/expert",
+    "chat_history_limit": 20,
+    "save_interval": "30s",
+    "intent_min_score": 0.5,
+    "dialog_store": {"type": "sqlite", "path": "./data/dialogs.db"},
+    "fallback": {"type": "reply", "reply": "没听懂"}
+  },
+  "chat": {
+    "llm_url": "http://127.0.0.1:8010/v1",
+    "model": "qwen",
+    "stream": true,
+    "max_tool_steps": 3,
+    "provider": "local",
+    "providers": {
+      "local": {"type": "ollama", "url": "http://127.0.0.1:11434", "model": "qwen2"}
+    }
+  },
+  "program": {
+    "program_path": "./programs",
+    "idle_timeout": "10m"
+  }
+}`
+
+// expectedConfig 三种格式的配置文件解析后应该得到的配置
+func expectedConfig() *Config {
+	return &Config{
+		LogLevel: "debug",
+		Expert: ExpertConfig{
+			DataPath:         "./data/expert",
+			ChatHistoryLimit: 20,
+			SaveInterval:     Duration(30 * time.Second),
+			IntentMinScore:   0.5,
+			DialogStore:      DialogStoreConfig{Type: "sqlite", Path: "./data/dialogs.db"},
+			Fallback:         FallbackConfig{Type: "reply", Reply: "没听懂"},
+		},
+		Chat: ChatConfig{
+			LLMURL:       "http://127.0.0.1:8010/v1",
+			Model:        "qwen",
+			Stream:       true,
+			MaxToolSteps: 3,
+			Provider:     "local",
+			Providers: map[string]LLMProviderConfig{
+				"local": {Type: "ollama", URL: "http://127.0.0.1:11434", Model: "qwen2"},
+			},
+		},
+		Program: ProgramConfig{
+			ProgramPath: "./programs",
+			IdleTimeout: Duration(10 * time.Minute),
+		},
+	}
+}
+
+// clearEnv 清除已经设置的 EXPERTLIB_ 环境变量，避免影响 Load
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, binding := range envBindings {
+		if _, ok := os.LookupEnv(EnvPrefix + binding.name); ok {
+			t.Setenv(EnvPrefix+binding.name, "")
+		}
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	clearEnv(t)
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"yaml", "config.yaml", yamlConfig},
+		{"yml", "config.yml", yamlConfig},
+		{"toml", "config.toml", tomlConfig},
+		{"json", "config.json", jsonConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if want := expectedConfig(); !reflect.DeepEqual(cfg, want) {
+				t.Errorf("Load() = %+v, want %+v", cfg, want)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadUnsupportedFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.ini")
+	if err := os.WriteFile(path, []byte("log_level=debug"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load() error = nil, want unsupported config format")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(c *Config) bool
+		wantErr bool
+	}{
+		{
+			name:  "string",
+			env:   map[string]string{"EXPERTLIB_CHAT_LLM_URL": "http://llm:8000/v1"},
+			check: func(c *Config) bool { return c.Chat.LLMURL == "http://llm:8000/v1" },
+		},
+		{
+			name: "nested string",
+			env:  map[string]string{"EXPERTLIB_EXPERT_DIALOG_STORE_TYPE": "redis", "EXPERTLIB_EXPERT_DIALOG_STORE_ADDR": "redis:6379"},
+			check: func(c *Config) bool {
+				return c.Expert.DialogStore.Type == "redis" && c.Expert.DialogStore.Addr == "redis:6379"
+			},
+		},
+		{
+			name:  "bool",
+			env:   map[string]string{"EXPERTLIB_CHAT_STREAM": "false"},
+			check: func(c *Config) bool { return !c.Chat.Stream },
+		},
+		{
+			name:  "int",
+			env:   map[string]string{"EXPERTLIB_CHAT_MAX_TOOL_STEPS": "8"},
+			check: func(c *Config) bool { return c.Chat.MaxToolSteps == 8 },
+		},
+		{
+			name:  "float",
+			env:   map[string]string{"EXPERTLIB_EXPERT_INTENT_MIN_SCORE": "0.75"},
+			check: func(c *Config) bool { return c.Expert.IntentMinScore == 0.75 },
+		},
+		{
+			name:  "duration",
+			env:   map[string]string{"EXPERTLIB_PROGRAM_IDLE_TIMEOUT": "90s"},
+			check: func(c *Config) bool { return c.Program.IdleTimeout == Duration(90*time.Second) },
+		},
+		{
+			name:  "empty keeps file value",
+			env:   map[string]string{"EXPERTLIB_CHAT_MODEL": ""},
+			check: func(c *Config) bool { return c.Chat.Model == "qwen" },
+		},
+		{
+			name:    "invalid bool",
+			env:     map[string]string{"EXPERTLIB_CHAT_STREAM": "maybe"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid int",
+			env:     map[string]string{"EXPERTLIB_EXPERT_DISPATCH_SHARDS": "many"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid duration",
+			env:     map[string]string{"EXPERTLIB_EXPERT_DIALOG_TTL": "1 day"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := expectedConfig()
+			err := cfg.ApplyEnv(func(name string) string { return tt.env[name] })
+			if tt.wantErr {
+				if err == nil {
+					t.Error("ApplyEnv() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyEnv() error = %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("ApplyEnv() = %+v, env %v not applied", cfg, tt.env)
+			}
+		})
+	}
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	clearEnv(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yamlConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EXPERTLIB_LOG_LEVEL", "warn")
+	t.Setenv("EXPERTLIB_CHAT_PROVIDER", "")
+	t.Setenv("EXPERTLIB_EXPERT_CHAT_HISTORY_LIMIT", "5")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.LogLevel != "warn" {
+		t.Errorf("LogLevel = %q, want warn", cfg.LogLevel)
+	}
+	if cfg.Chat.Provider != "local" {
+		t.Errorf("Chat.Provider = %q, want local", cfg.Chat.Provider)
+	}
+	if cfg.Expert.ChatHistoryLimit != 5 {
+		t.Errorf("Expert.ChatHistoryLimit = %d, want 5", cfg.Expert.ChatHistoryLimit)
+	}
+}
+
+func TestEnvBindingNamesUnique(t *testing.T) {
+	names := make(map[string]bool)
+	for _, binding := range envBindings {
+		if names[binding.name] {
+			t.Errorf("duplicate env binding %s", binding.name)
+		}
+		names[binding.name] = true
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *Config) {},
+		},
+		{
+			name:   "llm url without provider",
+			modify: func(c *Config) { c.Chat.Provider = "" },
+		},
+		{
+			name:    "missing llm url",
+			modify:  func(c *Config) { c.Chat.Provider = ""; c.Chat.LLMURL = "" },
+			wantErr: "chat.llm_url",
+		},
+		{
+			name:    "provider not configured",
+			modify:  func(c *Config) { c.Chat.Provider = "remote" },
+			wantErr: `chat.provider "remote"`,
+		},
+		{
+			name: "unknown provider type",
+			modify: func(c *Config) {
+				c.Chat.Providers["other"] = LLMProviderConfig{Type: "gemini", URL: "http://127.0.0.1"}
+			},
+			wantErr: `unknown chat.providers.other.type "gemini"`,
+		},
+		{
+			name: "provider without url",
+			modify: func(c *Config) {
+				c.Chat.Providers["other"] = LLMProviderConfig{Type: "anthropic"}
+			},
+			wantErr: "chat.providers.other.url",
+		},
+		{
+			name: "fake provider without url",
+			modify: func(c *Config) {
+				c.Chat.Providers["other"] = LLMProviderConfig{Type: "fake"}
+			},
+		},
+		{
+			name:    "unknown dialog store type",
+			modify:  func(c *Config) { c.Expert.DialogStore.Type = "mongo" },
+			wantErr: `unknown expert.dialog_store.type "mongo"`,
+		},
+		{
+			name:    "redis dialog store without addr",
+			modify:  func(c *Config) { c.Expert.DialogStore.Type = "redis" },
+			wantErr: "expert.dialog_store.addr",
+		},
+		{
+			name:   "file dialog store",
+			modify: func(c *Config) { c.Expert.DialogStore.Type = "file" },
+		},
+		{
+			name:    "unknown log level",
+			modify:  func(c *Config) { c.LogLevel = "verbose" },
+			wantErr: "unknown log level",
+		},
+		{
+			name:    "unknown fallback type",
+			modify:  func(c *Config) { c.Expert.Fallback.Type = "ignore" },
+			wantErr: "expert.fallback",
+		},
+		{
+			name: "unknown platform fallback type",
+			modify: func(c *Config) {
+				c.Expert.PlatformFallbacks = map[string]FallbackConfig{"wechat": {Type: "ignore"}}
+			},
+			wantErr: "expert.platform_fallbacks.wechat",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := expectedConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load(filepath.Join("..", "example", "config.yaml"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
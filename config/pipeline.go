@@ -0,0 +1,278 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/huihui4754/expertlib/chat"
+	"github.com/huihui4754/expertlib/dialogstore"
+	"github.com/huihui4754/expertlib/experts"
+	programs "github.com/huihui4754/expertlib/program"
+	"github.com/huihui4754/expertlib/types"
+)
+
+// Pipeline 由同一份配置创建并互相接好消息通道的 专家、多轮对话、程序库 实例
+type Pipeline struct {
+	Expert     *experts.Expert
+	Chat       *chat.Chat
+	Program    *programs.Program
+	runStorage bool
+}
+
+// LoadPipeline 读取配置文件并创建 Pipeline
+func LoadPipeline(path string) (*Pipeline, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return Build(cfg)
+}
+
+// Build 按配置创建三个模块并把它们的消息处理函数互相连接。
+// 返回后仍可以继续调用各模块的 Set 方法，例如注册意图匹配器和设置返回给用户的消息处理函数。
+func Build(cfg *Config) (*Pipeline, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.LogLevel != "" {
+		level, _ := cfg.Level()
+		experts.SetLogger(level)
+		chat.SetLogger(level)
+		programs.SetLogger(level)
+	}
+
+	expertx := experts.NewExpert()
+	if cfg.Expert.DataPath != "" {
+		expertx.SetDataFilePath(cfg.Expert.DataPath)
+	}
+	if cfg.Expert.RNNModelPath != "" {
+		expertx.SetRNNIntentPath(cfg.Expert.RNNModelPath)
+	}
+	if cfg.Expert.ONNXLibPath != "" {
+		expertx.SetONNXLibPath(cfg.Expert.ONNXLibPath)
+	}
+	expertx.SetWatchRNNIntentPath(cfg.Expert.WatchRNNModel)
+	if cfg.Expert.JiebaUserDict != "" {
+		expertx.SetJiebaUserDict(cfg.Expert.JiebaUserDict)
+	}
+	if cfg.Expert.RNNConcurrency > 0 {
+		expertx.SetRNNInferConcurrency(cfg.Expert.RNNConcurrency)
+	}
+	expertx.SetCommandFirst(cfg.Expert.CommandFirst)
+	if cfg.Expert.SaveInterval > 0 {
+		expertx.SetSaveIntervalTime(time.Duration(cfg.Expert.SaveInterval))
+	}
+	if cfg.Expert.ChatHistoryLimit > 0 {
+		expertx.SetChatSaveHistoryLimit(cfg.Expert.ChatHistoryLimit)
+	}
+	if cfg.Expert.IntentMinScore > 0 {
+		expertx.SetIntentMinScore(cfg.Expert.IntentMinScore)
+	}
+	if cfg.Expert.IntentMinMargin > 0 {
+		expertx.SetIntentMinMargin(cfg.Expert.IntentMinMargin)
+	}
+	if cfg.Expert.IntentClarifyMin > 0 {
+		expertx.SetIntentClarifyMinScore(cfg.Expert.IntentClarifyMin)
+	}
+	for name, score := range cfg.Expert.IntentThresholds {
+		expertx.SetIntentThreshold(name, score)
+	}
+	for name, weight := range cfg.Expert.IntentWeights {
+		expertx.SetIntentWeight(name, weight)
+	}
+	if cfg.Expert.IntentCacheMaxSize > 0 {
+		expertx.SetIntentCacheMaxSize(cfg.Expert.IntentCacheMaxSize)
+	}
+	if cfg.Expert.IntentCacheTTL > 0 {
+		expertx.SetIntentCacheTTL(time.Duration(cfg.Expert.IntentCacheTTL))
+	}
+	if cfg.Expert.IntentLearnAutoApprove > 0 {
+		expertx.SetIntentLearnAutoApproveScore(cfg.Expert.IntentLearnAutoApprove)
+	}
+	expertx.SetRecordTrainingSamples(cfg.Expert.RecordTrainingSamples)
+	if cfg.Expert.RuleIntentPath != "" {
+		if err := expertx.LoadRuleIntents(cfg.Expert.RuleIntentPath); err != nil {
+			return nil, fmt.Errorf("failed to load rule intents: %w", err)
+		}
+	}
+	if cfg.Expert.EmbeddingModelPath != "" {
+		expertx.SetEmbeddingModelPath(cfg.Expert.EmbeddingModelPath)
+	}
+	for name, intent := range cfg.Expert.EmbeddingIntents {
+		if err := expertx.RegisterEmbeddingIntent(name, intent.Description, intent.Examples); err != nil {
+			return nil, fmt.Errorf("failed to register embedding intent %s: %w", name, err)
+		}
+	}
+	if cfg.Expert.DialogTTL > 0 {
+		expertx.SetDialogTTL(time.Duration(cfg.Expert.DialogTTL))
+	}
+	if cfg.Expert.DialogIdleTimeout > 0 {
+		expertx.SetDialogIdleTimeout(time.Duration(cfg.Expert.DialogIdleTimeout))
+	}
+	if cfg.Expert.DialogResetAfter > 0 || cfg.Expert.DialogDeleteAfter > 0 {
+		expertx.SetDialogExpirePolicy(experts.DialogExpirePolicy{
+			ResetAfter:  time.Duration(cfg.Expert.DialogResetAfter),
+			DeleteAfter: time.Duration(cfg.Expert.DialogDeleteAfter),
+		})
+	}
+	if cfg.Expert.DispatchShards > 0 {
+		expertx.SetDispatchShards(cfg.Expert.DispatchShards)
+	}
+	if cfg.Expert.DispatchQueueSize > 0 {
+		expertx.SetDispatchQueueSize(cfg.Expert.DispatchQueueSize)
+	}
+	if err := expertx.SetFallbackPolicy("", cfg.Expert.Fallback.Policy()); err != nil {
+		return nil, fmt.Errorf("invalid fallback policy: %w", err)
+	}
+	for platform, fallback := range cfg.Expert.PlatformFallbacks {
+		if err := expertx.SetFallbackPolicy(platform, fallback.Policy()); err != nil {
+			return nil, fmt.Errorf("invalid fallback policy of platform %s: %w", platform, err)
+		}
+	}
+	// 最后打开 dialog 存储，前面的配置出错时不需要关闭
+	store, err := openDialogStore(cfg.Expert.DialogStore, cfg.Expert.DataPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dialog store: %w", err)
+	}
+	if store != nil {
+		expertx.SetDialogStore(store)
+	}
+
+	chatx := chat.NewChat()
+	if cfg.Chat.DataPath != "" {
+		chatx.SetDataFilePath(cfg.Chat.DataPath)
+	}
+	if cfg.Chat.LLMURL != "" {
+		chatx.SetLLMUrl(cfg.Chat.LLMURL)
+	}
+	if cfg.Chat.Model != "" {
+		chatx.SetModelName(cfg.Chat.Model)
+	}
+	for name, providerCfg := range cfg.Chat.Providers {
+		chatx.AddLLMProvider(name, newLLMProvider(providerCfg), providerCfg.Model)
+	}
+	if cfg.Chat.Provider != "" {
+		chatx.SetLLMProvider(cfg.Chat.Provider)
+	}
+	if cfg.Chat.SystemPrompt != "" {
+		chatx.SetSystemPrompt(cfg.Chat.SystemPrompt)
+	}
+	if cfg.Chat.SaveInterval > 0 {
+		chatx.SetSaveIntervalTime(time.Duration(cfg.Chat.SaveInterval))
+	}
+	chatx.SetStream(cfg.Chat.Stream)
+	if cfg.Chat.MaxToolSteps > 0 {
+		chatx.SetMaxToolSteps(cfg.Chat.MaxToolSteps)
+	}
+
+	programx := programs.NewTool()
+	if cfg.Program.DataPath != "" {
+		programx.SetDataFilePath(cfg.Program.DataPath)
+	}
+	if cfg.Program.ProgramPath != "" {
+		programx.SetProgramPath(cfg.Program.ProgramPath)
+	}
+	if cfg.Program.SaveInterval > 0 {
+		programx.SetSaveIntervalTime(time.Duration(cfg.Program.SaveInterval))
+	}
+	if cfg.Program.StoragePort != "" {
+		programx.SetStoragePort(cfg.Program.StoragePort)
+	}
+	if cfg.Program.IdleTimeout > 0 {
+		programx.SetIdleTimeout(time.Duration(cfg.Program.IdleTimeout))
+	}
+
+	expertx.SetToProgramMessageHandler(func(_ types.TotalMessage, message string) {
+		programx.HandleExpertRequestMessage(message)
+	})
+	expertx.SetToChatMessageHandler(func(_ types.TotalMessage, message string) {
+		chatx.HandleExpertRequestMessage(message)
+	})
+	programx.SetToExpertMessageHandler(func(_ types.TotalMessage, message string) {
+		expertx.HandleProgramRequestMessage(message)
+	})
+	chatx.SetToExpertMessageHandler(func(_ types.TotalMessage, message string) {
+		expertx.HandleChatRequestMessage(message)
+	})
+
+	return &Pipeline{
+		Expert:     expertx,
+		Chat:       chatx,
+		Program:    programx,
+		runStorage: !cfg.Program.StorageDisabled,
+	}, nil
+}
+
+// newLLMProvider 按配置创建大模型接口，类型已经由 Validate 检查
+func newLLMProvider(cfg LLMProviderConfig) chat.LLMProvider {
+	switch cfg.Type {
+	case "ollama":
+		return chat.NewOllamaProvider(cfg.URL)
+	case "anthropic":
+		return chat.NewAnthropicProvider(cfg.URL, cfg.APIKey, cfg.MaxTokens)
+	case "fake":
+		return chat.NewFakeProvider()
+	default:
+		return chat.NewOpenAIProvider(cfg.URL, cfg.APIKey)
+	}
+}
+
+// openDialogStore 按配置打开 dialog 存储，file 类型且没有设置 path 时返回 nil，使用专家默认的数据目录
+func openDialogStore(cfg DialogStoreConfig, dataPath string) (experts.DialogStore, error) {
+	switch cfg.Type {
+	case "", "file":
+		if cfg.Path == "" {
+			return nil, nil
+		}
+		return experts.NewFileDialogStore(cfg.Path), nil
+	case "sqlite":
+		path := cfg.Path
+		if path == "" {
+			if dataPath == "" {
+				return nil, fmt.Errorf("expert.dialog_store.path or expert.data_path is required for sqlite dialog store")
+			}
+			if err := os.MkdirAll(dataPath, 0755); err != nil {
+				return nil, err
+			}
+			path = filepath.Join(dataPath, "dialogs.db")
+		}
+		return dialogstore.NewSQLiteStore(path)
+	case "redis":
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return dialogstore.NewRedisStore(ctx, dialogstore.RedisOptions{
+			Addr:     cfg.Addr,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+			Prefix:   cfg.Prefix,
+		})
+	default:
+		return nil, fmt.Errorf("unknown dialog store type %q", cfg.Type)
+	}
+}
+
+// Start 在后台启动三个模块，需要先通过 Expert.SetToUserMessageHandler 设置返回给用户的消息处理函数，
+// Expert.Ready 关闭后专家完成初始化
+func (p *Pipeline) Start() {
+	go p.Program.Run()
+	if p.runStorage {
+		p.Program.RunStroageUserData()
+	}
+	go p.Chat.Run()
+	go p.Expert.Run()
+}
+
+// Shutdown 按 专家 -> 多轮对话 -> 程序库 的顺序关闭，保证专家处理中的消息还能送达下游模块
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	return errors.Join(
+		p.Expert.Shutdown(ctx),
+		p.Chat.Shutdown(ctx),
+		p.Program.Shutdown(ctx),
+	)
+}
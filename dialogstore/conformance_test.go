@@ -0,0 +1,243 @@
+package dialogstore_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/huihui4754/expertlib/dialogstore"
+	"github.com/huihui4754/expertlib/experts"
+	"github.com/huihui4754/expertlib/types"
+)
+
+// storeBackend 一种 experts.DialogStore 实现，advance 让存储中的时间前进 d，用于检查有效期
+type storeBackend struct {
+	name    string
+	open    func(t *testing.T) experts.DialogStore
+	advance func(d time.Duration)
+}
+
+func backends(t *testing.T) []storeBackend {
+	mr := miniredis.RunT(t)
+	return []storeBackend{
+		{
+			name: "file",
+			open: func(t *testing.T) experts.DialogStore {
+				return experts.NewFileDialogStore(t.TempDir())
+			},
+			advance: time.Sleep,
+		},
+		{
+			name: "sqlite",
+			open: func(t *testing.T) experts.DialogStore {
+				store, err := dialogstore.NewSQLiteStore(filepath.Join(t.TempDir(), "dialogs.db"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return store
+			},
+			advance: time.Sleep,
+		},
+		{
+			name: "redis",
+			open: func(t *testing.T) experts.DialogStore {
+				mr.FlushAll()
+				// 每个子测试使用不同的前缀，确认 IDs 只返回自己前缀下的 dialog
+				store, err := dialogstore.NewRedisStore(context.Background(), dialogstore.RedisOptions{
+					Addr:   mr.Addr(),
+					Prefix: "test:" + t.Name() + ":",
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return store
+			},
+			advance: mr.FastForward,
+		},
+	}
+}
+
+func testDialog(id string) *types.DialogInfo {
+	return &types.DialogInfo{
+		UserID:   "user-1",
+		DialogID: id,
+		Platform: "web",
+		Program:  "checkAutoStatus",
+		Mutil:    true,
+		ChatHistory: []types.HistoryEntry{
+			{Role: types.HistoryRoleUser, Source: types.HistorySourceUser, Content: "查看状态", MessageID: "m1"},
+			{Role: types.HistoryRoleAssistant, Source: types.HistorySourceProgram, Intent: "checkAutoStatus", Content: "构建成功"},
+		},
+		Pending:   &types.PendingIntent{Kind: types.PendingSlot, Slot: "tag", Slots: map[string]any{"repo": "main"}},
+		UpdatedAt: time.Date(2026, 10, 1, 8, 30, 0, 0, time.UTC),
+	}
+}
+
+func TestDialogStoreConformance(t *testing.T) {
+	for _, backend := range backends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("GetMissing", func(t *testing.T) {
+				store := backend.open(t)
+				defer store.Close()
+				if _, err := store.Get(context.Background(), "missing"); !errors.Is(err, experts.ErrDialogNotFound) {
+					t.Errorf("Get() error = %v, want ErrDialogNotFound", err)
+				}
+			})
+
+			t.Run("PutGet", func(t *testing.T) {
+				store := backend.open(t)
+				defer store.Close()
+				want := testDialog("d1")
+				if err := store.Put(context.Background(), want, 0); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+				got, err := store.Get(context.Background(), "d1")
+				if err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Get() = %+v, want %+v", got, want)
+				}
+			})
+
+			t.Run("PutOverwrites", func(t *testing.T) {
+				store := backend.open(t)
+				defer store.Close()
+				dialogx := testDialog("d1")
+				if err := store.Put(context.Background(), dialogx, 0); err != nil {
+					t.Fatal(err)
+				}
+				dialogx.Program = ""
+				dialogx.Pending = nil
+				if err := store.Put(context.Background(), dialogx, 0); err != nil {
+					t.Fatal(err)
+				}
+				got, err := store.Get(context.Background(), "d1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Program != "" || got.Pending != nil {
+					t.Errorf("Get() after overwrite = %+v, want program and pending cleared", got)
+				}
+			})
+
+			t.Run("Delete", func(t *testing.T) {
+				store := backend.open(t)
+				defer store.Close()
+				if err := store.Put(context.Background(), testDialog("d1"), 0); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Delete(context.Background(), "d1"); err != nil {
+					t.Fatalf("Delete() error = %v", err)
+				}
+				if _, err := store.Get(context.Background(), "d1"); !errors.Is(err, experts.ErrDialogNotFound) {
+					t.Errorf("Get() after Delete error = %v, want ErrDialogNotFound", err)
+				}
+				if err := store.Delete(context.Background(), "d1"); err != nil {
+					t.Errorf("Delete() of a missing dialog error = %v, want nil", err)
+				}
+			})
+
+			t.Run("IDs", func(t *testing.T) {
+				store := backend.open(t)
+				defer store.Close()
+				ids, err := store.IDs(context.Background())
+				if err != nil {
+					t.Fatalf("IDs() of an empty store error = %v", err)
+				}
+				if len(ids) != 0 {
+					t.Errorf("IDs() of an empty store = %v, want empty", ids)
+				}
+				// 包含需要转义的字符，文件存储用 dialog_id 作为文件名
+				for _, id := range []string{"d2", "d1", "lark/chat:3"} {
+					if err := store.Put(context.Background(), testDialog(id), 0); err != nil {
+						t.Fatal(err)
+					}
+				}
+				ids, err = store.IDs(context.Background())
+				if err != nil {
+					t.Fatalf("IDs() error = %v", err)
+				}
+				if want := []string{"d1", "d2", "lark/chat:3"}; !reflect.DeepEqual(ids, want) {
+					t.Errorf("IDs() = %v, want %v", ids, want)
+				}
+				got, err := store.Get(context.Background(), "lark/chat:3")
+				if err != nil || got.DialogID != "lark/chat:3" {
+					t.Errorf("Get(lark/chat:3) = %v, %v", got, err)
+				}
+			})
+
+			t.Run("TTL", func(t *testing.T) {
+				store := backend.open(t)
+				defer store.Close()
+				const ttl = 200 * time.Millisecond
+				if err := store.Put(context.Background(), testDialog("expiring"), ttl); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Put(context.Background(), testDialog("kept"), 0); err != nil {
+					t.Fatal(err)
+				}
+				// 先设置有效期再以 0 保存，之后不再过期
+				if err := store.Put(context.Background(), testDialog("renewed"), ttl); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Put(context.Background(), testDialog("renewed"), 0); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := store.Get(context.Background(), "expiring"); err != nil {
+					t.Fatalf("Get() before expiry error = %v", err)
+				}
+
+				backend.advance(ttl + 100*time.Millisecond)
+
+				if _, err := store.Get(context.Background(), "expiring"); !errors.Is(err, experts.ErrDialogNotFound) {
+					t.Errorf("Get() after expiry error = %v, want ErrDialogNotFound", err)
+				}
+				ids, err := store.IDs(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := []string{"kept", "renewed"}; !reflect.DeepEqual(ids, want) {
+					t.Errorf("IDs() after expiry = %v, want %v", ids, want)
+				}
+			})
+		})
+	}
+}
+
+func TestRedisStorePrefixIsolation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	open := func(prefix string) *dialogstore.RedisStore {
+		store, err := dialogstore.NewRedisStore(context.Background(), dialogstore.RedisOptions{Addr: mr.Addr(), Prefix: prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	a := open("a:")
+	b := open("b:")
+	if err := a.Put(context.Background(), testDialog("d1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := b.IDs(context.Background()); err != nil || len(ids) != 0 {
+		t.Errorf("IDs() of another prefix = %v, %v, want empty", ids, err)
+	}
+	if !mr.Exists("a:d1") {
+		t.Error("dialog not saved under key a:d1")
+	}
+}
+
+func TestNewRedisStoreUnreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+	if _, err := dialogstore.NewRedisStore(context.Background(), dialogstore.RedisOptions{Addr: addr}); err == nil {
+		t.Error("NewRedisStore() error = nil, want connection error")
+	}
+}
@@ -0,0 +1,104 @@
+package dialogstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/huihui4754/expertlib/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisPrefix RedisStore 默认的 key 前缀，dialog 保存在 <前缀><dialog_id> 中
+const DefaultRedisPrefix = "expertlib:dialog:"
+
+// RedisOptions 连接 redis 或兼容 redis 协议的服务（例如 valkey、kvrocks、miniredis）的参数
+type RedisOptions struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	DB       int
+	Prefix   string // key 前缀，为空时使用 DefaultRedisPrefix
+}
+
+// RedisStore 每个 dialog 保存为一个字符串 key，有效期使用 redis 的过期时间
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore 连接 redis 并用 PING 检查连接
+func NewRedisStore(ctx context.Context, options RedisOptions) (*RedisStore, error) {
+	if options.Addr == "" {
+		return nil, fmt.Errorf("redis addr is required")
+	}
+	prefix := options.Prefix
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     options.Addr,
+		Username: options.Username,
+		Password: options.Password,
+		DB:       options.DB,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect redis '%s': %w", options.Addr, err)
+	}
+	return &RedisStore{client: client, prefix: prefix}, nil
+}
+
+func (r *RedisStore) key(dialogID string) string {
+	return r.prefix + dialogID
+}
+
+func (r *RedisStore) Get(ctx context.Context, dialogID string) (*types.DialogInfo, error) {
+	data, err := r.client.Get(ctx, r.key(dialogID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, types.ErrDialogNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	dialog := &types.DialogInfo{}
+	if err := json.Unmarshal(data, dialog); err != nil {
+		return nil, fmt.Errorf("failed to decode dialog %s: %w", dialogID, err)
+	}
+	return dialog, nil
+}
+
+// Put ttl 为 0 时 key 不过期（会清除之前设置的过期时间）
+func (r *RedisStore) Put(ctx context.Context, dialog *types.DialogInfo, ttl time.Duration) error {
+	data, err := json.Marshal(dialog)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key(dialog.DialogID), data, ttl).Err()
+}
+
+func (r *RedisStore) Delete(ctx context.Context, dialogID string) error {
+	return r.client.Del(ctx, r.key(dialogID)).Err()
+}
+
+// IDs 用 SCAN 遍历前缀下的 key，不会阻塞 redis
+func (r *RedisStore) IDs(ctx context.Context) ([]string, error) {
+	ids := make([]string, 0)
+	iter := r.client.Scan(ctx, 0, r.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		ids = append(ids, strings.TrimPrefix(iter.Val(), r.prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
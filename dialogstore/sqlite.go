@@ -0,0 +1,118 @@
+// Package dialogstore 提供专家 dialog 状态的 SQLite 和 Redis 存储，实现 experts.DialogStore。
+package dialogstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/huihui4754/expertlib/types"
+	_ "modernc.org/sqlite" // 纯 go 实现的 sqlite 驱动，不需要 cgo
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS dialogs (
+	dialog_id  TEXT PRIMARY KEY,
+	data       TEXT NOT NULL,
+	updated_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0
+)`
+
+// SQLiteStore 所有 dialog 保存在一个 sqlite 数据库文件的 dialogs 表中，每个 dialog 一行
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore 打开（不存在时创建）sqlite 数据库文件，并删除已经过期的 dialog
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // sqlite 同一时间只有一个写入，单连接避免 database is locked
+	store := &SQLiteStore{db: db}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create dialogs table in '%s': %w", path, err)
+	}
+	if err := store.deleteExpired(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, dialogID string) (*types.DialogInfo, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT data FROM dialogs WHERE dialog_id = ? AND (expires_at = 0 OR expires_at > ?)`,
+		dialogID, time.Now().UnixMilli()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrDialogNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	dialog := &types.DialogInfo{}
+	if err := json.Unmarshal([]byte(data), dialog); err != nil {
+		return nil, fmt.Errorf("failed to decode dialog %s: %w", dialogID, err)
+	}
+	return dialog, nil
+}
+
+func (s *SQLiteStore) Put(ctx context.Context, dialog *types.DialogInfo, ttl time.Duration) error {
+	data, err := json.Marshal(dialog)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = now.Add(ttl).UnixMilli()
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO dialogs (dialog_id, data, updated_at, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(dialog_id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at, expires_at = excluded.expires_at`,
+		dialog.DialogID, string(data), now.UnixMilli(), expiresAt)
+	return err
+}
+
+func (s *SQLiteStore) Delete(ctx context.Context, dialogID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM dialogs WHERE dialog_id = ?`, dialogID)
+	return err
+}
+
+// IDs 先删除过期的 dialog，再按 dialog_id 排序返回
+func (s *SQLiteStore) IDs(ctx context.Context) ([]string, error) {
+	if err := s.deleteExpired(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT dialog_id FROM dialogs ORDER BY dialog_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLiteStore) deleteExpired(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM dialogs WHERE expires_at > 0 AND expires_at <= ?`, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to delete expired dialogs: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -217,8 +222,23 @@ func main() {
 	})
 
 	// listenAddr := fmt.Sprintf(":%d", config.Port) // 自由设置端口
-	logger.Debug("start websocket")
-	if err := http.ListenAndServe(listenAddr, nil); err != nil {
-		logger.Fatalf("Failed to start server: %v", err)
-	}
+	server := &http.Server{Addr: listenAddr}
+	go func() {
+		logger.Debug("start websocket")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// 收到退出信号后按 入口 -> 专家 -> 多轮对话 -> 程序库 的顺序关闭，保证数据落盘
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	expertx.Shutdown(shutdownCtx)
+	chatx.Shutdown(shutdownCtx)
+	funclibs.Shutdown(shutdownCtx)
 }
@@ -0,0 +1,283 @@
+package experts
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/huihui4754/expertlib/types"
+)
+
+// DialogFilter ListDialogs 的过滤条件，零值字段不过滤
+type DialogFilter struct {
+	UserID   string
+	Platform string
+	Program  string        // 当前对接的程序库
+	State    string        // dialog 状态，见 types.DialogStateIdle 等
+	IdleFor  time.Duration // 只返回空闲超过该时间的 dialog
+}
+
+// DialogSummary ListDialogs 返回的 dialog 概要
+type DialogSummary struct {
+	DialogID   string    `json:"dialog_id"`
+	UserID     string    `json:"user_id"`
+	Platform   string    `json:"platform,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Program    string    `json:"program,omitempty"`
+	State      string    `json:"state"` // 见 types.DialogStateIdle 等
+	Mutil      bool      `json:"mutil"`
+	Pending    string    `json:"pending,omitempty"` // 待确认类型，见 types.PendingChoose 等
+	HistoryLen int       `json:"history_len"`
+	UpdatedAt  time.Time `json:"updated_at,omitzero"`
+	InMemory   bool      `json:"in_memory"` // 是否在内存中，不在时从 dialog 存储中读取
+}
+
+// DialogExpirePolicy 空闲 dialog 的处理策略，零值字段不生效。
+// 内存中的 dialog 在每次定时保存时检查，已经移出内存的 dialog 在下次收到用户消息时检查
+type DialogExpirePolicy struct {
+	ResetAfter  time.Duration `json:"reset_after"`  // 空闲超过该时间时重置 dialog，见 ResetDialog
+	DeleteAfter time.Duration `json:"delete_after"` // 空闲超过该时间时从内存和 dialog 存储中删除
+}
+
+// SetDialogExpirePolicy 设置空闲 dialog 的重置和删除策略，用于清理对接程序库后一直没有结束的 dialog
+func (t *Expert) SetDialogExpirePolicy(policy DialogExpirePolicy) {
+	t.dialogExpirePolicy = policy
+	logger.Infof("Dialog expire policy set to: reset after %v, delete after %v", policy.ResetAfter, policy.DeleteAfter)
+}
+
+func summarizeDialog(dialogx *DialogInfo, inMemory bool) DialogSummary {
+	summary := DialogSummary{
+		DialogID:   dialogx.DialogID,
+		UserID:     dialogx.UserID,
+		Platform:   dialogx.Platform,
+		UserAgent:  dialogx.UserAgent,
+		Program:    dialogx.Program,
+		State:      dialogx.State(),
+		Mutil:      dialogx.Mutil,
+		HistoryLen: len(dialogx.ChatHistory),
+		UpdatedAt:  dialogx.UpdatedAt,
+		InMemory:   inMemory,
+	}
+	if dialogx.Pending != nil {
+		summary.Pending = dialogx.Pending.Kind
+	}
+	return summary
+}
+
+func (f *DialogFilter) match(summary *DialogSummary, now time.Time) bool {
+	if f.UserID != "" && summary.UserID != f.UserID {
+		return false
+	}
+	if f.Platform != "" && summary.Platform != f.Platform {
+		return false
+	}
+	if f.Program != "" && summary.Program != f.Program {
+		return false
+	}
+	if f.State != "" && summary.State != f.State {
+		return false
+	}
+	if f.IdleFor > 0 && now.Sub(summary.UpdatedAt) < f.IdleFor {
+		return false
+	}
+	return true
+}
+
+// ListDialogs 列出内存和 dialog 存储中的 dialog，同一个 dialog 以内存中的为准，按最近活跃时间倒序
+func (t *Expert) ListDialogs(filter DialogFilter) ([]DialogSummary, error) {
+	now := time.Now()
+	summaries := make([]DialogSummary, 0)
+	seen := make(map[string]bool)
+
+	t.dialogsMutex.RLock()
+	dialogs := make([]*DialogInfo, 0, len(t.dialogs))
+	for id, dialogx := range t.dialogs {
+		dialogs = append(dialogs, dialogx)
+		seen[id] = true
+	}
+	t.dialogsMutex.RUnlock()
+	for _, dialogx := range dialogs {
+		dialogx.RWMutex.RLock()
+		summary := summarizeDialog(dialogx, true)
+		dialogx.RWMutex.RUnlock()
+		if filter.match(&summary, now) {
+			summaries = append(summaries, summary)
+		}
+	}
+
+	if t.dialogStore != nil {
+		ctx := context.Background()
+		ids, err := t.dialogStore.IDs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			dialogx, err := t.dialogStore.Get(ctx, id)
+			if err != nil {
+				continue // 读取 id 之后过期或被删除
+			}
+			summary := summarizeDialog(dialogx, false)
+			if filter.match(&summary, now) {
+				summaries = append(summaries, summary)
+			}
+		}
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+	return summaries, nil
+}
+
+// GetDialog 返回 dialog 状态和历史的副本，不会加载到内存，不存在时返回 ErrDialogNotFound
+func (t *Expert) GetDialog(dialogID string) (*DialogInfo, error) {
+	t.dialogsMutex.RLock()
+	dialogx, ok := t.dialogs[dialogID]
+	t.dialogsMutex.RUnlock()
+	if !ok {
+		if t.dialogStore == nil {
+			return nil, ErrDialogNotFound
+		}
+		return t.dialogStore.Get(context.Background(), dialogID)
+	}
+
+	dialogx.RWMutex.RLock()
+	data, err := json.Marshal(dialogx)
+	dialogx.RWMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &DialogInfo{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// ResetDialog 强制重置 dialog：对接程序库时发送 1002 结束程序会话，清除程序库、多轮对话、待确认状态和人工接管，保留历史记录
+func (t *Expert) ResetDialog(dialogID string) error {
+	dialogx := t.lockDialog(dialogID, nil, false)
+	if dialogx == nil {
+		return ErrDialogNotFound
+	}
+	defer dialogx.RWMutex.Unlock()
+	t.resetDialog(dialogx)
+	logger.Infof("Dialog %s reset.", dialogID)
+	return nil
+}
+
+// DeleteDialog 重置 dialog 后从内存和 dialog 存储中删除
+func (t *Expert) DeleteDialog(dialogID string) error {
+	dialogx := t.lockDialog(dialogID, nil, false)
+	if dialogx == nil {
+		return ErrDialogNotFound
+	}
+	defer dialogx.RWMutex.Unlock()
+	return t.deleteDialog(dialogx)
+}
+
+// resetDialog 调用方需要持有 dialog 的写锁。人工接管中时给人工发送 4003，和人工交还 dialog 一样结束人工会话
+func (t *Expert) resetDialog(dialogx *DialogInfo) {
+	if dialogx.Human {
+		t.releaseHuman(dialogx)
+	}
+	if dialogx.Program != "" && t.programMessageHandler != nil {
+		terminate := TotalMessage{
+			EventType: types.EventClientTerminate,
+			DialogID:  dialogx.DialogID,
+			UserId:    dialogx.UserID,
+			MessageID: uuid.New().String(),
+		}
+		msg, err := json.Marshal(terminate)
+		if err != nil {
+			logger.Errorf("Failed to marshal terminate message: %v", err)
+		}
+		t.programMessageHandler(terminate, string(msg))
+	}
+	dialogx.Program = ""
+	dialogx.Mutil = false
+	dialogx.FirstMutil = false
+	dialogx.FirstMutilContent = ""
+	dialogx.RoutedContent = ""
+	dialogx.Pending = nil
+	dialogx.Human = false
+}
+
+// deleteDialog 调用方需要持有 dialog 的写锁
+func (t *Expert) deleteDialog(dialogx *DialogInfo) error {
+	t.resetDialog(dialogx)
+	t.dialogsMutex.Lock()
+	if t.dialogs[dialogx.DialogID] == dialogx {
+		delete(t.dialogs, dialogx.DialogID)
+		delete(t.dialogSaved, dialogx.DialogID)
+	}
+	t.dialogsMutex.Unlock()
+	if t.dialogStore != nil {
+		if err := t.dialogStore.Delete(context.Background(), dialogx.DialogID); err != nil {
+			return err
+		}
+	}
+	logger.Infof("Dialog %s deleted.", dialogx.DialogID)
+	if t.dialogDeletedHandler != nil {
+		t.dialogDeletedHandler(dialogx.DialogID)
+	}
+	return nil
+}
+
+// SetDialogDeletedHandler 设置 dialog 通过管理接口删除或者按 SetDialogExpirePolicy 过期删除后调用的函数，
+// 调用时持有该 dialog 的锁，不能在函数中再操作同一个 dialog。存储自己的有效期到期不会调用
+func (t *Expert) SetDialogDeletedHandler(handler func(dialogID string)) {
+	t.dialogDeletedHandler = handler
+}
+
+// applyExpirePolicy 按空闲时间重置或删除 dialog，返回 true 表示已经删除。调用方需要持有 dialog 的写锁
+func (t *Expert) applyExpirePolicy(dialogx *DialogInfo, idle time.Duration) bool {
+	policy := t.dialogExpirePolicy
+	if dialogx.UpdatedAt.IsZero() {
+		return false
+	}
+	if policy.DeleteAfter > 0 && idle >= policy.DeleteAfter {
+		if err := t.deleteDialog(dialogx); err != nil {
+			logger.Errorf("Failed to delete expired dialog %s: %v", dialogx.DialogID, err)
+		}
+		return true
+	}
+	if policy.ResetAfter > 0 && idle >= policy.ResetAfter && (dialogx.Program != "" || dialogx.Mutil || dialogx.Pending != nil || dialogx.Human) {
+		logger.Infof("Dialog %s idle for %v, reset.", dialogx.DialogID, idle.Round(time.Second))
+		t.resetDialog(dialogx)
+	}
+	return false
+}
+
+// ExpireIdleDialogs 对内存中的 dialog 应用 SetDialogExpirePolicy 设置的策略，定时保存时会自动调用
+func (t *Expert) ExpireIdleDialogs() {
+	policy := t.dialogExpirePolicy
+	if policy.ResetAfter <= 0 && policy.DeleteAfter <= 0 {
+		return
+	}
+	t.dialogsMutex.RLock()
+	dialogs := make([]*DialogInfo, 0, len(t.dialogs))
+	for _, dialogx := range t.dialogs {
+		dialogs = append(dialogs, dialogx)
+	}
+	t.dialogsMutex.RUnlock()
+
+	now := time.Now()
+	for _, dialogx := range dialogs {
+		if !dialogx.RWMutex.TryLock() {
+			continue // 正在处理消息，不是空闲的
+		}
+		t.dialogsMutex.RLock()
+		current := t.dialogs[dialogx.DialogID] == dialogx
+		t.dialogsMutex.RUnlock()
+		if current {
+			t.applyExpirePolicy(dialogx, now.Sub(dialogx.UpdatedAt))
+		}
+		dialogx.RWMutex.Unlock()
+	}
+}
@@ -0,0 +1,96 @@
+package experts
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DialogAdminHandler 返回管理 dialog 的 http 接口，prefix 例如 "/admin"：
+//
+//	GET    {prefix}/dialogs?user_id=&platform=&program=&state=&idle=10m  列出 dialog，参数都是可选的过滤条件
+//	GET    {prefix}/dialogs/{id}                                         dialog 的状态和历史
+//	POST   {prefix}/dialogs/{id}/reset                                   重置 dialog，见 ResetDialog
+//	DELETE {prefix}/dialogs/{id}                                         删除 dialog，见 DeleteDialog
+//	POST   {prefix}/dialogs/expire                                       立即对内存中的 dialog 应用空闲策略
+//
+// 接口本身没有鉴权，需要由调用方包装或只在内网监听。
+func (t *Expert) DialogAdminHandler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+prefix+"/dialogs", t.handleAdminListDialogs)
+	mux.HandleFunc("GET "+prefix+"/dialogs/{id}", t.handleAdminGetDialog)
+	mux.HandleFunc("POST "+prefix+"/dialogs/{id}/reset", t.handleAdminResetDialog)
+	mux.HandleFunc("DELETE "+prefix+"/dialogs/{id}", t.handleAdminDeleteDialog)
+	mux.HandleFunc("POST "+prefix+"/dialogs/expire", t.handleAdminExpireDialogs)
+	return mux
+}
+
+func (t *Expert) handleAdminListDialogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := DialogFilter{
+		UserID:   query.Get("user_id"),
+		Platform: query.Get("platform"),
+		Program:  query.Get("program"),
+		State:    query.Get("state"),
+	}
+	if idle := query.Get("idle"); idle != "" {
+		duration, err := time.ParseDuration(idle)
+		if err != nil {
+			http.Error(w, "invalid idle duration", http.StatusBadRequest)
+			return
+		}
+		filter.IdleFor = duration
+	}
+	dialogs, err := t.ListDialogs(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, map[string]any{"dialogs": dialogs})
+}
+
+func (t *Expert) handleAdminGetDialog(w http.ResponseWriter, r *http.Request) {
+	dialogx, err := t.GetDialog(r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminJSON(w, dialogx)
+}
+
+func (t *Expert) handleAdminResetDialog(w http.ResponseWriter, r *http.Request) {
+	if err := t.ResetDialog(r.PathValue("id")); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (t *Expert) handleAdminDeleteDialog(w http.ResponseWriter, r *http.Request) {
+	if err := t.DeleteDialog(r.PathValue("id")); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (t *Expert) handleAdminExpireDialogs(w http.ResponseWriter, r *http.Request) {
+	t.ExpireIdleDialogs()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Errorf("Failed to write admin response: %v", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrDialogNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
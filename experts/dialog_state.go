@@ -0,0 +1,326 @@
+package experts
+
+import (
+	"encoding/json"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+// 消息的来源
+const (
+	fromUser    = "user"
+	fromProgram = "program"
+	fromChat    = "chat"
+	fromHuman   = "human"
+	// fromBackground 交还前台后在后台运行的程序库（TotalMessage.Background）
+	fromBackground = "background"
+)
+
+// dialogEvent 状态机的输入：消息来源和事件类型
+type dialogEvent struct {
+	from  string
+	event int
+}
+
+// dialogTransition 处理一个事件并修改 dialog 的状态，调用方已经持有 dialog 的写锁，
+// 需要按另一个事件继续处理时直接调用对应的处理函数，不能再次加锁
+type dialogTransition func(t *Expert, dialogx *DialogInfo, message *TotalMessage)
+
+// dialogTransitions dialog 的状态见 types.DialogStateIdle 等，每个事件在各状态下的转移：
+//
+//	用户 1001      idle        -> 匹配到程序库时 program，缺少必填参数时 awaiting，意图接近或需要确认时 awaiting，
+//	                              否则按平台的识别失败策略处理（见 SetFallbackPolicy），默认 multi_turn
+//	               multi_turn  -> 命令优先且匹配到程序库时 program，意图接近或需要确认且命令优先时 awaiting，否则 multi_turn
+//	               awaiting    -> 回答了选择或确认时 program 或 multi_turn，补充了参数时 program 或继续 awaiting，
+//	                              回答无关时清除待确认，按 idle 或 multi_turn 处理
+//	               program     -> program，交给当前程序库
+//	               human       -> human，交给人工（没有设置人工处理函数时退出接管，按 idle 处理）
+//	用户 1002      任意状态    -> 清除待确认，program 时通知程序库后 idle，human 时通知人工后退出接管，multi_turn 保持不变
+//	程序库 2001    任意状态    -> 不变，回复转发给用户
+//	程序库 2002    program     -> idle，回复转发给用户
+//	程序库 2003    program     -> 原消息按用户 1001 重新分配，不再分配给该程序库，识别不到时按识别失败策略处理
+//	程序库 2004    program     -> idle，回复转发给用户
+//	程序库 2005    program     -> idle，程序在后台继续运行，回复转发给用户
+//	后台程序 2001  任意状态    -> 不变，回复转发给用户并记录到历史，dialog 已经不存在时也转发
+//	后台程序 2002  任意状态    -> 不变，内容不为空时以 2001 转发给用户
+//	多轮对话 1001  multi_turn  -> 退出多轮对话，消息按用户 1001 在 idle 状态分配
+//	多轮对话 2001  任意状态    -> 不变，回复转发给用户
+//	多轮对话 2006  任意状态    -> 不变，流式回复的增量片段转发给用户，不记录到历史
+//	多轮对话 2007  任意状态    -> 不变，流式回复结束，完整的回复转发给用户并记录到历史
+//	人工 4001      任意状态    -> human，program 时先通知程序库 1002，清除多轮对话和待确认
+//	人工 4002      任意状态    -> 不变，以 2001 转发给用户
+//	人工 4003      human       -> intention 不为空时 program（缺少必填参数时 awaiting），否则 idle
+//
+// 其他事件只记录日志，不改变状态。
+var dialogTransitions = map[dialogEvent]dialogTransition{
+	{fromUser, types.EventUserMessage}:          (*Expert).onUserMessage,
+	{fromUser, types.EventClientTerminate}:      (*Expert).onClientTerminate,
+	{fromProgram, types.EventServerMessage}:     (*Expert).onProgramReply,
+	{fromProgram, types.EventToolFinish}:        (*Expert).onProgramFinish,
+	{fromProgram, types.EventToolNotSupport}:    (*Expert).onProgramNotSupport,
+	{fromProgram, types.EventToolNotFound}:      (*Expert).onProgramNotFound,
+	{fromProgram, types.EventToolBackground}:    (*Expert).onProgramBackground,
+	{fromBackground, types.EventServerMessage}:  (*Expert).onBackgroundReply,
+	{fromBackground, types.EventToolFinish}:     (*Expert).onBackgroundFinish,
+	{fromBackground, types.EventToolNotSupport}: (*Expert).onBackgroundFinish,
+	{fromBackground, types.EventToolNotFound}:   (*Expert).onBackgroundFinish,
+	{fromChat, types.EventUserMessage}:          (*Expert).onChatIntent,
+	{fromChat, types.EventServerMessage}:        (*Expert).onChatReply,
+	{fromChat, types.EventChatDelta}:            (*Expert).onChatDelta,
+	{fromChat, types.EventChatDone}:             (*Expert).onChatReply,
+	{fromHuman, types.EventHumanTakeover}:       (*Expert).onHumanTakeover,
+	{fromHuman, types.EventHumanReply}:          (*Expert).onHumanReply,
+	{fromHuman, types.EventHumanRelease}:        (*Expert).onHumanRelease,
+}
+
+// handleDialogEvent 所有消息处理的入口：加载 dialog 并加写锁，按 dialogTransitions 处理后解锁。
+// 用户消息在 dialog 不存在时新建，程序库和多轮对话的消息只处理已有的 dialog
+func (t *Expert) handleDialogEvent(from string, message *TotalMessage) {
+	var newDialog func() *DialogInfo
+	if from == fromUser {
+		newDialog = func() *DialogInfo {
+			return &DialogInfo{
+				UserID:      message.UserId,
+				DialogID:    message.DialogID,
+				Program:     "",
+				ChatHistory: make([]types.HistoryEntry, 0),
+			}
+		}
+	}
+	dialogx := t.lockDialog(message.DialogID, newDialog, from == fromUser)
+	if dialogx == nil {
+		// dialog 不存在，后台程序的回复仍然发给用户，其他消息直接返回
+		if from == fromBackground && message.EventType == types.EventServerMessage {
+			t.sendToUser(message)
+		}
+		return
+	}
+	defer dialogx.RWMutex.Unlock()
+
+	if from == fromUser {
+		if message.Platform != "" {
+			dialogx.Platform = message.Platform
+		}
+		if message.UserAgent != "" {
+			dialogx.UserAgent = message.UserAgent
+		}
+	}
+
+	transition, ok := dialogTransitions[dialogEvent{from, message.EventType}]
+	if !ok {
+		logger.Debugf("dialog %s 状态 %s 收到未知事件类型: %s %d", dialogx.DialogID, dialogx.State(), from, message.EventType)
+		return
+	}
+	before := dialogx.State()
+	transition(t, dialogx, message)
+	logger.Debugf("dialog %s %s %d: %s -> %s", dialogx.DialogID, from, message.EventType, before, dialogx.State())
+}
+
+func (t *Expert) handleFromUserMessage(message *TotalMessage) {
+	t.handleDialogEvent(fromUser, message)
+}
+
+func (t *Expert) handleFromProgramMessage(message *TotalMessage) {
+	logger.Debug("收到程序库消息:", *message)
+	if message.Background {
+		t.handleDialogEvent(fromBackground, message)
+		return
+	}
+	t.handleDialogEvent(fromProgram, message)
+}
+
+func (t *Expert) handleFromChatMessage(message *TotalMessage) {
+	logger.Debug("收到多轮对话:", *message)
+	t.handleDialogEvent(fromChat, message)
+}
+
+// onUserMessage 用户 1001
+func (t *Expert) onUserMessage(dialogx *DialogInfo, message *TotalMessage) {
+	logger.Infof("【用户提问】:%s", message.Messages.Content)
+
+	// 如果从程序库返回消息 2003 不支持，且原封不动返回给专家，则不加入历史记录，避免重复
+	historyLen := len(dialogx.ChatHistory)
+	if historyLen > 0 && dialogx.ChatHistory[historyLen-1].Source == types.HistorySourceUser && dialogx.ChatHistory[historyLen-1].Content == message.Messages.Content {
+		// 重复消息，不添加到历史记录
+	} else {
+		t.appendHistory(dialogx, types.NewHistoryEntry(types.HistorySourceUser, dialogx.Program, message))
+	}
+
+	// 人工接管中，不识别意图
+	if dialogx.Human && t.forwardToHuman(dialogx, message, nil) {
+		return
+	}
+
+	// 用户在回复专家的确认问题
+	if t.handlePendingIntent(dialogx, message) {
+		return
+	}
+
+	if dialogx.Program != "" {
+		dialogx.FirstMutil = false
+		dialogx.FirstMutilContent = ""
+		logger.Debug("继续使用当前程序库:", dialogx.Program)
+		t.forwardToProgram(dialogx, message)
+		return
+	}
+	t.assignProgram(dialogx, message, "")
+}
+
+// assignProgram 为还没有对接程序库的 dialog 识别意图，declined 为刚刚返回不支持的程序库，不会再次分配给它
+func (t *Expert) assignProgram(dialogx *DialogInfo, message *TotalMessage, declined string) {
+	logger.Debug("为其寻找合适的程序库")
+	matchResult := t.intentMatch.MatchIntent(message.Messages.Content, message.Messages.Attachments, !dialogx.Mutil)
+	bestProgram, possibleIntentions := matchResult.Intent, matchResult.PossibleIntentions
+	if matchResult.Outcome != IntentMatched || (declined != "" && bestProgram == declined) {
+		bestProgram = ""
+	}
+
+	if declined == "" {
+		// 多个意图分数接近，让用户选择，多轮对话中命令不优先时仍交给多轮对话
+		if matchResult.Outcome == IntentAmbiguous && (!dialogx.Mutil || t.commandFirst) {
+			t.askIntentChoice(dialogx, message, matchResult.Candidates)
+			return
+		}
+		// 意图分数处于中间区间且匹配器给出了澄清问题，向用户确认
+		if matchResult.Outcome == IntentNeedConfirm && (!dialogx.Mutil || t.commandFirst) {
+			t.askIntentConfirm(dialogx, message, matchResult)
+			return
+		}
+	}
+
+	// 多轮对话中只有命令优先时才分配程序库，否则继续交给多轮对话
+	if dialogx.Mutil && (bestProgram == "" || !t.commandFirst) && t.chatMessageHandler != nil {
+		t.forwardToChat(dialogx, message, possibleIntentions)
+		return
+	}
+	if bestProgram == "" {
+		t.fallback(dialogx, message, possibleIntentions)
+		return
+	}
+	dialogx.Program = bestProgram
+	dialogx.FirstMutil = false
+	dialogx.FirstMutilContent = ""
+	dialogx.Mutil = false
+	t.routeToProgram(dialogx, message)
+}
+
+// onClientTerminate 用户 1002，客户端终止对话
+func (t *Expert) onClientTerminate(dialogx *DialogInfo, message *TotalMessage) {
+	dialogx.Pending = nil
+	if dialogx.Human {
+		t.forwardToHuman(dialogx, message, nil)
+		dialogx.Human = false
+	}
+	if dialogx.Program == "" {
+		return
+	}
+	msg, err := json.Marshal(message)
+	if err != nil {
+		logger.Errorf("Failed to marshal client message: %v", err)
+	}
+	if t.programMessageHandler != nil {
+		t.programMessageHandler(*message, string(msg))
+	}
+	dialogx.Program = ""
+}
+
+// onProgramReply 程序库 2001
+func (t *Expert) onProgramReply(dialogx *DialogInfo, message *TotalMessage) {
+	logger.Infof("【回复用户】:%s", message.Messages.Content)
+	t.appendHistory(dialogx, types.NewHistoryEntry(types.HistorySourceProgram, dialogx.Program, message))
+	t.sendToUser(message)
+}
+
+// onProgramFinish 程序库 2002，程序结束
+func (t *Expert) onProgramFinish(dialogx *DialogInfo, message *TotalMessage) {
+	t.sendToUser(message)
+	t.recordOutcome(dialogx, 1)
+	dialogx.Program = ""
+}
+
+// onProgramBackground 程序库 2005，程序交还前台后在后台继续运行，和 2002 一样结束本轮对话
+func (t *Expert) onProgramBackground(dialogx *DialogInfo, message *TotalMessage) {
+	if message.Messages.Content != "" {
+		t.appendHistory(dialogx, types.NewHistoryEntry(types.HistorySourceProgram, dialogx.Program, message))
+	}
+	t.sendToUser(message)
+	t.recordOutcome(dialogx, 1)
+	dialogx.Program = ""
+}
+
+// onBackgroundReply 后台程序 2001，只转发给用户，不改变 dialog 当前对接的程序库
+func (t *Expert) onBackgroundReply(dialogx *DialogInfo, message *TotalMessage) {
+	logger.Infof("【后台程序 %s 回复用户】:%s", message.Intention, message.Messages.Content)
+	t.appendHistory(dialogx, types.NewHistoryEntry(types.HistorySourceProgram, message.Intention, message))
+	t.sendToUser(message)
+}
+
+// onBackgroundFinish 后台程序结束，内容不为空时作为 2001 发给用户，避免客户端误以为当前对话结束
+func (t *Expert) onBackgroundFinish(dialogx *DialogInfo, message *TotalMessage) {
+	logger.Debugf("dialog %s 的后台程序 %s 结束: %d", dialogx.DialogID, message.Intention, message.EventType)
+	if message.Messages.Content == "" {
+		return
+	}
+	reply := *message
+	reply.EventType = types.EventServerMessage
+	t.onBackgroundReply(dialogx, &reply)
+}
+
+// onProgramNotSupport 程序库 2003，程序库不支持该能力，原消息重新分配一个程序库
+func (t *Expert) onProgramNotSupport(dialogx *DialogInfo, message *TotalMessage) {
+	declined := dialogx.Program
+	t.recordOutcome(dialogx, 0)
+	dialogx.Program = ""
+
+	rerouted := *message
+	rerouted.EventType = types.EventUserMessage
+	rerouted.Intention = ""
+	rerouted.Slots = nil
+	logger.Infof("程序库 %s 不支持，重新分配:%s", declined, rerouted.Messages.Content)
+	t.assignProgram(dialogx, &rerouted, declined)
+}
+
+// onProgramNotFound 程序库 2004，找不到程序库
+func (t *Expert) onProgramNotFound(dialogx *DialogInfo, message *TotalMessage) {
+	t.sendToUser(message)
+	dialogx.Program = ""
+	dialogx.RoutedContent = ""
+}
+
+// onChatIntent 多轮对话 1001，多轮对话总结了用户的需求，按用户消息重新分配
+func (t *Expert) onChatIntent(dialogx *DialogInfo, message *TotalMessage) {
+	// 第一句话没有匹配到意图，多轮对话紧接着识别出了意图，记录下来用于学习意图缓存
+	if message.Intention != "" && dialogx.FirstMutil && dialogx.FirstMutilContent != "" {
+		t.intentMatch.LearnIntent(dialogx.FirstMutilContent, message.Intention, message.Messages.Content, message.DialogID)
+	}
+	dialogx.FirstMutil = false
+	dialogx.FirstMutilContent = ""
+	dialogx.Mutil = false
+	t.onUserMessage(dialogx, message)
+}
+
+// onChatReply 多轮对话 2001 和流式回复结束的 2007
+func (t *Expert) onChatReply(dialogx *DialogInfo, message *TotalMessage) {
+	logger.Infof("【回复用户】:%s", message.Messages.Content)
+	t.appendHistory(dialogx, types.NewHistoryEntry(types.HistorySourceChat, "", message))
+	t.sendToUser(message)
+}
+
+// onChatDelta 多轮对话 2006，增量片段只转发给用户，完整的回复在 2007 时记录
+func (t *Expert) onChatDelta(dialogx *DialogInfo, message *TotalMessage) {
+	t.sendToUser(message)
+}
+
+// sendToUser 把程序库或多轮对话的消息原样转发给用户
+func (t *Expert) sendToUser(message *TotalMessage) {
+	if t.userMessageHandler == nil {
+		logger.Warnf("没有设置返回给用户的消息处理函数，丢弃 dialog %s 的消息", message.DialogID)
+		return
+	}
+	toUserMessage := *message
+	msg, err := json.Marshal(toUserMessage)
+	if err != nil {
+		logger.Errorf("Failed to marshal user message: %v", err)
+	}
+	t.userMessageHandler(toUserMessage, string(msg))
+}
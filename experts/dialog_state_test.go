@@ -0,0 +1,315 @@
+package experts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+// keywordIntent 消息包含关键词时分数为 1 的意图
+type keywordIntent struct {
+	name    string
+	keyword string
+}
+
+func (k *keywordIntent) GetIntentName() string { return k.name }
+func (k *keywordIntent) GetIntentDesc() string { return k.name }
+func (k *keywordIntent) Matching(content string, _ []Attachment) float64 {
+	if strings.Contains(content, k.keyword) {
+		return 1
+	}
+	return 0
+}
+
+// outbound 专家通过处理函数发出的一条消息
+type outbound struct {
+	to         string // user、program、chat、human
+	event      int
+	intention  string
+	background bool
+}
+
+// dialogFields 状态转移后检查的 dialog 字段
+type dialogFields struct {
+	Program string
+	Mutil   bool
+	Human   bool
+	Pending bool
+}
+
+func newStateTestExpert(sent *[]outbound) *Expert {
+	expert := NewExpert()
+	status := &keywordIntent{name: "status", keyword: "状态"}
+	expert.Register(func() IntentMatchInter { return status }, status.name)
+	record := func(to string) func(TotalMessage, string) {
+		return func(message TotalMessage, raw string) {
+			*sent = append(*sent, outbound{to: to, event: message.EventType, intention: message.Intention, background: message.Background})
+		}
+	}
+	expert.SetToUserMessageHandler(record("user"))
+	expert.SetToProgramMessageHandler(record("program"))
+	expert.SetToChatMessageHandler(record("chat"))
+	expert.SetToHumanMessageHandler(record("human"))
+	return expert
+}
+
+func stateMessage(event int, content string) *TotalMessage {
+	message := &TotalMessage{EventType: event, DialogID: "d1", UserId: "user-1", MessageID: "m1"}
+	message.Messages.Content = content
+	return message
+}
+
+func TestDialogTransitions(t *testing.T) {
+	idle := dialogFields{}
+	inProgram := dialogFields{Program: "p1"}
+	multiTurn := dialogFields{Mutil: true}
+	human := dialogFields{Human: true}
+
+	tests := []struct {
+		name    string
+		from    string
+		message *TotalMessage
+		state   dialogFields
+		want    dialogFields
+		sent    []outbound
+	}{
+		{
+			name: "user 1001 idle matched", from: fromUser,
+			message: stateMessage(types.EventUserMessage, "查看状态"), state: idle,
+			want: dialogFields{Program: "status"},
+			sent: []outbound{{to: "program", event: types.EventUserMessage, intention: "status"}},
+		},
+		{
+			name: "user 1001 idle not matched", from: fromUser,
+			message: stateMessage(types.EventUserMessage, "你好"), state: idle,
+			want: multiTurn,
+			sent: []outbound{{to: "chat", event: types.EventUserMessage}},
+		},
+		{
+			name: "user 1001 program", from: fromUser,
+			message: stateMessage(types.EventUserMessage, "查看状态"), state: inProgram,
+			want: inProgram,
+			sent: []outbound{{to: "program", event: types.EventUserMessage, intention: "p1"}},
+		},
+		{
+			name: "user 1001 multi_turn", from: fromUser,
+			message: stateMessage(types.EventUserMessage, "你好"), state: multiTurn,
+			want: multiTurn,
+			sent: []outbound{{to: "chat", event: types.EventUserMessage}},
+		},
+		{
+			name: "user 1001 human", from: fromUser,
+			message: stateMessage(types.EventUserMessage, "查看状态"), state: human,
+			want: human,
+			sent: []outbound{{to: "human", event: types.EventUserMessage}},
+		},
+		{
+			name: "user 1002 program", from: fromUser,
+			message: stateMessage(types.EventClientTerminate, ""), state: inProgram,
+			want: idle,
+			sent: []outbound{{to: "program", event: types.EventClientTerminate}},
+		},
+		{
+			name: "user 1002 human", from: fromUser,
+			message: stateMessage(types.EventClientTerminate, ""), state: human,
+			want: idle,
+			sent: []outbound{{to: "human", event: types.EventClientTerminate}},
+		},
+		{
+			name: "user 1002 multi_turn", from: fromUser,
+			message: stateMessage(types.EventClientTerminate, ""), state: multiTurn,
+			want: multiTurn,
+		},
+		{
+			name: "program 2001", from: fromProgram,
+			message: stateMessage(types.EventServerMessage, "处理中"), state: inProgram,
+			want: inProgram,
+			sent: []outbound{{to: "user", event: types.EventServerMessage}},
+		},
+		{
+			name: "program 2002", from: fromProgram,
+			message: stateMessage(types.EventToolFinish, "完成"), state: inProgram,
+			want: idle,
+			sent: []outbound{{to: "user", event: types.EventToolFinish}},
+		},
+		{
+			name: "program 2003", from: fromProgram,
+			message: stateMessage(types.EventToolNotSupport, "查看状态"), state: dialogFields{Program: "status"},
+			// 不再分配给 status，识别失败按默认策略交给多轮对话
+			want: multiTurn,
+			sent: []outbound{{to: "chat", event: types.EventUserMessage}},
+		},
+		{
+			name: "program 2004", from: fromProgram,
+			message: stateMessage(types.EventToolNotFound, "找不到程序库"), state: inProgram,
+			want: idle,
+			sent: []outbound{{to: "user", event: types.EventToolNotFound}},
+		},
+		{
+			name: "program 2005", from: fromProgram,
+			message: stateMessage(types.EventToolBackground, "后台继续运行"), state: inProgram,
+			want: idle,
+			sent: []outbound{{to: "user", event: types.EventToolBackground}},
+		},
+		{
+			name: "background 2001", from: fromBackground,
+			message: backgroundMessage(types.EventServerMessage, "后台进度"), state: inProgram,
+			want: inProgram,
+			sent: []outbound{{to: "user", event: types.EventServerMessage, intention: "bg", background: true}},
+		},
+		{
+			name: "background 2002", from: fromBackground,
+			message: backgroundMessage(types.EventToolFinish, "后台完成"), state: inProgram,
+			want: inProgram,
+			sent: []outbound{{to: "user", event: types.EventServerMessage, intention: "bg", background: true}},
+		},
+		{
+			name: "background 2002 without content", from: fromBackground,
+			message: backgroundMessage(types.EventToolFinish, ""), state: inProgram,
+			want: inProgram,
+		},
+		{
+			name: "background 2003", from: fromBackground,
+			message: backgroundMessage(types.EventToolNotSupport, ""), state: inProgram,
+			want: inProgram,
+		},
+		{
+			name: "background 2004", from: fromBackground,
+			message: backgroundMessage(types.EventToolNotFound, ""), state: idle,
+			want: idle,
+		},
+		{
+			name: "chat 1001", from: fromChat,
+			message: stateMessage(types.EventUserMessage, "查看状态"), state: multiTurn,
+			want: dialogFields{Program: "status"},
+			sent: []outbound{{to: "program", event: types.EventUserMessage, intention: "status"}},
+		},
+		{
+			name: "chat 2001", from: fromChat,
+			message: stateMessage(types.EventServerMessage, "你好"), state: multiTurn,
+			want: multiTurn,
+			sent: []outbound{{to: "user", event: types.EventServerMessage}},
+		},
+		{
+			name: "chat 2006", from: fromChat,
+			message: stateMessage(types.EventChatDelta, "你"), state: multiTurn,
+			want: multiTurn,
+			sent: []outbound{{to: "user", event: types.EventChatDelta}},
+		},
+		{
+			name: "chat 2007", from: fromChat,
+			message: stateMessage(types.EventChatDone, "你好"), state: multiTurn,
+			want: multiTurn,
+			sent: []outbound{{to: "user", event: types.EventChatDone}},
+		},
+		{
+			name: "human 4001 program", from: fromHuman,
+			message: stateMessage(types.EventHumanTakeover, ""), state: inProgram,
+			want: human,
+			sent: []outbound{{to: "program", event: types.EventClientTerminate}},
+		},
+		{
+			name: "human 4001 with reply", from: fromHuman,
+			message: stateMessage(types.EventHumanTakeover, "您好，人工客服为您服务"), state: multiTurn,
+			want: human,
+			sent: []outbound{{to: "user", event: types.EventServerMessage}},
+		},
+		{
+			name: "human 4002", from: fromHuman,
+			message: stateMessage(types.EventHumanReply, "已经处理"), state: human,
+			want: human,
+			sent: []outbound{{to: "user", event: types.EventServerMessage}},
+		},
+		{
+			name: "human 4003", from: fromHuman,
+			message: stateMessage(types.EventHumanRelease, ""), state: human,
+			want: idle,
+		},
+		{
+			name: "human 4003 with intention", from: fromHuman,
+			message: func() *TotalMessage {
+				message := stateMessage(types.EventHumanRelease, "继续构建")
+				message.Intention = "p2"
+				return message
+			}(), state: human,
+			want: dialogFields{Program: "p2"},
+			sent: []outbound{{to: "program", event: types.EventUserMessage, intention: "p2"}},
+		},
+	}
+
+	covered := make(map[dialogEvent]bool)
+	for _, tt := range tests {
+		covered[dialogEvent{tt.from, tt.message.EventType}] = true
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []outbound
+			expert := newStateTestExpert(&sent)
+			dialogx := expert.lockDialog("d1", newTestDialog, true)
+			dialogx.Program = tt.state.Program
+			dialogx.Mutil = tt.state.Mutil
+			dialogx.Human = tt.state.Human
+			dialogx.RWMutex.Unlock()
+
+			expert.handleDialogEvent(tt.from, tt.message)
+
+			dialogx.RWMutex.RLock()
+			got := dialogFields{Program: dialogx.Program, Mutil: dialogx.Mutil, Human: dialogx.Human, Pending: dialogx.Pending != nil}
+			dialogx.RWMutex.RUnlock()
+			if got != tt.want {
+				t.Errorf("dialog = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(sent, tt.sent) {
+				t.Errorf("sent %+v, want %+v", sent, tt.sent)
+			}
+		})
+	}
+	for event := range dialogTransitions {
+		if !covered[event] {
+			t.Errorf("transition %s %d has no test", event.from, event.event)
+		}
+	}
+}
+
+func backgroundMessage(event int, content string) *TotalMessage {
+	message := stateMessage(event, content)
+	message.Intention = "bg"
+	message.Background = true
+	return message
+}
+
+func TestHandleDialogEventMissingDialog(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		message *TotalMessage
+		sent    []outbound
+	}{
+		{
+			name: "background reply still sent to user", from: fromBackground,
+			message: backgroundMessage(types.EventServerMessage, "后台进度"),
+			sent:    []outbound{{to: "user", event: types.EventServerMessage, intention: "bg", background: true}},
+		},
+		{
+			name: "program reply dropped", from: fromProgram,
+			message: stateMessage(types.EventServerMessage, "处理中"),
+		},
+		{
+			name: "chat reply dropped", from: fromChat,
+			message: stateMessage(types.EventServerMessage, "你好"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []outbound
+			expert := newStateTestExpert(&sent)
+			expert.handleDialogEvent(tt.from, tt.message)
+			if !reflect.DeepEqual(sent, tt.sent) {
+				t.Errorf("sent %+v, want %+v", sent, tt.sent)
+			}
+			if dialogInMemory(expert, "d1") != nil {
+				t.Error("dialog created by a module message")
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package experts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+// ErrDialogNotFound DialogStore.Get 找不到 dialog 或 dialog 已经过期时返回
+var ErrDialogNotFound = types.ErrDialogNotFound
+
+// DialogStore 按 dialog 保存对话状态，专家只在内存中保留活跃的 dialog，其余的按需从存储中加载。
+// 内置文件存储（NewFileDialogStore），SQLite 和 Redis 存储见 dialogstore 包。
+type DialogStore interface {
+	// Get 读取 dialog，不存在或已经过期时返回 ErrDialogNotFound
+	Get(ctx context.Context, dialogID string) (*DialogInfo, error)
+	// Put 保存 dialog，ttl 大于 0 时从本次保存开始计算过期时间，为 0 时不过期
+	Put(ctx context.Context, dialog *DialogInfo, ttl time.Duration) error
+	// Delete 删除 dialog，不存在时不返回错误
+	Delete(ctx context.Context, dialogID string) error
+	// IDs 返回所有未过期的 dialog_id
+	IDs(ctx context.Context) ([]string, error)
+	Close() error
+}
+
+// fileDialogRecord 文件存储中一个 dialog 文件的内容
+type fileDialogRecord struct {
+	ExpiresAt time.Time   `json:"expires_at,omitzero"`
+	Dialog    *DialogInfo `json:"dialog"`
+}
+
+// FileDialogStore 每个 dialog 保存为目录下的一个 <dialog_id>.json 文件
+type FileDialogStore struct {
+	dir string
+}
+
+func NewFileDialogStore(dir string) *FileDialogStore {
+	return &FileDialogStore{dir: dir}
+}
+
+func (f *FileDialogStore) path(dialogID string) string {
+	return filepath.Join(f.dir, url.PathEscape(dialogID)+".json")
+}
+
+func (f *FileDialogStore) Get(_ context.Context, dialogID string) (*DialogInfo, error) {
+	data, err := os.ReadFile(f.path(dialogID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrDialogNotFound
+		}
+		return nil, err
+	}
+	var record fileDialogRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode dialog %s: %w", dialogID, err)
+	}
+	if record.Dialog == nil {
+		return nil, ErrDialogNotFound
+	}
+	if !record.ExpiresAt.IsZero() && time.Now().After(record.ExpiresAt) {
+		os.Remove(f.path(dialogID))
+		return nil, ErrDialogNotFound
+	}
+	return record.Dialog, nil
+}
+
+// Put 先写临时文件再重命名，避免保存中途退出留下不完整的文件
+func (f *FileDialogStore) Put(_ context.Context, dialog *DialogInfo, ttl time.Duration) error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	record := fileDialogRecord{Dialog: dialog}
+	if ttl > 0 {
+		record.ExpiresAt = time.Now().Add(ttl)
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := f.path(dialog.DialogID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f *FileDialogStore) Delete(_ context.Context, dialogID string) error {
+	err := os.Remove(f.path(dialogID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// IDs 读取每个文件检查是否过期，过期的文件会被删除
+func (f *FileDialogStore) IDs(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		dialogID, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		if _, err := f.Get(ctx, dialogID); err != nil {
+			if !errors.Is(err, ErrDialogNotFound) {
+				logger.Warnf("Skip dialog file %s: %v", entry.Name(), err)
+			}
+			continue
+		}
+		ids = append(ids, dialogID)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (f *FileDialogStore) Close() error {
+	return nil
+}
+
+// readLegacyDialogs 读取旧版的 dailoginfo.json（所有 dialog 保存在一个文件中），文件不存在时返回 nil
+func readLegacyDialogs(path string) (map[string]*DialogInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	dialogs := make(map[string]*DialogInfo)
+	if err := json.Unmarshal(data, &dialogs); err != nil {
+		return nil, err
+	}
+	return dialogs, nil
+}
+
+// migrateLegacyDialogs 把旧版 dailoginfo.json 中的 dialog 逐个写入 dialog 存储，完成后将文件重命名为 dailoginfo.json.migrated
+func (t *Expert) migrateLegacyDialogs() {
+	if t.dialogStore == nil || t.dataFilePath == "" {
+		return
+	}
+	path := t.legacyDialogPath()
+	dialogs, err := readLegacyDialogs(path)
+	if err != nil {
+		logger.Errorf("Failed to read legacy dialog file: %v", err)
+		return
+	}
+	if dialogs == nil {
+		return
+	}
+	for id, dialogx := range dialogs {
+		if dialogx == nil {
+			continue
+		}
+		if dialogx.DialogID == "" {
+			dialogx.DialogID = id
+		}
+		if err := t.dialogStore.Put(context.Background(), dialogx, t.dialogTTL); err != nil {
+			logger.Errorf("Failed to migrate dialog %s: %v, keep legacy dialog file.", id, err)
+			return
+		}
+	}
+	if err := os.Rename(path, path+".migrated"); err != nil {
+		logger.Errorf("Failed to rename legacy dialog file: %v", err)
+		return
+	}
+	logger.Infof("Migrated %d dialogs from %s to dialog store.", len(dialogs), path)
+}
+
+// SetDialogStore 设置 dialog 存储，需要在 Run 之前设置，默认在数据目录的 dialogs 子目录中每个 dialog 保存一个文件
+func (t *Expert) SetDialogStore(store DialogStore) {
+	t.dialogStore = store
+}
+
+// SetDialogTTL 设置 dialog 在存储中的有效期，从最后一次保存开始计算，默认 0 永不过期
+func (t *Expert) SetDialogTTL(ttl time.Duration) {
+	t.dialogTTL = ttl
+	logger.Info("Dialog ttl set to:", ttl)
+}
+
+// SetDialogIdleTimeout 设置 dialog 多久没有消息后保存并从内存中移除，默认 30 分钟，0 为不移除
+func (t *Expert) SetDialogIdleTimeout(timeout time.Duration) {
+	t.dialogIdleTimeout = timeout
+	logger.Info("Dialog idle timeout set to:", timeout)
+}
+
+// loadDialog 从内存获取 dialog，内存中没有时从 dialog 存储中加载，都没有时用 newDialog 新建（newDialog 为 nil 时返回 nil）
+func (t *Expert) loadDialog(dialogID string, newDialog func() *DialogInfo) *DialogInfo {
+	t.dialogsMutex.RLock()
+	dialogx, ok := t.dialogs[dialogID]
+	t.dialogsMutex.RUnlock()
+	if ok {
+		return dialogx
+	}
+
+	var stored *DialogInfo
+	if t.dialogStore != nil {
+		var err error
+		stored, err = t.dialogStore.Get(context.Background(), dialogID)
+		if err != nil && !errors.Is(err, ErrDialogNotFound) {
+			// 读取失败时不新建，避免保存时覆盖存储中的数据
+			logger.Errorf("Failed to load dialog %s: %v", dialogID, err)
+			return nil
+		}
+	}
+	if stored == nil {
+		if newDialog == nil {
+			return nil
+		}
+		stored = newDialog()
+	}
+
+	t.dialogsMutex.Lock()
+	defer t.dialogsMutex.Unlock()
+	if existing, ok := t.dialogs[dialogID]; ok {
+		return existing
+	}
+	t.dialogs[dialogID] = stored
+	t.dialogSaved[dialogID] = stored.UpdatedAt
+	return stored
+}
+
+// lockDialog 获取 dialog 并加写锁，同时更新 UpdatedAt，调用方负责解锁；dialog 不存在时返回 nil，见 loadDialog。
+// expire 为 true 时（用户发来的消息）加锁后先应用 SetDialogExpirePolicy 设置的策略，模块的回复不会让 dialog 被重置或删除
+func (t *Expert) lockDialog(dialogID string, newDialog func() *DialogInfo, expire bool) *DialogInfo {
+	for {
+		dialogx := t.loadDialog(dialogID, newDialog)
+		if dialogx == nil {
+			return nil
+		}
+		dialogx.RWMutex.Lock()
+		t.dialogsMutex.RLock()
+		current := t.dialogs[dialogID]
+		t.dialogsMutex.RUnlock()
+		if current != dialogx {
+			// 加锁前已经被移出内存，重新加载
+			dialogx.RWMutex.Unlock()
+			continue
+		}
+		// 从存储中加载的 dialog 可能已经空闲很久，先按策略重置或删除，删除后重新加载时新建
+		if expire && t.applyExpirePolicy(dialogx, time.Since(dialogx.UpdatedAt)) {
+			dialogx.RWMutex.Unlock()
+			continue
+		}
+		dialogx.UpdatedAt = time.Now()
+		return dialogx
+	}
+}
+
+// saveDialogs 把上次保存后有变化的 dialog 写入 dialog 存储
+func (t *Expert) saveDialogs() {
+	if t.dialogStore == nil {
+		return
+	}
+	t.dialogsMutex.RLock()
+	dialogs := make(map[string]*DialogInfo, len(t.dialogs))
+	for id, dialogx := range t.dialogs {
+		dialogs[id] = dialogx
+	}
+	t.dialogsMutex.RUnlock()
+
+	saved := 0
+	for id, dialogx := range dialogs {
+		dialogx.RWMutex.RLock()
+		t.dialogsMutex.RLock()
+		lastSaved := t.dialogSaved[id]
+		t.dialogsMutex.RUnlock()
+		updatedAt := dialogx.UpdatedAt
+		if lastSaved.Equal(updatedAt) {
+			dialogx.RWMutex.RUnlock()
+			continue
+		}
+		err := t.dialogStore.Put(context.Background(), dialogx, t.dialogTTL)
+		dialogx.RWMutex.RUnlock()
+		if err != nil {
+			logger.Errorf("Failed to save dialog %s: %v", id, err)
+			continue
+		}
+		t.dialogsMutex.Lock()
+		if t.dialogs[id] == dialogx {
+			t.dialogSaved[id] = updatedAt
+		}
+		t.dialogsMutex.Unlock()
+		saved++
+	}
+	if saved > 0 {
+		logger.Infof("Saved %d dialogs.", saved)
+	}
+}
+
+// evictIdleDialogs 将已经保存且超过空闲时间的 dialog 移出内存，正在处理消息的 dialog 跳过
+func (t *Expert) evictIdleDialogs() {
+	if t.dialogStore == nil || t.dialogIdleTimeout <= 0 {
+		return
+	}
+	now := time.Now()
+	t.dialogsMutex.Lock()
+	defer t.dialogsMutex.Unlock()
+	evicted := 0
+	for id, dialogx := range t.dialogs {
+		if !dialogx.RWMutex.TryLock() {
+			continue
+		}
+		if t.dialogSaved[id].Equal(dialogx.UpdatedAt) && now.Sub(dialogx.UpdatedAt) >= t.dialogIdleTimeout {
+			delete(t.dialogs, id)
+			delete(t.dialogSaved, id)
+			evicted++
+		}
+		dialogx.RWMutex.Unlock()
+	}
+	if evicted > 0 {
+		logger.Debugf("Evicted %d idle dialogs from memory.", evicted)
+	}
+}
@@ -0,0 +1,218 @@
+package experts
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+// failingDialogStore 读取时总是返回错误的存储
+type failingDialogStore struct {
+	FileDialogStore
+}
+
+func (f *failingDialogStore) Get(context.Context, string) (*DialogInfo, error) {
+	return nil, errors.New("connection refused")
+}
+
+func newStoreTestExpert(t *testing.T) (*Expert, *FileDialogStore) {
+	t.Helper()
+	store := NewFileDialogStore(t.TempDir())
+	expert := NewExpert()
+	expert.SetDialogStore(store)
+	expert.SetDialogIdleTimeout(time.Hour)
+	return expert, store
+}
+
+func newTestDialog() *DialogInfo {
+	return &DialogInfo{DialogID: "d1", UserID: "user-1", ChatHistory: make([]types.HistoryEntry, 0)}
+}
+
+func dialogInMemory(expert *Expert, dialogID string) *DialogInfo {
+	expert.dialogsMutex.RLock()
+	defer expert.dialogsMutex.RUnlock()
+	return expert.dialogs[dialogID]
+}
+
+func TestLockDialogLazyLoad(t *testing.T) {
+	expert, store := newStoreTestExpert(t)
+	stored := &DialogInfo{DialogID: "d1", UserID: "user-1", Program: "checkAutoStatus", UpdatedAt: time.Now().Add(-time.Minute)}
+	if err := store.Put(context.Background(), stored, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	dialogx := expert.lockDialog("d1", nil, true)
+	if dialogx == nil {
+		t.Fatal("lockDialog() = nil, want dialog loaded from store")
+	}
+	if dialogx.Program != "checkAutoStatus" || dialogx.UserID != "user-1" {
+		t.Errorf("lockDialog() = %+v, want stored dialog", dialogx)
+	}
+	if time.Since(dialogx.UpdatedAt) > time.Second {
+		t.Errorf("UpdatedAt = %v, want refreshed on lock", dialogx.UpdatedAt)
+	}
+	dialogx.RWMutex.Unlock()
+
+	if dialogInMemory(expert, "d1") != dialogx {
+		t.Error("loaded dialog not kept in memory")
+	}
+	again := expert.lockDialog("d1", nil, true)
+	if again != dialogx {
+		t.Error("second lockDialog() loaded another copy")
+	}
+	again.RWMutex.Unlock()
+}
+
+func TestLockDialogMissing(t *testing.T) {
+	expert, _ := newStoreTestExpert(t)
+	if dialogx := expert.lockDialog("d1", nil, true); dialogx != nil {
+		t.Fatalf("lockDialog() without newDialog = %+v, want nil", dialogx)
+	}
+	if dialogInMemory(expert, "d1") != nil {
+		t.Error("missing dialog added to memory")
+	}
+
+	dialogx := expert.lockDialog("d1", newTestDialog, true)
+	if dialogx == nil || dialogx.DialogID != "d1" {
+		t.Fatalf("lockDialog() with newDialog = %+v, want new dialog", dialogx)
+	}
+	dialogx.RWMutex.Unlock()
+	if dialogInMemory(expert, "d1") != dialogx {
+		t.Error("new dialog not kept in memory")
+	}
+}
+
+func TestLockDialogStoreError(t *testing.T) {
+	expert := NewExpert()
+	expert.SetDialogStore(&failingDialogStore{FileDialogStore: *NewFileDialogStore(t.TempDir())})
+	// 读取失败时不能新建，否则保存时会覆盖存储中的数据
+	if dialogx := expert.lockDialog("d1", newTestDialog, true); dialogx != nil {
+		t.Errorf("lockDialog() = %+v, want nil when store fails", dialogx)
+	}
+}
+
+func TestEvictIdleDialogs(t *testing.T) {
+	expert, store := newStoreTestExpert(t)
+
+	// 已保存且空闲超时，移出内存
+	idle := expert.lockDialog("idle", func() *DialogInfo { return &DialogInfo{DialogID: "idle", Program: "p1"} }, true)
+	idle.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	idle.RWMutex.Unlock()
+	// 已保存但没有超时，保留
+	active := expert.lockDialog("active", func() *DialogInfo { return &DialogInfo{DialogID: "active"} }, true)
+	active.RWMutex.Unlock()
+	// 空闲超时但正在处理消息，保留
+	busy := expert.lockDialog("busy", func() *DialogInfo { return &DialogInfo{DialogID: "busy"} }, true)
+	busy.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	busy.RWMutex.Unlock()
+
+	expert.saveDialogs()
+
+	// 保存后又有修改的 dialog 不能移出，否则修改会丢失
+	unsaved := expert.lockDialog("unsaved", func() *DialogInfo { return &DialogInfo{DialogID: "unsaved"} }, true)
+	unsaved.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	unsaved.RWMutex.Unlock()
+
+	busy.RWMutex.Lock()
+	expert.evictIdleDialogs()
+	busy.RWMutex.Unlock()
+
+	tests := []struct {
+		id       string
+		inMemory bool
+	}{
+		{"idle", false},
+		{"active", true},
+		{"busy", true},
+		{"unsaved", true},
+	}
+	for _, tt := range tests {
+		if got := dialogInMemory(expert, tt.id) != nil; got != tt.inMemory {
+			t.Errorf("dialog %s in memory = %v, want %v", tt.id, got, tt.inMemory)
+		}
+	}
+
+	// 移出内存的 dialog 仍然在存储中，下次收到消息时重新加载
+	if _, err := store.Get(context.Background(), "idle"); err != nil {
+		t.Fatalf("evicted dialog not in store: %v", err)
+	}
+	reloaded := expert.lockDialog("idle", nil, true)
+	if reloaded == nil {
+		t.Fatal("lockDialog() of evicted dialog = nil, want reloaded")
+	}
+	defer reloaded.RWMutex.Unlock()
+	if reloaded == idle {
+		t.Error("evicted dialog returned from memory, want reloaded from store")
+	}
+	if reloaded.Program != "p1" {
+		t.Errorf("reloaded dialog = %+v, want program p1", reloaded)
+	}
+}
+
+func TestEvictIdleDialogsDisabled(t *testing.T) {
+	expert, _ := newStoreTestExpert(t)
+	expert.SetDialogIdleTimeout(0)
+	dialogx := expert.lockDialog("d1", newTestDialog, true)
+	dialogx.UpdatedAt = time.Now().Add(-48 * time.Hour)
+	dialogx.RWMutex.Unlock()
+	expert.saveDialogs()
+	expert.evictIdleDialogs()
+	if dialogInMemory(expert, "d1") == nil {
+		t.Error("dialog evicted with idle timeout 0")
+	}
+}
+
+func TestLockDialogExpirePolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		expire      bool
+		wantProgram string
+	}{
+		{"user event resets idle dialog", true, ""},
+		{"module reply keeps dialog", false, "p1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expert, _ := newStoreTestExpert(t)
+			expert.SetDialogExpirePolicy(DialogExpirePolicy{ResetAfter: time.Minute})
+			dialogx := expert.lockDialog("d1", newTestDialog, true)
+			dialogx.Program = "p1"
+			dialogx.UpdatedAt = time.Now().Add(-time.Hour)
+			dialogx.RWMutex.Unlock()
+
+			dialogx = expert.lockDialog("d1", nil, tt.expire)
+			defer dialogx.RWMutex.Unlock()
+			if dialogx.Program != tt.wantProgram {
+				t.Errorf("Program = %q, want %q", dialogx.Program, tt.wantProgram)
+			}
+		})
+	}
+}
+
+func TestDeleteDialogHandler(t *testing.T) {
+	expert, _ := newStoreTestExpert(t)
+	var deleted []string
+	expert.SetDialogDeletedHandler(func(dialogID string) {
+		deleted = append(deleted, dialogID)
+	})
+	dialogx := expert.lockDialog("d1", newTestDialog, true)
+	dialogx.RWMutex.Unlock()
+	if err := expert.DeleteDialog("d1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 空闲策略删除也会调用
+	expert.SetDialogExpirePolicy(DialogExpirePolicy{DeleteAfter: time.Minute})
+	dialogx = expert.lockDialog("d2", func() *DialogInfo { return &DialogInfo{DialogID: "d2"} }, true)
+	dialogx.UpdatedAt = time.Now().Add(-time.Hour)
+	dialogx.RWMutex.Unlock()
+	expert.ExpireIdleDialogs()
+
+	if want := []string{"d1", "d2"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+}
@@ -0,0 +1,120 @@
+package experts
+
+import (
+	"errors"
+	"hash/fnv"
+	"runtime"
+	"sync"
+)
+
+var (
+	// ErrQueueFull 用户消息所在分片的队列已满，调用方可以稍后重试或提示用户繁忙
+	ErrQueueFull = errors.New("expert message queue is full")
+	// ErrExpertStopped 专家已经关闭，不再接收消息
+	ErrExpertStopped = errors.New("expert has been shut down")
+
+	errUnsupportedMessage = errors.New("unsupported message type")
+)
+
+const (
+	defaultDispatchQueueSize = 256
+)
+
+// dispatchTask 一条待处理的消息
+type dispatchTask struct {
+	handle  func(*TotalMessage)
+	message *TotalMessage
+}
+
+// dispatcher 按 dialog_id 分片的消息队列，每个分片一个协程顺序处理，
+// 同一个 dialog 的消息总是进入同一个分片，保证按到达顺序处理，不同分片之间并行
+type dispatcher struct {
+	mu      *sync.RWMutex // 保护 closed，关闭通道时需要写锁，避免向已关闭的通道发送
+	closed  bool
+	shards  []chan dispatchTask
+	started *sync.Once
+	workers *sync.WaitGroup
+}
+
+func newDispatcher(shards int, queueSize int) *dispatcher {
+	if shards <= 0 {
+		shards = runtime.NumCPU()
+	}
+	if queueSize <= 0 {
+		queueSize = defaultDispatchQueueSize
+	}
+	d := &dispatcher{
+		mu:      &sync.RWMutex{},
+		shards:  make([]chan dispatchTask, shards),
+		started: &sync.Once{},
+		workers: &sync.WaitGroup{},
+	}
+	for idx := range d.shards {
+		d.shards[idx] = make(chan dispatchTask, queueSize)
+	}
+	return d
+}
+
+func (d *dispatcher) shard(dialogID string) chan dispatchTask {
+	hash := fnv.New32a()
+	hash.Write([]byte(dialogID))
+	return d.shards[hash.Sum32()%uint32(len(d.shards))]
+}
+
+// submit 把消息放入 dialog 所在分片的队列。wait 为 false 时队列已满立即返回 ErrQueueFull，
+// 为 true 时等待队列有空位或 stop 关闭
+func (d *dispatcher) submit(task dispatchTask, wait bool, stop <-chan struct{}) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrExpertStopped
+	}
+	queue := d.shard(task.message.DialogID)
+	if !wait {
+		select {
+		case queue <- task:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+	select {
+	case queue <- task:
+		return nil
+	case <-stop:
+		return ErrExpertStopped
+	}
+}
+
+// start 为每个分片启动处理协程，重复调用只启动一次
+func (d *dispatcher) start() {
+	d.started.Do(func() {
+		for _, queue := range d.shards {
+			d.workers.Add(1)
+			go func() {
+				defer d.workers.Done()
+				for task := range queue {
+					task.handle(task.message)
+				}
+			}()
+		}
+	})
+}
+
+// close 不再接收新消息，已经排队的消息由处理协程处理完后退出
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	for _, queue := range d.shards {
+		close(queue)
+	}
+}
+
+// wait 等待所有处理协程退出，需要先调用 close
+func (d *dispatcher) wait() {
+	d.workers.Wait()
+}
@@ -0,0 +1,160 @@
+package experts
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func dispatchMessage(dialogID string, seq int) *TotalMessage {
+	return &TotalMessage{DialogID: dialogID, MessageID: strconv.Itoa(seq)}
+}
+
+// dialogsInDifferentShards 返回两个落在不同分片的 dialog_id
+func dialogsInDifferentShards(t *testing.T, d *dispatcher) (string, string) {
+	t.Helper()
+	first := "d0"
+	for idx := 1; idx < 100; idx++ {
+		other := fmt.Sprintf("d%d", idx)
+		if d.shard(other) != d.shard(first) {
+			return first, other
+		}
+	}
+	t.Fatal("no dialog_id in another shard")
+	return "", ""
+}
+
+func TestDispatcherFIFOWithinDialog(t *testing.T) {
+	d := newDispatcher(4, 16)
+	mu := &sync.Mutex{}
+	got := make(map[string][]string)
+	handle := func(message *TotalMessage) {
+		mu.Lock()
+		got[message.DialogID] = append(got[message.DialogID], message.MessageID)
+		mu.Unlock()
+	}
+	d.start()
+	const count = 200
+	want := make(map[string][]string)
+	for seq := 0; seq < count; seq++ {
+		dialogID := fmt.Sprintf("d%d", seq%5)
+		if err := d.submit(dispatchTask{handle: handle, message: dispatchMessage(dialogID, seq)}, true, nil); err != nil {
+			t.Fatal(err)
+		}
+		want[dialogID] = append(want[dialogID], strconv.Itoa(seq))
+	}
+	d.close()
+	d.wait()
+
+	for dialogID, ids := range want {
+		if fmt.Sprint(got[dialogID]) != fmt.Sprint(ids) {
+			t.Errorf("dialog %s handled %v, want %v", dialogID, got[dialogID], ids)
+		}
+	}
+}
+
+func TestDispatcherParallelAcrossShards(t *testing.T) {
+	d := newDispatcher(2, 1)
+	first, second := dialogsInDifferentShards(t, d)
+	// first 的处理要等 second 的处理开始，两个分片不并行时会超时
+	secondStarted := make(chan struct{})
+	firstDone := make(chan bool, 1)
+	d.start()
+	defer func() {
+		d.close()
+		d.wait()
+	}()
+	err := d.submit(dispatchTask{message: dispatchMessage(first, 0), handle: func(*TotalMessage) {
+		select {
+		case <-secondStarted:
+			firstDone <- true
+		case <-time.After(5 * time.Second):
+			firstDone <- false
+		}
+	}}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.submit(dispatchTask{message: dispatchMessage(second, 1), handle: func(*TotalMessage) {
+		close(secondStarted)
+	}}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !<-firstDone {
+		t.Error("dialogs in different shards not handled in parallel")
+	}
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	d := newDispatcher(1, 2)
+	noop := func(*TotalMessage) {}
+	// 没有启动处理协程，队列放满后不等待的提交立即失败
+	for seq := 0; seq < 2; seq++ {
+		if err := d.submit(dispatchTask{handle: noop, message: dispatchMessage("d1", seq)}, false, nil); err != nil {
+			t.Fatalf("submit %d error = %v", seq, err)
+		}
+	}
+	if err := d.submit(dispatchTask{handle: noop, message: dispatchMessage("d1", 2)}, false, nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("submit to a full shard error = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestDispatcherClosed(t *testing.T) {
+	d := newDispatcher(1, 4)
+	handled := make(chan string, 4)
+	handle := func(message *TotalMessage) { handled <- message.MessageID }
+	if err := d.submit(dispatchTask{handle: handle, message: dispatchMessage("d1", 0)}, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.close()
+	d.close() // 可以重复关闭
+	for _, wait := range []bool{false, true} {
+		if err := d.submit(dispatchTask{handle: handle, message: dispatchMessage("d1", 1)}, wait, nil); !errors.Is(err, ErrExpertStopped) {
+			t.Errorf("submit(wait=%v) after close error = %v, want ErrExpertStopped", wait, err)
+		}
+	}
+	// 关闭前排队的消息仍然会处理
+	d.start()
+	d.wait()
+	close(handled)
+	var ids []string
+	for id := range handled {
+		ids = append(ids, id)
+	}
+	if fmt.Sprint(ids) != "[0]" {
+		t.Errorf("handled %v, want [0]", ids)
+	}
+}
+
+func TestDispatcherSubmitReleasedByStop(t *testing.T) {
+	d := newDispatcher(1, 1)
+	noop := func(*TotalMessage) {}
+	if err := d.submit(dispatchTask{handle: noop, message: dispatchMessage("d1", 0)}, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- d.submit(dispatchTask{handle: noop, message: dispatchMessage("d1", 1)}, true, stop)
+	}()
+	select {
+	case err := <-result:
+		t.Fatalf("submit to a full shard returned %v, want blocked", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(stop)
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrExpertStopped) {
+			t.Errorf("blocked submit error = %v, want ErrExpertStopped", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked submit not released by stop")
+	}
+	// 阻塞的提交返回后才能关闭
+	d.close()
+}
@@ -0,0 +1,362 @@
+package experts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+const (
+	embeddingModelFile  = "model.onnx"
+	embeddingVocabFile  = "vocab.txt"
+	embeddingConfigFile = "config.json"
+
+	embeddingPoolingMean = "mean" // 对所有 token 的向量取平均
+	embeddingPoolingCLS  = "cls"  // 使用 [CLS] 的向量
+
+	defaultEmbeddingMaxLength = 128
+	embeddingCacheSize        = 256 // 缓存最近的句向量，多个 embedding 意图匹配同一句话时只推理一次
+)
+
+// EmbeddingModelConfig 句向量模型目录中 config.json 的内容，都可以不填
+type EmbeddingModelConfig struct {
+	Inputs    []string `json:"inputs,omitempty"`     // 模型输入名称，支持 input_ids、attention_mask、token_type_ids，默认读取模型的输入
+	Output    string   `json:"output,omitempty"`     // 使用的模型输出名称，默认为模型的第一个输出
+	Pooling   string   `json:"pooling,omitempty"`    // 输出为 (1, 长度, 维度) 时的池化方式：mean（默认）或 cls
+	MaxLength int      `json:"max_length,omitempty"` // 最大 token 数，默认 128
+	LowerCase *bool    `json:"lower_case,omitempty"` // 分词前是否转小写，默认 true
+}
+
+// embeddingCall 一次句向量计算，同一句话同时请求时共享结果
+type embeddingCall struct {
+	done   chan struct{}
+	vector []float32
+	err    error
+}
+
+// EmbeddingModel 本地 onnx 句向量模型，目录中包含 model.onnx、vocab.txt 和可选的 config.json。
+// 多个 EmbeddingIntent 可以共用一个模型。
+type EmbeddingModel struct {
+	modelPath string
+	config    EmbeddingModelConfig
+	tokenizer *WordPieceTokenizer
+	session   *ort.DynamicAdvancedSession
+	mu        *sync.RWMutex // 推理时读锁，Close 时写锁
+
+	cacheMutex *sync.Mutex
+	cache      map[string]*embeddingCall
+	cacheOrder []string // 按加入顺序，超出容量时删除最早的
+}
+
+// NewEmbeddingModel 加载句向量模型，onnxLibPath 为 onnxruntime 动态库路径，与 rnn 意图共用同一个 onnxruntime 环境
+func NewEmbeddingModel(modelPath string, onnxLibPath string) (*EmbeddingModel, error) {
+	initializeONNX(onnxLibPath)
+
+	config := EmbeddingModelConfig{}
+	if data, err := os.ReadFile(filepath.Join(modelPath, embeddingConfigFile)); err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to decode embedding config: %w", err)
+		}
+	}
+	onnxPath := filepath.Join(modelPath, embeddingModelFile)
+	inputs, outputs, err := ort.GetInputOutputInfo(onnxPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding model '%s': %w", onnxPath, err)
+	}
+	if len(config.Inputs) == 0 {
+		for _, input := range inputs {
+			config.Inputs = append(config.Inputs, input.Name)
+		}
+	}
+	for _, name := range config.Inputs {
+		if !slices.Contains([]string{"input_ids", "attention_mask", "token_type_ids"}, name) {
+			return nil, fmt.Errorf("unsupported embedding model input %q", name)
+		}
+	}
+	if config.Output == "" {
+		if len(outputs) == 0 {
+			return nil, fmt.Errorf("embedding model '%s' has no output", onnxPath)
+		}
+		config.Output = outputs[0].Name
+	}
+	if config.Pooling == "" {
+		config.Pooling = embeddingPoolingMean
+	}
+	if config.Pooling != embeddingPoolingMean && config.Pooling != embeddingPoolingCLS {
+		return nil, fmt.Errorf("unsupported embedding pooling %q", config.Pooling)
+	}
+	if config.MaxLength <= 0 {
+		config.MaxLength = defaultEmbeddingMaxLength
+	}
+	lowerCase := config.LowerCase == nil || *config.LowerCase
+
+	tokenizer, err := NewWordPieceTokenizer(filepath.Join(modelPath, embeddingVocabFile), lowerCase, config.MaxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ort.NewSessionOptions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session options: %w", err)
+	}
+	defer options.Destroy()
+	session, err := ort.NewDynamicAdvancedSession(onnxPath, config.Inputs, []string{config.Output}, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic session for model '%s': %w", onnxPath, err)
+	}
+
+	model := &EmbeddingModel{
+		modelPath:  modelPath,
+		config:     config,
+		tokenizer:  tokenizer,
+		session:    session,
+		mu:         &sync.RWMutex{},
+		cacheMutex: &sync.Mutex{},
+		cache:      make(map[string]*embeddingCall),
+	}
+	if _, err := model.embed("你好"); err != nil {
+		model.Close()
+		return nil, fmt.Errorf("smoke inference failed: %w", err)
+	}
+	logger.Infof("Embedding model loaded from %s, inputs %v, output %s, pooling %s.", modelPath, config.Inputs, config.Output, config.Pooling)
+	return model, nil
+}
+
+// Close 释放模型资源，会等待正在进行的推理完成
+func (e *EmbeddingModel) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.session != nil {
+		e.session.Destroy()
+		e.session = nil
+	}
+}
+
+// Embed 返回归一化后的句向量，最近计算过的句子直接使用缓存
+func (e *EmbeddingModel) Embed(text string) ([]float32, error) {
+	e.cacheMutex.Lock()
+	if call, ok := e.cache[text]; ok {
+		e.cacheMutex.Unlock()
+		<-call.done
+		return call.vector, call.err
+	}
+	call := &embeddingCall{done: make(chan struct{})}
+	e.cache[text] = call
+	e.cacheOrder = append(e.cacheOrder, text)
+	if len(e.cacheOrder) > embeddingCacheSize {
+		delete(e.cache, e.cacheOrder[0])
+		e.cacheOrder = e.cacheOrder[1:]
+	}
+	e.cacheMutex.Unlock()
+
+	call.vector, call.err = e.embed(text)
+	close(call.done)
+	if call.err != nil {
+		// 失败的结果不缓存
+		e.cacheMutex.Lock()
+		if e.cache[text] == call {
+			delete(e.cache, text)
+		}
+		e.cacheMutex.Unlock()
+	}
+	return call.vector, call.err
+}
+
+func (e *EmbeddingModel) embed(text string) ([]float32, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.session == nil {
+		return nil, fmt.Errorf("embedding model %s is closed", e.modelPath)
+	}
+
+	ids := e.tokenizer.Encode(text)
+	shape := ort.NewShape(1, int64(len(ids)))
+	inputs := make([]ort.Value, 0, len(e.config.Inputs))
+	defer func() {
+		for _, input := range inputs {
+			input.Destroy()
+		}
+	}()
+	for _, name := range e.config.Inputs {
+		data := make([]int64, len(ids))
+		switch name {
+		case "input_ids":
+			copy(data, ids)
+		case "attention_mask":
+			for idx := range data {
+				data[idx] = 1
+			}
+		}
+		tensor, err := ort.NewTensor(shape, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create input tensor %s: %w", name, err)
+		}
+		inputs = append(inputs, tensor)
+	}
+
+	// 输出形状由模型决定，交给 onnxruntime 分配
+	outputs := []ort.Value{nil}
+	if err := e.session.Run(inputs, outputs); err != nil {
+		return nil, fmt.Errorf("embedding inference failed for text '%s': %w", text, err)
+	}
+	defer outputs[0].Destroy()
+	output, ok := outputs[0].(*ort.Tensor[float32])
+	if !ok {
+		return nil, fmt.Errorf("embedding model output %s is not a float32 tensor", e.config.Output)
+	}
+	return e.pool(output.GetData(), output.GetShape())
+}
+
+// pool 把模型输出转换为一个归一化的句向量，输出为 (1, 维度) 时直接使用
+func (e *EmbeddingModel) pool(data []float32, shape ort.Shape) ([]float32, error) {
+	var vector []float32
+	switch len(shape) {
+	case 2:
+		vector = append([]float32(nil), data[:shape[1]]...)
+	case 3:
+		length, dim := int(shape[1]), int(shape[2])
+		if length == 0 {
+			return nil, fmt.Errorf("embedding model returned empty sequence")
+		}
+		vector = make([]float32, dim)
+		if e.config.Pooling == embeddingPoolingCLS {
+			copy(vector, data[:dim])
+			break
+		}
+		for token := 0; token < length; token++ {
+			for idx := 0; idx < dim; idx++ {
+				vector[idx] += data[token*dim+idx]
+			}
+		}
+		for idx := range vector {
+			vector[idx] /= float32(length)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported embedding output shape %v", shape)
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 || math.IsNaN(norm) {
+		return nil, fmt.Errorf("embedding model returned invalid vector")
+	}
+	for idx := range vector {
+		vector[idx] = float32(float64(vector[idx]) / norm)
+	}
+	return vector, nil
+}
+
+// EmbeddingIntent 用几句示例话术定义的意图，匹配时计算用户输入和每句示例的余弦相似度，返回最高的相似度
+type EmbeddingIntent struct {
+	model       *EmbeddingModel
+	intentName  string
+	intentDesc  string
+	examples    []string
+	exampleVecs [][]float32
+}
+
+// NewEmbeddingIntent 创建 embedding 意图，创建时计算所有示例的句向量
+func NewEmbeddingIntent(model *EmbeddingModel, name string, description string, examples []string) (*EmbeddingIntent, error) {
+	if len(examples) == 0 {
+		return nil, fmt.Errorf("embedding intent %s has no examples", name)
+	}
+	intent := &EmbeddingIntent{
+		model:      model,
+		intentName: name,
+		intentDesc: description,
+		examples:   examples,
+	}
+	for _, example := range examples {
+		vector, err := model.embed(example)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed example %q of intent %s: %w", example, name, err)
+		}
+		intent.exampleVecs = append(intent.exampleVecs, vector)
+	}
+	return intent, nil
+}
+
+func (e *EmbeddingIntent) GetIntentName() string {
+	return e.intentName
+}
+
+func (e *EmbeddingIntent) GetIntentDesc() string {
+	return e.intentDesc
+}
+
+// Examples 返回意图的示例话术
+func (e *EmbeddingIntent) Examples() []string {
+	return append([]string(nil), e.examples...)
+}
+
+// Matching 返回用户输入和示例之间最高的余弦相似度，小于 0 时返回 0
+func (e *EmbeddingIntent) Matching(content string, attachments []Attachment) float64 {
+	vector, err := e.model.Embed(content)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return 0.0
+	}
+	best := 0.0
+	for _, example := range e.exampleVecs {
+		var similarity float64
+		for idx := range vector {
+			similarity += float64(vector[idx]) * float64(example[idx])
+		}
+		best = math.Max(best, similarity)
+	}
+	logger.Debugf("embedding intent %s similarity for text %s: %.4f", e.intentName, content, best)
+	return math.Min(best, 1)
+}
+
+// SetEmbeddingModelPath 设置句向量模型目录（model.onnx、vocab.txt、可选的 config.json），需要在 RegisterEmbeddingIntent 之前设置，
+// 同时需要 SetONNXLibPath 设置 onnxruntime 动态库路径
+func (t *Expert) SetEmbeddingModelPath(path string) {
+	t.embeddingMutex.Lock()
+	defer t.embeddingMutex.Unlock()
+	t.embeddingModelPath = path
+	logger.Info("Embedding model path set to:", path)
+}
+
+// embeddingModelInstance 第一次注册 embedding 意图时加载句向量模型
+func (t *Expert) embeddingModelInstance() (*EmbeddingModel, error) {
+	t.embeddingMutex.Lock()
+	defer t.embeddingMutex.Unlock()
+	if t.embeddingModel != nil {
+		return t.embeddingModel, nil
+	}
+	if t.embeddingModelPath == "" {
+		return nil, fmt.Errorf("embedding model path is not set")
+	}
+	model, err := NewEmbeddingModel(t.embeddingModelPath, t.onnxLibPath)
+	if err != nil {
+		return nil, err
+	}
+	t.embeddingModel = model
+	return model, nil
+}
+
+// RegisterEmbeddingIntent 用示例话术注册一个意图，匹配分数为用户输入和示例之间最高的余弦相似度。
+// 同名意图已经注册时替换，并删除该意图由匹配器得到的缓存。
+func (t *Expert) RegisterEmbeddingIntent(name string, description string, examples []string) error {
+	model, err := t.embeddingModelInstance()
+	if err != nil {
+		return err
+	}
+	intent, err := NewEmbeddingIntent(model, name, description, examples)
+	if err != nil {
+		return err
+	}
+	t.intentMatch.Replace(func() IntentMatchInter { return intent }, name)
+	t.intentMatch.InvalidateCache(name)
+	logger.Infof("Embedding intent %s registered with %d examples.", name, len(examples))
+	return nil
+}
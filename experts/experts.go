@@ -1,14 +1,14 @@
 package experts
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/huihui4754/expertlib/types"
@@ -28,25 +28,44 @@ type DialogInfo = types.DialogInfo
 
 // Expert结构体保存expert实例的配置和处理程序。
 type Expert struct {
-	dataFilePath           string
-	rnnIntentPath          string
-	onnxLibPath            string
-	commandFirst           bool
-	userMessageHandler     func(TotalMessage, string)
-	programMessageHandler  func(TotalMessage, string)
-	chatMessageHandler     func(TotalMessage, string)
-	intentMatch            *IntentMatchManager //意图识别管理器
-	rnnIntent              *RNNIntentManager   //RNN意图管理器
-	userMessageInChan      chan *TotalMessage  //用户消息输入通道
-	programMessageInChan   chan *TotalMessage  //程序库消息输入通道
-	chatMessageInChan      chan *TotalMessage  //多轮对话消息输入通道
-	dialogs                map[string]*DialogInfo
-	dialogsMutex           *sync.RWMutex
-	lastSavedDialogInfoMd5 string // 上次保存的dialog 信息的md5 值
-	saveDialogInfoFunc     func(map[string]*DialogInfo)
-	loadDialogInfoFunc     func() map[string]*DialogInfo
-	saveInterval           time.Duration // 定时保存dialog 和 意图识别间隔时间
-	chatSaveHistoryLimit   int           // 多轮对话保存的历史消息条数限制
+	dataFilePath          string
+	rnnIntentPath         string
+	onnxLibPath           string
+	commandFirst          bool
+	userMessageHandler    func(TotalMessage, string)
+	programMessageHandler func(TotalMessage, string)
+	chatMessageHandler    func(TotalMessage, string)
+	humanMessageHandler   func(TotalMessage, string)
+	dialogDeletedHandler  func(dialogID string)  // dialog 被删除后调用
+	intentMatch           *IntentMatchManager    //意图识别管理器
+	rnnIntent             *RNNIntentManager      //RNN意图管理器
+	dispatcher            *dispatcher            // 按 dialog 分片的消息队列，第一次收到消息或 Run 时创建
+	dispatcherOnce        *sync.Once             // 保证 dispatcher 只创建一次
+	dispatchShards        int                    // 分片数量，0 为 cpu 核数
+	dispatchQueueSize     int                    // 每个分片的队列长度
+	dialogs               map[string]*DialogInfo // 内存中活跃的 dialog
+	dialogsMutex          *sync.RWMutex
+	dialogSaved           map[string]time.Time // 每个 dialog 最后一次保存时的 UpdatedAt
+	dialogStore           DialogStore          // 为空时在 Run 中使用数据目录下的文件存储
+	dialogTTL             time.Duration        // dialog 在存储中的有效期，0 为永不过期
+	dialogIdleTimeout     time.Duration        // dialog 空闲多久后移出内存
+	dialogExpirePolicy    DialogExpirePolicy   // 空闲 dialog 的重置和删除策略
+	saveInterval          time.Duration        // 定时保存dialog 和 意图识别间隔时间
+	chatSaveHistoryLimit  int                  // 多轮对话保存的历史消息条数限制
+	stopChan              chan struct{}        // 关闭信号，Shutdown 时关闭
+	stopOnce              *sync.Once           // 保证关闭信号只发送一次
+	runDone               chan struct{}        // Run 退出后关闭
+	ready                 chan struct{}        // Run 完成初始化开始处理消息后关闭
+	running               atomic.Bool          // Run 是否已经启动
+	trainingRecorder      *trainingRecorder    // 记录程序库处理结果用于导出训练数据
+	watchRNNIntent        bool                 // 是否监听 rnn 模型目录自动重新加载
+	rnnReloadMutex        *sync.Mutex          // 同一时间只重新加载一个 rnn 意图
+	rnnInferConcurrency   int                  // rnn 意图同时推理的数量上限，0 为 cpu 核数
+	embeddingModelPath    string               // 句向量模型目录
+	embeddingModel        *EmbeddingModel      // 第一次注册 embedding 意图时加载
+	embeddingMutex        *sync.Mutex
+	slots                 *SlotRegistry     // SetIntentSlots 设置的意图参数
+	fallbacks             *fallbackPolicies // 识别不到意图时各平台的处理策略
 }
 
 // NewExpert会建立Expert的对象
@@ -54,7 +73,7 @@ func NewExpert() *Expert {
 	intentsManager := NewIntentManager()
 	defalutRnnModelPath := ""
 	defalutDataPath := ""
-	currentUser, err := user.Current() // todo 后续支持从配置文件读取配置
+	currentUser, err := user.Current() // 从配置文件读取配置见 config 包
 	if err != nil {
 		fmt.Printf("获取用户信息失败：%v\n", err)
 	} else {
@@ -66,13 +85,23 @@ func NewExpert() *Expert {
 		rnnIntentPath:        defalutRnnModelPath,
 		dataFilePath:         defalutDataPath,
 		onnxLibPath:          "",
-		userMessageInChan:    make(chan *TotalMessage, 1000),
-		programMessageInChan: make(chan *TotalMessage, 1000),
-		chatMessageInChan:    make(chan *TotalMessage, 1000),
+		dispatcherOnce:       &sync.Once{},
+		dispatchQueueSize:    defaultDispatchQueueSize,
 		saveInterval:         1 * time.Minute,
 		dialogs:              make(map[string]*DialogInfo),
 		dialogsMutex:         &sync.RWMutex{},
+		dialogSaved:          make(map[string]time.Time),
+		dialogIdleTimeout:    30 * time.Minute,
 		chatSaveHistoryLimit: 20,
+		stopChan:             make(chan struct{}),
+		stopOnce:             &sync.Once{},
+		runDone:              make(chan struct{}),
+		ready:                make(chan struct{}),
+		trainingRecorder:     &trainingRecorder{},
+		rnnReloadMutex:       &sync.Mutex{},
+		embeddingMutex:       &sync.Mutex{},
+		slots:                NewSlotRegistry(),
+		fallbacks:            newFallbackPolicies(),
 	}
 }
 
@@ -100,6 +129,100 @@ func (t *Expert) UnRegister(intentName string) {
 	t.intentMatch.UnRegister(intentName)
 }
 
+// SetIntentMinScore 设置意图匹配的全局最低分数，默认 0.9
+func (t *Expert) SetIntentMinScore(score float64) {
+	t.intentMatch.SetVaildMinScore(score)
+	logger.Info("Intent min score set to:", score)
+}
+
+// SetIntentThreshold 设置某个意图单独的最低分数，小于等于 0 时恢复使用全局最低分数
+func (t *Expert) SetIntentThreshold(intentName string, score float64) {
+	t.intentMatch.SetIntentThreshold(intentName, score)
+	logger.Infof("Intent %s threshold set to: %v", intentName, score)
+}
+
+// SetIntentWeight 设置某个意图的权重，匹配分数乘以权重后再和阈值比较
+func (t *Expert) SetIntentWeight(intentName string, weight float64) {
+	t.intentMatch.SetIntentWeight(intentName, weight)
+	logger.Infof("Intent %s weight set to: %v", intentName, weight)
+}
+
+// SetIntentMinMargin 设置第一名和第二名意图之间的最小分差，小于该值时让用户在候选意图中选择，为 0 时不检查
+func (t *Expert) SetIntentMinMargin(margin float64) {
+	t.intentMatch.SetMinMargin(margin)
+	logger.Info("Intent min margin set to:", margin)
+}
+
+// SetIntentClarifyMinScore 设置向用户确认意图的最低分数，默认 0.5。
+// 第一名意图低于自己的阈值但不低于该分数，且匹配器（实现 IntentClarifyInter）给出了澄清问题时，专家把问题发给用户，用户确认后交给该程序库
+func (t *Expert) SetIntentClarifyMinScore(score float64) {
+	t.intentMatch.SetClarifyMinScore(score)
+	logger.Info("Intent clarify min score set to:", score)
+}
+
+// SetIntentCacheMaxSize 设置意图缓存的最大条数，默认 10000，超出时淘汰最久未使用的条目，0 代表不限制
+func (t *Expert) SetIntentCacheMaxSize(size int) {
+	t.intentMatch.SetCacheMaxSize(size)
+	logger.Info("Intent cache max size set to:", size)
+}
+
+// SetIntentCacheTTL 设置意图缓存的有效期，默认 0 永不过期
+func (t *Expert) SetIntentCacheTTL(ttl time.Duration) {
+	t.intentMatch.SetCacheTTL(ttl)
+	logger.Info("Intent cache ttl set to:", ttl)
+}
+
+// ListIntentCache 按最近使用顺序返回所有意图缓存
+func (t *Expert) ListIntentCache() []IntentCacheEntry {
+	return t.intentMatch.ListCache()
+}
+
+// DeleteIntentCache 删除某条用户输入的意图缓存，返回是否存在
+func (t *Expert) DeleteIntentCache(content string) bool {
+	return t.intentMatch.DeleteCache(content)
+}
+
+// PinIntentCache 固定或取消固定某条用户输入的意图缓存，固定的条目不会过期也不会被淘汰，返回是否存在
+func (t *Expert) PinIntentCache(content string, pinned bool) bool {
+	return t.intentMatch.PinCache(content, pinned)
+}
+
+// AddIntentCache 手动添加一条用户输入到意图的缓存
+func (t *Expert) AddIntentCache(content string, intent string, pinned bool) {
+	t.intentMatch.AddCache(content, intent, pinned)
+}
+
+// SetIntentLearnAutoApproveScore 设置从多轮对话学习到的意图自动通过审核的分数。
+// 意图匹配器给用户第一句话的分数达到该值时直接写入意图缓存，默认 0 代表都需要调用 ApproveLearnedIntent 审核
+func (t *Expert) SetIntentLearnAutoApproveScore(score float64) {
+	t.intentMatch.SetLearnAutoApproveScore(score)
+	logger.Info("Intent learn auto approve score set to:", score)
+}
+
+// ListLearnedIntents 列出从多轮对话学习到的意图，status 为 LearnPending 等，为空时返回全部
+func (t *Expert) ListLearnedIntents(status string) []LearnedIntent {
+	return t.intentMatch.ListLearned(status)
+}
+
+// ApproveLearnedIntent 通过学习到的意图，写入意图缓存
+func (t *Expert) ApproveLearnedIntent(id string) error {
+	return t.intentMatch.ApproveLearned(id)
+}
+
+// RejectLearnedIntent 拒绝学习到的意图，已经写入意图缓存的会被删除
+func (t *Expert) RejectLearnedIntent(id string) error {
+	return t.intentMatch.RejectLearned(id)
+}
+
+// SetRecordTrainingSamples 设置是否记录程序库处理结果（2002 结束为正样本，2003 不支持为负样本），
+// 记录保存在数据目录的 trainingSamples.jsonl 中，用 ExportTrainingData 导出训练数据
+func (t *Expert) SetRecordTrainingSamples(enabled bool) {
+	t.trainingRecorder.mu.Lock()
+	t.trainingRecorder.enabled = enabled
+	t.trainingRecorder.mu.Unlock()
+	logger.Info("Record training samples set to:", enabled)
+}
+
 // SetDataFilePath设置专家的数据文件路径。
 func (t *Expert) SetDataFilePath(path string) {
 	t.dataFilePath = path
@@ -112,6 +235,18 @@ func (t *Expert) SetRNNIntentPath(path string) {
 	logger.Info("RNN intent path set to:", path)
 }
 
+// SetJiebaUserDict 设置 rnn 意图识别分词使用的 jieba 自定义词典，需要在 Run 之前设置
+func (t *Expert) SetJiebaUserDict(path string) {
+	SetJiebaUserDict(path)
+	logger.Info("Jieba user dict set to:", path)
+}
+
+// SetRNNInferConcurrency 设置 rnn 意图同时推理的数量上限，默认为 cpu 核数，需要在 Run 之前设置
+func (t *Expert) SetRNNInferConcurrency(n int) {
+	t.rnnInferConcurrency = n
+	logger.Info("RNN infer concurrency set to:", n)
+}
+
 // SetCommandFirst设置在多轮对话中命令是否优先。
 func (t *Expert) SetCommandFirst(commandFirst bool) {
 	t.commandFirst = commandFirst
@@ -130,150 +265,118 @@ func (t *Expert) SetSaveIntervalTime(interval time.Duration) {
 	logger.Info("Save interval time set to:", interval)
 }
 
-// 内部使用，获取默认保存dialog信息的路径
-func (t *Expert) defaultDialogPath() string {
+// 内部使用，旧版所有dialog 信息保存在一个文件中的路径，启动时迁移到 dialog 存储
+func (t *Expert) legacyDialogPath() string {
 	return filepath.Join(t.dataFilePath, "dailoginfo.json")
 }
 
+// 内部使用，默认的 dialog 文件存储目录
+func (t *Expert) defaultDialogStorePath() string {
+	return filepath.Join(t.dataFilePath, "dialogs")
+}
+
 // 内部使用，获取默认保存意图匹配缓存的路径
 func (t *Expert) defaultIntentMatchCachePath() string {
 	return filepath.Join(t.dataFilePath, "intentMatchCache.json")
 }
 
-// 设置保存dialog信息的处理函数，可以自定义保存逻辑，不会保存到默认文件路径，设置后会定时触发保存
-func (t *Expert) SetSaveDialogInfoHandler(handler func(map[string]*DialogInfo)) {
-	t.saveDialogInfoFunc = handler
-}
-
-// 设置加载dialog信息的处理函数，可以自定义加载逻辑，不会从默认文件路径加载，在 run 启动设置后会调用此函数加载
-func (t *Expert) SetLoadDialogInfoHandler(handler func() map[string]*DialogInfo) {
-	t.loadDialogInfoFunc = handler
+// 内部使用，获取默认保存学习意图审核队列的路径
+func (t *Expert) defaultIntentLearnPath() string {
+	return filepath.Join(t.dataFilePath, "intentLearnQueue.json")
 }
 
-// loadUserInfo 加载dialog信息到内存。
-func (t *Expert) loadDialogInfo() {
-	t.dialogsMutex.Lock()
-	defer t.dialogsMutex.Unlock()
-
-	if t.loadDialogInfoFunc != nil {
-		t.dialogs = t.loadDialogInfoFunc()
-		logger.Infof("Loaded %d user info records from custom loader.", len(t.dialogs))
-		return
-	}
-
-	if t.dataFilePath != "" {
-		data, err := os.ReadFile(t.defaultDialogPath())
-		if err != nil {
-			if os.IsNotExist(err) {
-				logger.Info("User info file not found, starting with empty data.")
-				return
-			}
-			logger.Errorf("Failed to read user info file: %v", err)
-			return
-		}
+// 定期保存有变化的dialog信息，并将空闲的dialog 移出内存
+func (t *Expert) periodicSave() {
+	ticker := time.NewTicker(t.saveInterval)
+	defer ticker.Stop()
 
-		if err := json.Unmarshal(data, &t.dialogs); err != nil {
-			logger.Errorf("Failed to unmarshal user info data: %v", err)
+	for {
+		select {
+		case <-ticker.C:
+			logger.Debug("Periodic save check")
+			t.ExpireIdleDialogs()
+			t.saveDialogs()
+			t.evictIdleDialogs()
+		case <-t.stopChan:
 			return
 		}
-
-		// Store the initial state of the data
-		initialData, err := json.MarshalIndent(t.dialogs, "", "  ")
-		if err == nil {
-			hash := md5.Sum(initialData)
-			t.lastSavedDialogInfoMd5 = hex.EncodeToString(hash[:])
-		}
-
-		logger.Infof("Loaded %d user info records.", len(t.dialogs))
 	}
-
 }
 
-// 保存daialog信息
-func (t *Expert) saveDialogInfo() {
-
-	if t.saveDialogInfoFunc != nil {
-		t.dialogsMutex.Lock()
-		t.saveDialogInfoFunc(t.dialogs)
-		t.dialogsMutex.Unlock()
-		logger.Info("Saved user info using custom saver.")
-		return
-	}
-	t.dialogsMutex.Lock()
-	data, err := json.MarshalIndent(t.dialogs, "", "  ")
-	t.dialogsMutex.Unlock()
-	if err != nil {
-		logger.Errorf("Failed to marshal user info data: %v", err)
-		return
-	}
-
-	hash := md5.Sum(data)
-	currentHash := hex.EncodeToString(hash[:])
-
-	if currentHash == t.lastSavedDialogInfoMd5 {
-		return // No changes to save
-	}
+// SetDispatchShards 设置消息队列的分片数量，默认 cpu 核数。同一个 dialog 的消息在一个分片中按顺序处理，不同分片并行处理，
+// 需要在 Run 和传入第一条消息之前设置
+func (t *Expert) SetDispatchShards(shards int) {
+	t.dispatchShards = shards
+	logger.Info("Dispatch shards set to:", shards)
+}
 
-	if err := os.WriteFile(t.defaultDialogPath(), data, 0644); err != nil {
-		logger.Errorf("Failed to write user info file: %v", err)
-	} else {
-		logger.Info("Save  user info successfully.")
-		t.lastSavedDialogInfoMd5 = currentHash
-	}
+// SetDispatchQueueSize 设置每个分片的队列长度，默认 256，用户消息在队列满时返回 ErrQueueFull，需要在 Run 和传入第一条消息之前设置
+func (t *Expert) SetDispatchQueueSize(size int) {
+	t.dispatchQueueSize = size
+	logger.Info("Dispatch queue size set to:", size)
 }
 
-// 定期保存dialog信息
-func (t *Expert) periodicSave() {
-	ticker := time.NewTicker(t.saveInterval)
-	defer ticker.Stop()
+func (t *Expert) getDispatcher() *dispatcher {
+	t.dispatcherOnce.Do(func() {
+		t.dispatcher = newDispatcher(t.dispatchShards, t.dispatchQueueSize)
+	})
+	return t.dispatcher
+}
 
-	for range ticker.C {
-		logger.Debug("Periodic save check")
-		t.saveDialogInfo()
+// enqueueMessage 将消息送入 dialog 所在分片的队列。用户消息在队列满时直接返回 ErrQueueFull，
+// 程序库和多轮对话的回复不能丢弃，等待队列有空位，专家关闭后返回 ErrExpertStopped
+func (t *Expert) enqueueMessage(handle func(*TotalMessage), message *TotalMessage, wait bool) error {
+	err := t.getDispatcher().submit(dispatchTask{handle: handle, message: message}, wait, t.stopChan)
+	if err != nil {
+		logger.Warnf("丢弃消息 dialog: %s event: %d: %v", message.DialogID, message.EventType, err)
 	}
+	return err
 }
 
-// HandleUserRequestMessage 用户传给专家的消息由此进入，可以传入多种格式。
-func (t *Expert) HandleUserRequestMessage(message any) {
-	logger.Debug("HandleUserRequestMessage received:", message)
-	var messagePointer *TotalMessage //todo 后面可以优化成启动前初始化很多个 TotalMessage 指针，避免频繁分配内存,需要根据并发量决定是否使用，低频环境可能现在更适用
-	var err error
+// decodeMessage 把传入的消息转换为 *TotalMessage，值和指针都会复制一份，避免外部修改影响
+func decodeMessage(message any) (*TotalMessage, error) {
 	switch v := message.(type) {
 	case TotalMessage:
 		// 复制值类型，取新地址
 		msg := v
-		messagePointer = &msg
+		return &msg, nil
 	case *TotalMessage:
 		if v == nil {
 			logger.Error("*TotalMessage 为 nil")
-			break
+			return nil, errUnsupportedMessage
 		}
 		// 复制指针指向的值，取新地址（避免外部修改影响）
 		msg := *v // 解引用并复制
-		messagePointer = &msg
+		return &msg, nil
 	case string:
 		var totalMsg TotalMessage
-		err = json.Unmarshal([]byte(v), &totalMsg)
-		if err == nil {
-			messagePointer = &totalMsg
-		} else {
+		if err := json.Unmarshal([]byte(v), &totalMsg); err != nil {
 			logger.Errorf("无法解析字符串消息为 TotalMessage  message: %v,  err: %v", v, err)
+			return nil, err
 		}
+		return &totalMsg, nil
 	case []byte:
 		var totalMsg TotalMessage
-		err = json.Unmarshal(v, &totalMsg)
-		if err == nil {
-			messagePointer = &totalMsg
-		} else {
+		if err := json.Unmarshal(v, &totalMsg); err != nil {
 			logger.Errorf("无法解析bytes消息为 TotalMessage  bytes: %v,  err: %v", string(v), err)
+			return nil, err
 		}
-
+		return &totalMsg, nil
 	default:
 		logger.Error("不支持的消息结构")
+		return nil, errUnsupportedMessage
 	}
-	if t.userMessageInChan != nil && messagePointer != nil && err == nil {
-		t.userMessageInChan <- messagePointer
+}
+
+// HandleUserRequestMessage 用户传给专家的消息由此进入，可以传入多种格式。
+// 消息无法解析时返回解析错误，队列已满时返回 ErrQueueFull，专家关闭后返回 ErrExpertStopped。
+func (t *Expert) HandleUserRequestMessage(message any) error {
+	logger.Debug("HandleUserRequestMessage received:", message)
+	messagePointer, err := decodeMessage(message) //todo 后面可以优化成启动前初始化很多个 TotalMessage 指针，避免频繁分配内存,需要根据并发量决定是否使用，低频环境可能现在更适用
+	if err != nil {
+		return err
 	}
+	return t.enqueueMessage(t.handleFromUserMessage, messagePointer, false)
 }
 
 // SetUserMessage 设置返回给用户的消息处理函数
@@ -281,47 +384,14 @@ func (t *Expert) SetToUserMessageHandler(handler func(TotalMessage, string)) {
 	t.userMessageHandler = handler
 }
 
-// HandleProgramRequestMessage  程序库（工具）传给专家的消息由此进入
-func (t *Expert) HandleProgramRequestMessage(message any) {
+// HandleProgramRequestMessage  程序库（工具）传给专家的消息由此进入，队列已满时等待
+func (t *Expert) HandleProgramRequestMessage(message any) error {
 	logger.Debug("HandleProgramRequestMessage received:", message)
-	var messagePointer *TotalMessage
-	var err error
-	switch v := message.(type) {
-	case TotalMessage:
-		// 复制值类型，取新地址
-		msg := v
-		messagePointer = &msg
-	case *TotalMessage:
-		if v == nil {
-			logger.Error("*TotalMessage 为 nil")
-			break
-		}
-		// 复制指针指向的值，取新地址（避免外部修改影响）
-		msg := *v // 解引用并复制
-		messagePointer = &msg
-	case string:
-		var totalMsg TotalMessage
-		err = json.Unmarshal([]byte(v), &totalMsg)
-		if err == nil {
-			messagePointer = &totalMsg
-		} else {
-			logger.Errorf("无法解析字符串消息为 TotalMessage  message: %v,  err: %v", v, err)
-		}
-	case []byte:
-		var totalMsg TotalMessage
-		err = json.Unmarshal(v, &totalMsg)
-		if err == nil {
-			messagePointer = &totalMsg
-		} else {
-			logger.Errorf("无法解析bytes消息为 TotalMessage  bytes: %v,  err: %v", string(v), err)
-		}
-
-	default:
-		logger.Error("不支持的消息结构")
-	}
-	if t.programMessageInChan != nil && messagePointer != nil && err == nil {
-		t.programMessageInChan <- messagePointer
+	messagePointer, err := decodeMessage(message)
+	if err != nil {
+		return err
 	}
+	return t.enqueueMessage(t.handleFromProgramMessage, messagePointer, true)
 }
 
 // SetUserMessage 设置返回给工具的消息处理函数
@@ -329,47 +399,14 @@ func (t *Expert) SetToProgramMessageHandler(handler func(TotalMessage, string))
 	t.programMessageHandler = handler
 }
 
-// HandleChatRequestMessage  多轮对话传给专家的消息由此进入
-func (t *Expert) HandleChatRequestMessage(message any) {
+// HandleChatRequestMessage  多轮对话传给专家的消息由此进入，队列已满时等待
+func (t *Expert) HandleChatRequestMessage(message any) error {
 	logger.Debug("HandleChatRequestMessage received:", message)
-	var messagePointer *TotalMessage
-	var err error
-	switch v := message.(type) {
-	case TotalMessage:
-		// 复制值类型，取新地址
-		msg := v
-		messagePointer = &msg
-	case *TotalMessage:
-		if v == nil {
-			logger.Error("*TotalMessage 为 nil")
-			break
-		}
-		// 复制指针指向的值，取新地址（避免外部修改影响）
-		msg := *v // 解引用并复制
-		messagePointer = &msg
-	case string:
-		var totalMsg TotalMessage
-		err = json.Unmarshal([]byte(v), &totalMsg)
-		if err == nil {
-			messagePointer = &totalMsg
-		} else {
-			logger.Errorf("无法解析字符串消息为 TotalMessage  message: %v,  err: %v", v, err)
-		}
-	case []byte:
-		var totalMsg TotalMessage
-		err = json.Unmarshal(v, &totalMsg)
-		if err == nil {
-			messagePointer = &totalMsg
-		} else {
-			logger.Errorf("无法解析bytes消息为 TotalMessage  bytes: %v,  err: %v", string(v), err)
-		}
-
-	default:
-		logger.Error("不支持的消息结构")
-	}
-	if t.chatMessageInChan != nil && messagePointer != nil && err == nil {
-		t.chatMessageInChan <- messagePointer
+	messagePointer, err := decodeMessage(message)
+	if err != nil {
+		return err
 	}
+	return t.enqueueMessage(t.handleFromChatMessage, messagePointer, true)
 }
 
 // SetToChatMessageHandler 设置返回给多轮对话的消息处理函数
@@ -384,329 +421,154 @@ func (t *Expert) getRNNIntentMangerFromFile() {
 		rnnManager := NewRNNIntentManager()
 		rnnManager.SetRNNModelPath(t.rnnIntentPath)
 		rnnManager.SetLibPath(t.onnxLibPath)
+		rnnManager.SetInferConcurrency(t.rnnInferConcurrency)
 		rnnManager.LoadRNNModelIntents()
 
-		for name, intent := range rnnManager.GetAllRNNIntents() {
-			t.intentMatch.Register(intent.GetIntentExpertMatch, name)
+		for _, setting := range rnnManager.Settings() {
+			if t.intentMatch.matcher(setting.Name) != nil {
+				if !setting.MultiClass {
+					logger.Warnf("Intent %s is registered by code, skip loading rnn model with the same name.", setting.Name)
+					rnnManager.UnloadRNNIntent(setting.Name)
+					continue
+				}
+				// 多分类模型中的其他意图仍然可用，同名意图取代码注册的匹配器和模型中的最高分
+				logger.Warnf("Intent %s of multi-class model %s is also registered by code.", setting.Name, setting.Model)
+				continue
+			}
+			t.intentMatch.SetIntentWeight(setting.Name, float64(setting.Weight))
+			if setting.Threshold > 0 {
+				t.intentMatch.SetIntentThreshold(setting.Name, setting.Threshold)
+			}
 		}
+		// 所有 rnn 意图作为一个整体注册，每次匹配只分词一次
+		t.intentMatch.RegisterGroup(rnnGroupName, rnnManager)
 		t.rnnIntent = rnnManager
 		logger.Info("RNN Intent Manager initialized and intents registered.")
 	}
 }
 
-// 前台占用启动专家实例。
+// 前台占用启动专家实例，调用 Shutdown 后停止接收消息，由 Shutdown 等待排队的消息处理完。
 func (t *Expert) Run() {
+	select {
+	case <-t.stopChan:
+		logger.Warn("Expert has been shut down, Run ignored.")
+		return
+	default:
+	}
+	t.running.Store(true)
+	defer close(t.runDone)
+
 	logger.Info("Expert is running...")
 	t.getRNNIntentMangerFromFile()
 	if t.dataFilePath != "" {
 		// 如果设置了数据文件路径，则加载意图缓存，并启动定期保存
 		logger.Info("Loading intent cache from path:", t.defaultIntentMatchCachePath())
 		t.intentMatch.SetCacheFilePath(t.defaultIntentMatchCachePath())
+		t.intentMatch.SetLearnFilePath(t.defaultIntentLearnPath())
+		t.trainingRecorder.mu.Lock()
+		t.trainingRecorder.filePath = filepath.Join(t.dataFilePath, trainingSamplesFile)
+		t.trainingRecorder.mu.Unlock()
 		t.intentMatch.LoadIntentCache()
 		go t.intentMatch.PeriodicCacheSave(t.saveInterval)
 	}
 
-	t.loadDialogInfo()
-	go t.periodicSave()
-
-	for {
-		select {
-		case userMsg := <-t.userMessageInChan:
-			go t.handleFromUserMessage(userMsg)
-		case programMsg := <-t.programMessageInChan:
-			go t.handleFromProgramMessage(programMsg)
-		case chatMsg := <-t.chatMessageInChan:
-			go t.handleFromChatMessage(chatMsg)
-		}
+	if t.dialogStore == nil && t.dataFilePath != "" {
+		t.dialogStore = NewFileDialogStore(t.defaultDialogStorePath())
 	}
-	// 启动逻辑的占位符
-}
-
-// type ExpertToChatMessage = types.ExpertToChatMessage
-// type ExpertToProgramMessage = types.ExpertToProgramMessage
-
-func (t *Expert) handleFromUserMessage(message *TotalMessage) {
-	dialogx, exists := t.dialogs[message.DialogID]
-	if !exists {
-		dialogx = &DialogInfo{
-			UserID:      message.UserId,
-			DialogID:    message.DialogID,
-			Program:     "",
-			ChatHistory: make([]string, 0),
-		}
-		t.dialogsMutex.Lock()
-		t.dialogs[message.DialogID] = dialogx
-		t.dialogsMutex.Unlock()
+	t.migrateLegacyDialogs()
+	go t.periodicSave()
+	if t.watchRNNIntent && t.rnnIntent != nil && t.rnnIntentPath != "" {
+		go t.watchRNNIntentPath()
 	}
-	dialogx.RWMutex.Lock()
-	defer dialogx.RWMutex.Unlock()
-	switch message.EventType {
-	case 1001: // 客户端发送消息
-
-		logger.Infof("【用户提问】:%s", message.Messages.Content)
-
-		// 如果从程序库返回消息 2003 不支持，且原封不动返回给专家，则不加入历史记录，避免重复
-		historyLen := len(dialogx.ChatHistory)
-		if historyLen > 0 && dialogx.ChatHistory[historyLen-1] == "User: "+message.Messages.Content {
-			// 重复消息，不添加到历史记录
-		} else {
-			dialogx.ChatHistory = append(dialogx.ChatHistory, "User: "+message.Messages.Content)
-		}
-
-		if len(dialogx.ChatHistory) > t.chatSaveHistoryLimit {
-			dialogx.ChatHistory = dialogx.ChatHistory[len(dialogx.ChatHistory)-t.chatSaveHistoryLimit:]
-		}
-
-		// if messageEvent.Intention != "" {
-		// 	userInfo.Expert = messageEvent.Intention
-		// 	if userInfo.FirstMutil {
-		// 		expert.CacheContentIntent(messageEvent.Messages.Content, messageEvent.Intention)
-		// 	}
-		// }
-
-		if dialogx.Program == "" { // 还没有分配到程序库
-			logger.Debug("为其寻找合适的程序库")
-			var possibleIntentions []PossibleIntentions
-			bestProgram, possibleIntentions := t.intentMatch.FindBestIntent(message.Messages.Content, message.Messages.Attachments, !dialogx.Mutil)
-
-			var gotoMutil bool // 是否要走多轮对话
-			if dialogx.Mutil { // 如果在多轮中
-				if bestProgram != "" && t.commandFirst { // 找到合适的程序库并且是命令优先就去走程序库
-					gotoMutil = false
-					dialogx.Program = bestProgram
-				} else { // 否则给多轮对话
-					gotoMutil = true
-				}
-			} else { // 如果不在多轮中
-				if bestProgram != "" {
-					gotoMutil = false
-					dialogx.Program = bestProgram
-				} else {
-					gotoMutil = true
-				}
-			}
-			if gotoMutil { // 去走多轮对话
-				logger.Debug("分配给多轮对话识别")
-
-				// 用户说的第一句话没有匹配到意图，需要多轮识别，仅限一个场景中的第一句话用以存储意图识别识别不到而多轮识别到存入缓存
-				dialogx.FirstMutil = !dialogx.Mutil
-				dialogx.Mutil = true
-
-				// toChatMessage := ExpertToChatMessage{
-				// 	EventType:          1001,
-				// 	DialogID:           message.DialogID,
-				// 	MessageID:          message.MessageID,
-				// 	UserId:             message.UserId,
-				// 	PossibleIntentions: possibleIntentions,
-				// 	Messages: struct {
-				// 		Content     string             `json:"content"`
-				// 		Attachments []types.Attachment `json:"attachments"`
-				// 		History     []string           `json:"history,omitempty"`
-				// 	}{
-				// 		Content:     message.Messages.Content,
-				// 		Attachments: message.Messages.Attachments,
-				// 		History:     dialogx.ChatHistory,
-				// 	},
-				// }
-
-				toChatMessage := *message
-				toChatMessage.PossibleIntentions = possibleIntentions
-				toChatMessage.Messages.History = dialogx.ChatHistory
-
-				msg, err := json.Marshal(toChatMessage)
-				if err != nil {
-					logger.Error("Failed to marshal chat message: %v", err)
-				}
-				t.chatMessageHandler(toChatMessage, string(msg))
-				return
-			} else {
-				dialogx.FirstMutil = false
-				dialogx.Mutil = false
-
-				// toProgramMessage := ExpertToProgramMessage{
-				// 	EventType: 1001,
-				// 	DialogID:  message.DialogID,
-				// 	MessageID: message.MessageID,
-				// 	UserId:    message.UserId,
-				// 	Intention: dialogx.Program,
-				// 	Messages: struct {
-				// 		Content     string             `json:"content"`
-				// 		Attachments []types.Attachment `json:"attachments"`
-				// 	}{
-				// 		Content:     message.Messages.Content,
-				// 		Attachments: message.Messages.Attachments,
-				// 	},
-				// }
-
-				toProgramMessage := *message
-				toProgramMessage.Intention = dialogx.Program
-
-				msg, err := json.Marshal(toProgramMessage)
-				if err != nil {
-					logger.Error("Failed to marshal client message: %v", err)
-				}
-				logger.Debug("分配到程序库:", dialogx.Program)
-				t.programMessageHandler(toProgramMessage, string(msg))
-				return
-			}
-		} else {
-			dialogx.FirstMutil = false
-			logger.Debug("继续使用当前程序库:", dialogx.Program)
-
-			// toProgramMessage := ExpertToProgramMessage{
-			// 	EventType: 1001,
-			// 	DialogID:  message.DialogID,
-			// 	MessageID: message.MessageID,
-			// 	UserId:    message.UserId,
-			// 	Intention: dialogx.Program,
-			// 	Messages: struct {
-			// 		Content     string             `json:"content"`
-			// 		Attachments []types.Attachment `json:"attachments"`
-			// 	}{
-			// 		Content:     message.Messages.Content,
-			// 		Attachments: message.Messages.Attachments,
-			// 	},
-			// }
-
-			toProgramMessage := *message
-			toProgramMessage.Intention = dialogx.Program
-
-			msg, err := json.Marshal(toProgramMessage)
-			if err != nil {
-				logger.Error("Failed to marshal client message: %v", err)
-			}
-			logger.Debug("分配到程序库:", dialogx.Program)
-			t.programMessageHandler(toProgramMessage, string(msg))
-			return
-		}
-	case 1002: // 客户端终止对话
-		if dialogx.Program == "" {
-			return
-		}
-		msg, err := json.Marshal(message)
-		if err != nil {
-			logger.Error("Failed to marshal client message: %v", err)
-		}
-		toProgramMessage := *message
-		t.programMessageHandler(toProgramMessage, string(msg))
-		dialogx.Program = ""
 
-	default:
-		logger.Debugf("收到未知事件类型: %d", message.EventType)
-	}
+	dispatcherx := t.getDispatcher()
+	dispatcherx.start()
+	close(t.ready)
+	<-t.stopChan
+	// 不再接收新消息，分片中已经排队的消息处理完后处理协程退出
+	dispatcherx.close()
+	logger.Info("Expert stopped.")
 }
 
-func (t *Expert) handleFromProgramMessage(message *TotalMessage) {
-	logger.Debug("收到程序库消息:", *message)
+// Ready 返回的通道在 Run 加载完模型、意图缓存和 dialog 存储并开始处理消息后关闭，
+// 在这之前不要调用 dialog 管理相关的方法。Run 没有启动或者启动前已经 Shutdown 时不会关闭
+func (t *Expert) Ready() <-chan struct{} {
+	return t.ready
+}
 
-	dialogx, exists := t.dialogs[message.DialogID]
-	if !exists {
-		// 用户id 未记录，直接返回
-		return
-	}
-	dialogx.RWMutex.Lock()
-	defer dialogx.RWMutex.Unlock()
-	switch message.EventType {
-	case types.EventServerMessage: // 客户端发送消息
-
-		logger.Infof("【回复用户】:%s", message.Messages.Content)
-		dialogx.ChatHistory = append(dialogx.ChatHistory, "Progarm: "+message.Messages.Content)
-		// chatHistory 最大保存 t.chatSaveHistoryLimit 条信息
-		if len(dialogx.ChatHistory) > t.chatSaveHistoryLimit {
-			dialogx.ChatHistory = dialogx.ChatHistory[len(dialogx.ChatHistory)-t.chatSaveHistoryLimit:]
-		}
+// Shutdown 停止接收新消息，处理完排队和正在执行的消息后，将dialog 信息和意图缓存写入磁盘。
+// ctx 超时后仍会尝试保存数据，并返回 ctx 的错误。
+func (t *Expert) Shutdown(ctx context.Context) error {
+	logger.Info("Expert is shutting down...")
+	t.stopOnce.Do(func() {
+		close(t.stopChan)
+	})
 
-		toUserMessage := *message
-		msg, err := json.Marshal(toUserMessage)
-		if err != nil {
-			logger.Error("Failed to marshal chat message: %v", err)
+	var waitErr error
+	if t.running.Load() {
+		select {
+		case <-t.runDone:
+		case <-ctx.Done():
+			waitErr = ctx.Err()
 		}
-		t.userMessageHandler(toUserMessage, string(msg))
+	}
 
-	case types.EventToolFinish: // 客户端终止对话
-		toUserMessage := *message
-		msg, err := json.Marshal(toUserMessage)
-		if err != nil {
-			logger.Error("Failed to marshal chat message: %v", err)
-		}
-		t.userMessageHandler(toUserMessage, string(msg))
-		dialogx.Program = ""
-
-	case types.EventToolNotSupport: // 专家不支持该能力需要重新分配一个专家
-		dialogx.Program = ""
-		t.handleFromUserMessage(message)
-
-	case types.EventToolNotFound:
-		toUserMessage := *message
-		msg, err := json.Marshal(toUserMessage)
-		if err != nil {
-			logger.Error("Failed to marshal chat message: %v", err)
+	if waitErr == nil {
+		handlersDone := make(chan struct{})
+		go func() {
+			t.getDispatcher().wait()
+			close(handlersDone)
+		}()
+		select {
+		case <-handlersDone:
+		case <-ctx.Done():
+			waitErr = ctx.Err()
 		}
-		t.userMessageHandler(toUserMessage, string(msg))
-		dialogx.Program = ""
-	default:
-		logger.Debugf("收到未知事件类型: %d", message.EventType)
 	}
-}
-
-func (t *Expert) handleFromChatMessage(message *TotalMessage) {
-
-	logger.Debug("收到多轮对话:", *message)
-
-	dialogx, exists := t.dialogs[message.DialogID]
-	if !exists {
-		// 用户id 未注册
-		return
+	if waitErr != nil {
+		logger.Warnf("Expert shutdown wait interrupted: %v, flushing data anyway.", waitErr)
 	}
-	dialogx.RWMutex.Lock()
-	defer dialogx.RWMutex.Unlock()
-	switch message.EventType {
-	case 1001: // 多轮对话总结用户的需求，使用1001 代表用户返回请求专家
-		dialogx.Mutil = false
-		t.handleFromUserMessage(message)
-	case 2001: // 客户端发送消息
-
-		logger.Infof("【回复用户】:%s", message.Messages.Content)
-		dialogx.ChatHistory = append(dialogx.ChatHistory, "Chat: "+message.Messages.Content)
-		// chatHistory 最大保存20条信息
-		if len(dialogx.ChatHistory) > t.chatSaveHistoryLimit {
-			dialogx.ChatHistory = dialogx.ChatHistory[len(dialogx.ChatHistory)-t.chatSaveHistoryLimit:]
-		}
 
-		toUserMessage := *message
-		msg, err := json.Marshal(toUserMessage)
-		if err != nil {
-			logger.Error("Failed to marshal chat message: %v", err)
+	t.saveDialogs()
+	if t.dialogStore != nil {
+		if err := t.dialogStore.Close(); err != nil {
+			logger.Warnf("Failed to close dialog store: %v", err)
 		}
-		t.userMessageHandler(toUserMessage, string(msg))
-
-	default:
-		logger.Debugf("收到未知事件类型: %d", message.EventType)
 	}
+	t.intentMatch.StopPeriodicCacheSave()
+	if t.dataFilePath != "" {
+		t.intentMatch.SaveIntentCache()
+	}
+	logger.Info("Expert shut down.")
+	return waitErr
 }
 
 // GetAllIntentNames returns all intent names.
 func (t *Expert) GetAllIntentNames() []string {
 	logger.Debug("Getting all intent names")
-	matchers := t.intentMatch.GetALLNewIntentMatcher()
-	names := make([]string, 0, len(matchers))
-	for _, m := range matchers {
-		if m == nil {
-			names = append(names, "")
-			continue
-		}
-		names = append(names, m.GetIntentName())
-	}
-	return names
+	return t.intentMatch.IntentNames()
 }
 
-// UpdateIntentMatcher 从设置的路径更新意图匹配器。
+// UpdateIntentMatcherFromRNNPath 从设置的路径逐个重新加载 rnn 意图，新增的目录会加载，删除的目录会注销，
+// 每个意图验证通过后才替换，加载失败的保留旧模型。
 func (t *Expert) UpdateIntentMatcherFromRNNPath() {
 	logger.Info("Updating RNN intent matcher...")
-	// 实际逻辑的占位符
-	for name := range t.rnnIntent.GetAllRNNIntents() {
-		t.UnRegister(name)
+	if t.rnnIntent == nil {
+		t.getRNNIntentMangerFromFile()
+		return
+	}
+	names := make(map[string]bool)
+	for _, name := range t.rnnIntent.ModelNames() {
+		names[name] = true
+	}
+	if entries, err := os.ReadDir(t.rnnIntentPath); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				names[entry.Name()] = true
+			}
+		}
+	}
+	for name := range names {
+		t.reloadRNNIntent(name)
 	}
-	t.rnnIntent = nil
-	t.getRNNIntentMangerFromFile()
 }
@@ -0,0 +1,32 @@
+package experts
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunReady(t *testing.T) {
+	expert := NewExpert()
+	expert.SetDataFilePath(t.TempDir())
+	expert.SetRNNIntentPath(t.TempDir())
+	select {
+	case <-expert.Ready():
+		t.Fatal("Ready() closed before Run")
+	default:
+	}
+
+	go expert.Run()
+	select {
+	case <-expert.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ready() not closed after Run started")
+	}
+	// Ready 之后 dialog 存储已经设置好，可以从其他协程读取
+	if _, err := expert.ListDialogs(DialogFilter{}); err != nil {
+		t.Errorf("ListDialogs() error = %v", err)
+	}
+	if err := expert.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
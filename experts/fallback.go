@@ -0,0 +1,117 @@
+package experts
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	FallbackChat    = "chat"    // 交给多轮对话，默认策略
+	FallbackProgram = "program" // 交给 Program 指定的默认程序库
+	FallbackReply   = "reply"   // 直接回复 Reply
+	FallbackHuman   = "human"   // 由人工接管 dialog，交给 SetToHumanMessageHandler 设置的人工处理
+)
+
+// defaultFallbackReply 策略对应的模块没有设置且没有配置 Reply 时回复用户的话
+const defaultFallbackReply = "抱歉，暂时无法处理您的请求，请稍后再试。"
+
+// FallbackPolicy 识别不到意图时的处理策略
+type FallbackPolicy struct {
+	Type    string `json:"type" yaml:"type"`                           // 见 FallbackChat 等，为空时为 FallbackChat
+	Program string `json:"program,omitempty" yaml:"program,omitempty"` // FallbackProgram 时交给的程序库
+	Reply   string `json:"reply,omitempty" yaml:"reply,omitempty"`     // FallbackReply 时回复的话，也用于策略对应的模块没有设置时
+}
+
+// Validate 检查策略类型和必填字段
+func (p FallbackPolicy) Validate() error {
+	switch p.Type {
+	case "", FallbackChat, FallbackHuman:
+	case FallbackProgram:
+		if p.Program == "" {
+			return fmt.Errorf("fallback program is required for %s policy", FallbackProgram)
+		}
+	case FallbackReply:
+		if p.Reply == "" {
+			return fmt.Errorf("fallback reply is required for %s policy", FallbackReply)
+		}
+	default:
+		return fmt.Errorf("unknown fallback type %q", p.Type)
+	}
+	return nil
+}
+
+// fallbackPolicies 按平台保存的策略，空字符串为默认策略
+type fallbackPolicies struct {
+	mu       *sync.RWMutex
+	policies map[string]FallbackPolicy
+}
+
+func newFallbackPolicies() *fallbackPolicies {
+	return &fallbackPolicies{
+		mu:       &sync.RWMutex{},
+		policies: make(map[string]FallbackPolicy),
+	}
+}
+
+// get 返回平台的策略，平台没有单独设置时返回默认策略
+func (f *fallbackPolicies) get(platform string) FallbackPolicy {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if policy, ok := f.policies[platform]; ok {
+		return policy
+	}
+	return f.policies[""]
+}
+
+// SetFallbackPolicy 设置识别不到意图时的处理策略，platform 对应 DialogInfo.Platform（用户消息中的 platform），
+// 为空字符串时设置默认策略，默认交给多轮对话
+func (t *Expert) SetFallbackPolicy(platform string, policy FallbackPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	t.fallbacks.mu.Lock()
+	t.fallbacks.policies[platform] = policy
+	t.fallbacks.mu.Unlock()
+	logger.Infof("Fallback policy of platform %q set to: %s", platform, policy.Type)
+	return nil
+}
+
+// fallback 识别不到意图时按 dialog 所在平台的策略处理，策略对应的模块没有设置时回复用户 Reply 或默认的话
+func (t *Expert) fallback(dialogx *DialogInfo, message *TotalMessage, possibleIntentions []PossibleIntentions) {
+	policy := t.fallbacks.get(dialogx.Platform)
+	switch policy.Type {
+	case FallbackProgram:
+		if t.programMessageHandler != nil {
+			logger.Debugf("没有识别到意图，交给默认程序库 %s", policy.Program)
+			dialogx.Program = policy.Program
+			dialogx.FirstMutil = false
+			dialogx.FirstMutilContent = ""
+			dialogx.Mutil = false
+			t.routeToProgram(dialogx, message)
+			return
+		}
+	case FallbackReply:
+		logger.Debug("没有识别到意图，回复固定消息")
+		t.replyToUser(dialogx, message, policy.Reply, possibleIntentions)
+		return
+	case FallbackHuman:
+		if t.humanMessageHandler != nil {
+			logger.Debug("没有识别到意图，交给人工处理")
+			t.takeoverDialog(dialogx)
+			t.forwardToHuman(dialogx, message, possibleIntentions)
+			return
+		}
+	default:
+		if t.chatMessageHandler != nil {
+			t.forwardToChat(dialogx, message, possibleIntentions)
+			return
+		}
+	}
+
+	logger.Warnf("dialog %s 平台 %q 的识别失败策略 %q 对应的模块没有设置，直接回复用户", dialogx.DialogID, dialogx.Platform, policy.Type)
+	reply := policy.Reply
+	if reply == "" {
+		reply = defaultFallbackReply
+	}
+	t.replyToUser(dialogx, message, reply, possibleIntentions)
+}
@@ -0,0 +1,117 @@
+package experts
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/huihui4754/expertlib/types"
+)
+
+// HandleHumanRequestMessage  人工（客服）传给专家的消息由此进入，4001 接管、4002 回复用户、4003 交还，队列已满时等待
+func (t *Expert) HandleHumanRequestMessage(message any) error {
+	logger.Debug("HandleHumanRequestMessage received:", message)
+	messagePointer, err := decodeMessage(message)
+	if err != nil {
+		return err
+	}
+	return t.enqueueMessage(t.handleFromHumanMessage, messagePointer, true)
+}
+
+// SetToHumanMessageHandler 设置返回给人工的消息处理函数，人工接管期间的用户消息（1001、1002）由此发给人工，
+// dialog 被重置或删除而结束人工接管时发送 4003
+func (t *Expert) SetToHumanMessageHandler(handler func(TotalMessage, string)) {
+	t.humanMessageHandler = handler
+}
+
+func (t *Expert) handleFromHumanMessage(message *TotalMessage) {
+	logger.Debug("收到人工消息:", *message)
+	t.handleDialogEvent(fromHuman, message)
+}
+
+// takeoverDialog 结束程序会话、多轮对话和待确认状态后由人工接管
+func (t *Expert) takeoverDialog(dialogx *DialogInfo) {
+	// 已经由人工接管时再次接管不需要通知人工结束
+	dialogx.Human = false
+	t.resetDialog(dialogx)
+	dialogx.Human = true
+}
+
+// releaseHuman 专家结束人工接管（重置、删除 dialog）时给人工发送 4003，人工据此结束该 dialog 的会话
+func (t *Expert) releaseHuman(dialogx *DialogInfo) {
+	if t.humanMessageHandler == nil {
+		return
+	}
+	release := TotalMessage{
+		EventType: types.EventHumanRelease,
+		DialogID:  dialogx.DialogID,
+		UserId:    dialogx.UserID,
+		MessageID: uuid.New().String(),
+	}
+	msg, err := json.Marshal(release)
+	if err != nil {
+		logger.Errorf("Failed to marshal human release message: %v", err)
+	}
+	t.humanMessageHandler(release, string(msg))
+}
+
+// forwardToHuman 把用户消息连同历史记录交给人工，没有设置人工处理函数时退出人工接管并返回 false
+func (t *Expert) forwardToHuman(dialogx *DialogInfo, message *TotalMessage, possibleIntentions []PossibleIntentions) bool {
+	if t.humanMessageHandler == nil {
+		logger.Warnf("没有设置返回给人工的消息处理函数，dialog %s 退出人工接管", dialogx.DialogID)
+		dialogx.Human = false
+		return false
+	}
+	toHumanMessage := *message
+	toHumanMessage.PossibleIntentions = possibleIntentions
+	toHumanMessage.Messages.History = types.LegacyHistory(dialogx.ChatHistory)
+	toHumanMessage.Messages.HistoryEntries = dialogx.ChatHistory
+	msg, err := json.Marshal(toHumanMessage)
+	if err != nil {
+		logger.Errorf("Failed to marshal human message: %v", err)
+	}
+	t.humanMessageHandler(toHumanMessage, string(msg))
+	return true
+}
+
+// onHumanTakeover 人工 4001，接管 dialog，消息内容不为空时作为人工的第一句回复发给用户
+func (t *Expert) onHumanTakeover(dialogx *DialogInfo, message *TotalMessage) {
+	logger.Infof("dialog %s 由人工接管", dialogx.DialogID)
+	t.takeoverDialog(dialogx)
+	if message.Messages.Content != "" || len(message.Messages.Attachments) > 0 {
+		t.onHumanReply(dialogx, message)
+	}
+}
+
+// onHumanReply 人工 4002，以 2001 转发给用户并记录到历史
+func (t *Expert) onHumanReply(dialogx *DialogInfo, message *TotalMessage) {
+	reply := *message
+	reply.EventType = types.EventServerMessage
+	reply.UserId = dialogx.UserID
+	logger.Infof("【人工回复】:%s", reply.Messages.Content)
+	t.appendHistory(dialogx, types.NewHistoryEntry(types.HistorySourceHuman, "", &reply))
+	t.sendToUser(&reply)
+}
+
+// onHumanRelease 人工 4003，交还 dialog。intention 不为空时分配该程序库，消息内容不为空时作为用户的需求交给程序库，
+// 否则用户的下一句话交给程序库；intention 为空时用户的下一句话重新识别意图
+func (t *Expert) onHumanRelease(dialogx *DialogInfo, message *TotalMessage) {
+	dialogx.Human = false
+	if message.Intention == "" {
+		logger.Infof("dialog %s 人工交还", dialogx.DialogID)
+		return
+	}
+	logger.Infof("dialog %s 人工交还，分配程序库 %s", dialogx.DialogID, message.Intention)
+	dialogx.Program = message.Intention
+	dialogx.Mutil = false
+	dialogx.FirstMutil = false
+	dialogx.FirstMutilContent = ""
+	if message.Messages.Content == "" {
+		return
+	}
+	routed := *message
+	routed.EventType = types.EventUserMessage
+	routed.UserId = dialogx.UserID
+	routed.Intention = ""
+	routed.Slots = nil
+	t.routeToProgram(dialogx, &routed)
+}
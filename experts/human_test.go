@@ -0,0 +1,60 @@
+package experts
+
+import (
+	"testing"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+func TestResetDialogNotifiesHuman(t *testing.T) {
+	tests := []struct {
+		name     string
+		human    bool
+		reset    func(expert *Expert) error
+		wantSent []int
+	}{
+		{"reset human dialog", true, func(e *Expert) error { return e.ResetDialog("d1") }, []int{types.EventHumanRelease}},
+		{"delete human dialog", true, func(e *Expert) error { return e.DeleteDialog("d1") }, []int{types.EventHumanRelease}},
+		{"reset dialog without human", false, func(e *Expert) error { return e.ResetDialog("d1") }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expert, _ := newStoreTestExpert(t)
+			var sent []int
+			expert.SetToHumanMessageHandler(func(message TotalMessage, raw string) {
+				if message.DialogID != "d1" || message.UserId != "user-1" {
+					t.Errorf("human message = %+v, want dialog d1 of user-1", message)
+				}
+				sent = append(sent, message.EventType)
+			})
+			dialogx := expert.lockDialog("d1", newTestDialog, true)
+			dialogx.Human = tt.human
+			dialogx.RWMutex.Unlock()
+
+			if err := tt.reset(expert); err != nil {
+				t.Fatal(err)
+			}
+			if len(sent) != len(tt.wantSent) || (len(sent) > 0 && sent[0] != tt.wantSent[0]) {
+				t.Errorf("sent to human %v, want %v", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestTakeoverHumanDialogDoesNotRelease(t *testing.T) {
+	expert, _ := newStoreTestExpert(t)
+	var sent []int
+	expert.SetToHumanMessageHandler(func(message TotalMessage, raw string) {
+		sent = append(sent, message.EventType)
+	})
+	dialogx := expert.lockDialog("d1", newTestDialog, true)
+	defer dialogx.RWMutex.Unlock()
+	dialogx.Human = true
+	expert.takeoverDialog(dialogx)
+	if !dialogx.Human {
+		t.Error("Human = false after takeover")
+	}
+	if len(sent) != 0 {
+		t.Errorf("sent to human %v on repeated takeover, want nothing", sent)
+	}
+}
@@ -0,0 +1,265 @@
+package experts
+
+import (
+	"container/list"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	CacheSourceMatcher = "matcher" // 由意图匹配器匹配得到
+	CacheSourceLLM     = "llm"     // 由多轮对话大模型识别得到
+	CacheSourceManual  = "manual"  // 手动添加
+
+	defaultIntentCacheMaxSize = 10000
+)
+
+// IntentCacheEntry 意图缓存条目
+type IntentCacheEntry struct {
+	Content   string    `json:"content"`        // 归一化后的用户输入
+	Text      string    `json:"text,omitempty"` // 送入意图匹配器的文本（经过消息格式化函数处理），用于导出训练数据，旧版本的条目为空
+	Intent    string    `json:"intent"`
+	Source    string    `json:"source"` // 见 CacheSourceMatcher 等
+	HitCount  int64     `json:"hit_count"`
+	Pinned    bool      `json:"pinned,omitempty"` // 固定的条目不会过期也不会被淘汰
+	CreatedAt time.Time `json:"created_at"`
+	LastHitAt time.Time `json:"last_hit_at,omitzero"`
+}
+
+// IntentCache 带容量上限（LRU 淘汰）和过期时间的意图缓存，key 为归一化后的用户输入
+type IntentCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // 最近使用的在前
+	maxSize int        // 0 代表不限制
+	ttl     time.Duration
+}
+
+func NewIntentCache(maxSize int, ttl time.Duration) *IntentCache {
+	return &IntentCache{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		maxSize: maxSize,
+		ttl:     ttl,
+	}
+}
+
+// NormalizeContent 归一化用户输入：全角转半角、转小写、去掉空白、标点和符号
+func NormalizeContent(content string) string {
+	var builder strings.Builder
+	builder.Grow(len(content))
+	for _, r := range content {
+		switch {
+		case r == '　':
+			r = ' '
+		case r >= '！' && r <= '～':
+			r -= 0xFEE0
+		}
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		builder.WriteRune(unicode.ToLower(r))
+	}
+	return builder.String()
+}
+
+func (c *IntentCache) SetMaxSize(maxSize int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = maxSize
+	c.evict()
+}
+
+func (c *IntentCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
+}
+
+func (c *IntentCache) expired(entry *IntentCacheEntry, now time.Time) bool {
+	return c.ttl > 0 && !entry.Pinned && now.Sub(entry.CreatedAt) > c.ttl
+}
+
+// Get 查找缓存，命中时增加命中次数
+func (c *IntentCache) Get(key string) (IntentCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return IntentCacheEntry{}, false
+	}
+	entry := elem.Value.(*IntentCacheEntry)
+	now := time.Now()
+	if c.expired(entry, now) {
+		c.removeElement(elem)
+		return IntentCacheEntry{}, false
+	}
+	entry.HitCount++
+	entry.LastHitAt = now
+	c.lru.MoveToFront(elem)
+	return *entry, true
+}
+
+// Set 添加或更新缓存，text 为送入意图匹配器的文本，意图不变时保留命中次数和固定状态
+func (c *IntentCache) Set(key, text, intent, source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*IntentCacheEntry)
+		if entry.Intent != intent {
+			entry.Intent = intent
+			entry.HitCount = 0
+		}
+		entry.Text = text
+		entry.Source = source
+		entry.CreatedAt = time.Now()
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&IntentCacheEntry{
+		Content:   key,
+		Text:      text,
+		Intent:    intent,
+		Source:    source,
+		CreatedAt: time.Now(),
+	})
+	c.evict()
+}
+
+// Delete 删除缓存条目
+func (c *IntentCache) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	c.removeElement(elem)
+	return true
+}
+
+// Pin 设置条目是否固定，条目不存在时返回 false
+func (c *IntentCache) Pin(key string, pinned bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	elem.Value.(*IntentCacheEntry).Pinned = pinned
+	if !pinned {
+		c.evict()
+	}
+	return true
+}
+
+// List 按最近使用顺序返回所有未过期的条目
+func (c *IntentCache) List() []IntentCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	entries := make([]IntentCacheEntry, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*IntentCacheEntry)
+		if c.expired(entry, now) {
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// RemoveIf 删除满足条件的条目（包括固定的条目），返回删除的数量
+func (c *IntentCache) RemoveIf(match func(entry IntentCacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if match(*elem.Value.(*IntentCacheEntry)) {
+			c.removeElement(elem)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+func (c *IntentCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// MarshalJSON 按最近使用顺序保存为数组
+func (c *IntentCache) MarshalJSON() ([]byte, error) {
+	c.mu.Lock()
+	entries := make([]*IntentCacheEntry, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*IntentCacheEntry))
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	c.mu.Unlock()
+	return data, err
+}
+
+// UnmarshalJSON 支持新的数组格式，也兼容旧版本 {"用户输入": "意图"} 的格式
+func (c *IntentCache) UnmarshalJSON(data []byte) error {
+	var entries []*IntentCacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var legacy map[string]string
+		if legacyErr := json.Unmarshal(data, &legacy); legacyErr != nil {
+			return err
+		}
+		now := time.Now()
+		entries = make([]*IntentCacheEntry, 0, len(legacy))
+		for content, intent := range legacy {
+			entries = append(entries, &IntentCacheEntry{
+				Content:   content,
+				Intent:    intent,
+				Source:    CacheSourceMatcher,
+				CreatedAt: now,
+			})
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element, len(entries))
+	c.lru.Init()
+	for _, entry := range entries {
+		// 旧版本的 key 没有归一化
+		entry.Content = NormalizeContent(entry.Content)
+		if entry.Content == "" {
+			continue
+		}
+		if _, exists := c.entries[entry.Content]; exists {
+			continue
+		}
+		c.entries[entry.Content] = c.lru.PushBack(entry)
+	}
+	c.evict()
+	return nil
+}
+
+// evict 淘汰最久未使用的非固定条目直到不超过容量上限，调用前需要持有锁
+func (c *IntentCache) evict() {
+	if c.maxSize <= 0 {
+		return
+	}
+	for elem := c.lru.Back(); elem != nil && c.lru.Len() > c.maxSize; {
+		prev := elem.Prev()
+		if !elem.Value.(*IntentCacheEntry).Pinned {
+			c.removeElement(elem)
+		}
+		elem = prev
+	}
+}
+
+func (c *IntentCache) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*IntentCacheEntry)
+	delete(c.entries, entry.Content)
+}
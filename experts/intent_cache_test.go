@@ -0,0 +1,206 @@
+package experts
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func cacheKeys(c *IntentCache) []string {
+	keys := make([]string, 0)
+	for _, entry := range c.List() {
+		keys = append(keys, entry.Content)
+	}
+	return keys
+}
+
+// ageEntry 把条目的创建时间提前 d，用于检查过期
+func ageEntry(c *IntentCache, key string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[key].Value.(*IntentCacheEntry)
+	entry.CreatedAt = entry.CreatedAt.Add(-d)
+}
+
+func TestNormalizeContent(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"查看构建状态", "查看构建状态"},
+		{"查看构建状态？", "查看构建状态"},
+		{"查看 构建\t状态\n", "查看构建状态"},
+		{"查看ＣＩ状态！", "查看ci状态"},  // 全角字母和标点
+		{"查看　CI　状态", "查看ci状态"}, // 全角空格
+		{"ｔａｇ＝ｖ１．０", "tagv10"}, // 全角符号和数字
+		{"「查看」《状态》…", "查看状态"},
+		{"Hello, World!", "helloworld"},
+		{"？！。，", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeContent(tt.input); got != tt.want {
+			t.Errorf("NormalizeContent(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestIntentCacheLRU(t *testing.T) {
+	cache := NewIntentCache(2, 0)
+	cache.Set("a", "a", "intent1", CacheSourceMatcher)
+	cache.Set("b", "b", "intent1", CacheSourceMatcher)
+	// 命中 a 后 b 成为最久未使用的条目
+	if _, found := cache.Get("a"); !found {
+		t.Fatal("Get(a) not found")
+	}
+	cache.Set("c", "c", "intent2", CacheSourceMatcher)
+	if want := []string{"c", "a"}; !reflect.DeepEqual(cacheKeys(cache), want) {
+		t.Errorf("keys = %v, want %v", cacheKeys(cache), want)
+	}
+
+	// 固定的条目不会被淘汰，淘汰下一个最久未使用的
+	cache.Pin("a", true)
+	cache.Set("d", "d", "intent2", CacheSourceMatcher)
+	cache.Set("e", "e", "intent2", CacheSourceMatcher)
+	if want := []string{"e", "a"}; !reflect.DeepEqual(cacheKeys(cache), want) {
+		t.Errorf("keys with pinned a = %v, want %v", cacheKeys(cache), want)
+	}
+
+	// 取消固定或缩小容量时立即淘汰
+	cache.SetMaxSize(1)
+	if want := []string{"a"}; !reflect.DeepEqual(cacheKeys(cache), want) {
+		t.Errorf("keys after SetMaxSize(1) = %v, want %v", cacheKeys(cache), want)
+	}
+	// 容量被固定条目占满时，新条目加入后立即被淘汰
+	cache.Set("f", "f", "intent2", CacheSourceMatcher)
+	if want := []string{"a"}; !reflect.DeepEqual(cacheKeys(cache), want) {
+		t.Errorf("keys with capacity full of pinned = %v, want %v", cacheKeys(cache), want)
+	}
+	cache.Pin("a", false)
+	cache.Set("g", "g", "intent2", CacheSourceMatcher)
+	if want := []string{"g"}; !reflect.DeepEqual(cacheKeys(cache), want) {
+		t.Errorf("keys after unpin = %v, want %v", cacheKeys(cache), want)
+	}
+}
+
+func TestIntentCacheTTL(t *testing.T) {
+	cache := NewIntentCache(0, time.Hour)
+	cache.Set("fresh", "fresh", "intent1", CacheSourceMatcher)
+	cache.Set("stale", "stale", "intent1", CacheSourceMatcher)
+	cache.Set("pinned", "pinned", "intent1", CacheSourceManual)
+	cache.Pin("pinned", true)
+	ageEntry(cache, "stale", 2*time.Hour)
+	ageEntry(cache, "pinned", 2*time.Hour)
+
+	if want := []string{"pinned", "fresh"}; !reflect.DeepEqual(cacheKeys(cache), want) {
+		t.Errorf("List() = %v, want %v", cacheKeys(cache), want)
+	}
+	if _, found := cache.Get("stale"); found {
+		t.Error("Get(stale) found, want expired")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want expired entry removed on Get", cache.Len())
+	}
+	if _, found := cache.Get("pinned"); !found {
+		t.Error("Get(pinned) not found, pinned entries never expire")
+	}
+
+	// 重新设置会刷新创建时间，意图不变时保留命中次数
+	ageEntry(cache, "fresh", 2*time.Hour)
+	cache.Set("fresh", "fresh", "intent1", CacheSourceLLM)
+	entry, found := cache.Get("fresh")
+	if !found {
+		t.Fatal("Get(fresh) after Set not found")
+	}
+	if entry.Source != CacheSourceLLM || entry.HitCount != 1 {
+		t.Errorf("entry = %+v, want source llm and hit count 1", entry)
+	}
+	cache.Set("fresh", "fresh", "intent2", CacheSourceLLM)
+	if entry, _ := cache.Get("fresh"); entry.Intent != "intent2" || entry.HitCount != 1 {
+		t.Errorf("entry after intent change = %+v, want intent2 and hit count reset", entry)
+	}
+}
+
+func TestIntentCacheJSON(t *testing.T) {
+	t.Run("legacy map", func(t *testing.T) {
+		cache := NewIntentCache(0, 0)
+		legacy := `{"查看构建状态？": "checkAutoStatus", "查看 构建状态": "other", "？": "empty"}`
+		if err := json.Unmarshal([]byte(legacy), cache); err != nil {
+			t.Fatal(err)
+		}
+		entries := cache.List()
+		// 旧版本的 key 归一化后合并，归一化后为空的丢弃
+		if len(entries) != 1 || entries[0].Content != "查看构建状态" {
+			t.Fatalf("entries = %+v, want one normalized entry", entries)
+		}
+		if entries[0].Source != CacheSourceMatcher || entries[0].CreatedAt.IsZero() {
+			t.Errorf("entry = %+v, want matcher source and creation time", entries[0])
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		cache := NewIntentCache(0, 0)
+		cache.Set("b", "B", "intent2", CacheSourceLLM)
+		cache.Set("a", "A", "intent1", CacheSourceManual)
+		cache.Pin("a", true)
+		data, err := json.Marshal(cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded := NewIntentCache(0, 0)
+		if err := json.Unmarshal(data, loaded); err != nil {
+			t.Fatal(err)
+		}
+		got, want := loaded.List(), cache.List()
+		if len(got) != len(want) {
+			t.Fatalf("loaded %d entries, want %d", len(got), len(want))
+		}
+		for idx := range want {
+			if !got[idx].CreatedAt.Equal(want[idx].CreatedAt) {
+				t.Errorf("entry %d created at %v, want %v", idx, got[idx].CreatedAt, want[idx].CreatedAt)
+			}
+			got[idx].CreatedAt, want[idx].CreatedAt = time.Time{}, time.Time{}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("loaded = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if err := json.Unmarshal([]byte(`"not a cache"`), NewIntentCache(0, 0)); err == nil {
+			t.Error("Unmarshal() of invalid data error = nil")
+		}
+	})
+}
+
+func TestIntentCacheInvalidation(t *testing.T) {
+	manager := NewIntentManager()
+	manager.Register(func() IntentMatchInter { return &fixedIntent{"a", 0.95} }, "a")
+	manager.Register(func() IntentMatchInter { return &fixedIntent{"b", 0.1} }, "b")
+	manager.CacheContentIntent("查看a", "a")
+	manager.AddCache("固定a", "a", true)
+	manager.CacheContentIntent("查看b", "b")
+	manager.CacheContentIntentFrom("学习b", "b", CacheSourceLLM)
+	manager.CacheContentIntent("查看c", "c") // 没有注册的意图
+
+	// 模型更新后只删除匹配器得到的未固定条目
+	if removed := manager.InvalidateCache("b"); removed != 1 {
+		t.Errorf("InvalidateCache(b) = %d, want 1", removed)
+	}
+	if removed := manager.PurgeUnregisteredCache(); removed != 1 {
+		t.Errorf("PurgeUnregisteredCache() = %d, want 1", removed)
+	}
+	// 注销时删除该意图的所有缓存，包括固定的
+	manager.UnRegister("a")
+
+	keys := make([]string, 0)
+	for _, entry := range manager.ListCache() {
+		keys = append(keys, entry.Content)
+	}
+	if want := []string{"学习b"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("cache = %v, want %v", keys, want)
+	}
+	if result := manager.MatchIntent("查看a", nil, false); result.FromCache {
+		t.Errorf("MatchIntent() after UnRegister = %+v, want not from cache", result)
+	}
+}
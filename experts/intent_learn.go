@@ -0,0 +1,235 @@
+package experts
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LearnPending  = "pending"  // 等待审核
+	LearnApproved = "approved" // 已通过，已写入意图缓存
+	LearnRejected = "rejected" // 已拒绝
+
+	defaultLearnQueueLimit = 1000
+)
+
+// LearnedIntent 多轮对话大模型识别出意图后，从用户第一句话学习到的意图缓存，需要审核后才写入缓存
+type LearnedIntent struct {
+	ID         string    `json:"id"`
+	Content    string    `json:"content"`          // 用户进入多轮对话的第一句话（格式化后）
+	Intent     string    `json:"intent"`           // 大模型识别出的意图
+	Demand     string    `json:"demand,omitempty"` // 大模型总结的用户需求
+	Confidence float64   `json:"confidence"`       // 意图匹配器对第一句话给该意图的分数，分数越高说明匹配器和大模型越一致
+	Source     string    `json:"source"`           // 来源，目前只有 CacheSourceLLM
+	Status     string    `json:"status"`           // 见 LearnPending 等
+	DialogID   string    `json:"dialog_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReviewedAt time.Time `json:"reviewed_at,omitzero"`
+}
+
+// IntentLearnQueue 学习到的意图缓存审核队列
+type IntentLearnQueue struct {
+	mu               sync.Mutex
+	items            []*LearnedIntent // 按创建时间排序
+	limit            int              // 超出时先删除最早的已审核条目
+	autoApproveScore float64          // Confidence 达到该分数时自动通过，0 代表都需要人工审核
+	filePath         string
+	lastSavedMd5     string
+}
+
+func NewIntentLearnQueue() *IntentLearnQueue {
+	return &IntentLearnQueue{
+		limit: defaultLearnQueueLimit,
+	}
+}
+
+func (q *IntentLearnQueue) SetFilePath(path string) {
+	q.filePath = path
+}
+
+func (q *IntentLearnQueue) SetAutoApproveScore(score float64) {
+	q.mu.Lock()
+	q.autoApproveScore = score
+	q.mu.Unlock()
+}
+
+// Add 添加一条学习到的意图，同样的内容和意图已经在队列中时返回已有条目
+func (q *IntentLearnQueue) Add(item LearnedIntent) LearnedIntent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := NormalizeContent(item.Content)
+	for _, existing := range q.items {
+		if existing.Intent == item.Intent && NormalizeContent(existing.Content) == key && existing.Status != LearnRejected {
+			return *existing
+		}
+	}
+
+	item.ID = uuid.New().String()
+	item.CreatedAt = time.Now()
+	item.Status = LearnPending
+	if q.autoApproveScore > 0 && item.Confidence >= q.autoApproveScore {
+		item.Status = LearnApproved
+		item.ReviewedAt = item.CreatedAt
+	}
+	q.items = append(q.items, &item)
+	q.trim()
+	return item
+}
+
+// List 返回队列中的条目，status 为空时返回全部
+func (q *IntentLearnQueue) List(status string) []LearnedIntent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]LearnedIntent, 0, len(q.items))
+	for _, item := range q.items {
+		if status == "" || item.Status == status {
+			items = append(items, *item)
+		}
+	}
+	return items
+}
+
+// Review 修改条目的审核状态，返回修改前的条目
+func (q *IntentLearnQueue) Review(id string, status string) (LearnedIntent, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, item := range q.items {
+		if item.ID == id {
+			previous := *item
+			item.Status = status
+			item.ReviewedAt = time.Now()
+			return previous, nil
+		}
+	}
+	return LearnedIntent{}, fmt.Errorf("learned intent %s not found", id)
+}
+
+// trim 超出上限时删除最早的已审核条目，仍然超出再删除最早的待审核条目，调用前需要持有锁
+func (q *IntentLearnQueue) trim() {
+	if q.limit <= 0 || len(q.items) <= q.limit {
+		return
+	}
+	over := len(q.items) - q.limit
+	kept := q.items[:0]
+	for _, item := range q.items {
+		if over > 0 && item.Status != LearnPending {
+			over--
+			continue
+		}
+		kept = append(kept, item)
+	}
+	q.items = kept[over:]
+}
+
+// Load 从文件加载审核队列
+func (q *IntentLearnQueue) Load() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	data, err := os.ReadFile(q.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Errorf("Failed to read learned intent file: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &q.items); err != nil {
+		logger.Errorf("Failed to unmarshal learned intent data: %v", err)
+		return
+	}
+	hash := md5.Sum(data)
+	q.lastSavedMd5 = hex.EncodeToString(hash[:])
+	logger.Infof("Loaded %d learned intent records.", len(q.items))
+}
+
+// Save 将审核队列保存到文件，没有变化时不保存
+func (q *IntentLearnQueue) Save() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	data, err := json.MarshalIndent(q.items, "", "  ")
+	if err != nil {
+		logger.Errorf("Failed to marshal learned intent data: %v", err)
+		return
+	}
+	hash := md5.Sum(data)
+	currentHash := hex.EncodeToString(hash[:])
+	if currentHash == q.lastSavedMd5 {
+		return
+	}
+	if err := os.WriteFile(q.filePath, data, 0644); err != nil {
+		logger.Errorf("Failed to write learned intent file: %v", err)
+		return
+	}
+	q.lastSavedMd5 = currentHash
+}
+
+// SetLearnAutoApproveScore 设置学习意图自动通过审核的分数，0 代表都需要人工审核
+func (i *IntentMatchManager) SetLearnAutoApproveScore(score float64) {
+	i.learnQueue.SetAutoApproveScore(score)
+}
+
+// LearnIntent 记录多轮对话大模型为用户第一句话识别出的意图。
+// 使用该意图的匹配器给第一句话打分作为置信度，自动通过时直接写入缓存，否则等待审核。意图未注册时不记录。
+func (i *IntentMatchManager) LearnIntent(content string, intent string, demand string, dialogID string) (LearnedIntent, bool) {
+	if !i.IsRegistered(intent) {
+		logger.Warnf("LLM resolved unregistered intent %s, skip learning.", intent)
+		return LearnedIntent{}, false
+	}
+	content = i.format(content)
+	if NormalizeContent(content) == "" {
+		return LearnedIntent{}, false
+	}
+	confidence, _ := i.scoreIntent(intent, content, nil)
+
+	item := i.learnQueue.Add(LearnedIntent{
+		Content:    content,
+		Intent:     intent,
+		Demand:     demand,
+		Confidence: confidence,
+		Source:     CacheSourceLLM,
+		DialogID:   dialogID,
+	})
+	if item.Status == LearnApproved {
+		i.CacheContentIntentFrom(item.Content, item.Intent, CacheSourceLLM)
+	}
+	logger.Infof("Learned intent %s for content %q, confidence %.4f, status %s.", item.Intent, item.Content, item.Confidence, item.Status)
+	return item, true
+}
+
+// ListLearned 返回学习到的意图，status 为空时返回全部
+func (i *IntentMatchManager) ListLearned(status string) []LearnedIntent {
+	return i.learnQueue.List(status)
+}
+
+// ApproveLearned 通过审核并写入意图缓存
+func (i *IntentMatchManager) ApproveLearned(id string) error {
+	previous, err := i.learnQueue.Review(id, LearnApproved)
+	if err != nil {
+		return err
+	}
+	i.CacheContentIntentFrom(previous.Content, previous.Intent, CacheSourceLLM)
+	return nil
+}
+
+// RejectLearned 拒绝学习到的意图，已经写入缓存的会从缓存中删除
+func (i *IntentMatchManager) RejectLearned(id string) error {
+	previous, err := i.learnQueue.Review(id, LearnRejected)
+	if err != nil {
+		return err
+	}
+	if previous.Status == LearnApproved {
+		key := NormalizeContent(previous.Content)
+		i.intentCache.RemoveIf(func(entry IntentCacheEntry) bool {
+			return entry.Content == key && entry.Intent == previous.Intent && entry.Source == CacheSourceLLM
+		})
+	}
+	return nil
+}
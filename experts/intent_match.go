@@ -5,9 +5,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"os"
+	"slices"
+	"sort"
 	"sync"
 	"time"
-	"sort"
 
 	"github.com/huihui4754/expertlib/types"
 )
@@ -22,23 +23,54 @@ type IntentMatchInter interface {
 	Matching(string, []Attachment) float64 //用户的话匹配意图系统，返回的第一个参数是匹配的概率 float64
 }
 
+// IntentClarifyInter 可选接口，匹配器实现后匹配时调用 MatchingWithQuestion 代替 Matching，
+// 分数低于意图阈值但不低于澄清最低分数时，专家会把返回的问题发给用户确认
+type IntentClarifyInter interface {
+	MatchingWithQuestion(string, []Attachment) (float64, string) // 返回匹配的概率和澄清问题，没有问题时返回空字符串
+}
+
+// IntentGroupMatchInter 一次匹配得到多个意图分数的匹配器，例如共享分词的 rnn 意图和多分类模型
+type IntentGroupMatchInter interface {
+	GetIntentNames() []string                              // 获取当前包含的所有意图名称，可以随模型重新加载变化
+	MatchingAll(string, []Attachment) []PossibleIntentions // 用户的话匹配所有意图，返回每个意图的概率和描述
+}
+
 // 意图匹配管理器，用于注册、缓存和查找意图匹配器以及意图匹配缓存到文件
 type IntentMatchManager struct {
 	cacheFilePath     string
 	allIntentMatcher  map[string]func() IntentMatchInter
-	IntentCache       map[string]string // IntentCache存储用户输入到意图名称的映射。
-	cacheMutex        *sync.RWMutex     // cacheMutex保护IntentCache免受并发访问。
-	lastSavedCacheMd5 string            // 保存md5 上次保存的md5 值
-	vaildMinScore     float64
-	messageformatting func(string) string //消息格式化函数，将消息送入意图识别时可以用此函数去处理文字字符串以便更好识别
+	groupMatchers     map[string]IntentGroupMatchInter // 一次匹配多个意图的匹配器，key 为匹配器名称
+	matcherMutex      *sync.RWMutex                    // 保护 allIntentMatcher 和 groupMatchers，匹配时可以同时注册、替换和注销
+	intentCache       *IntentCache                     // 归一化后的用户输入到意图名称的缓存
+	cacheMutex        *sync.Mutex                      // 保护缓存文件的读写和 lastSavedCacheMd5
+	lastSavedCacheMd5 string                           // 保存md5 上次保存的md5 值
+	learnQueue        *IntentLearnQueue                // 多轮对话学习到的意图缓存审核队列
+	vaildMinScore     float64                          // 没有单独设置阈值的意图使用的最低分数
+	intentThresholds  map[string]float64               // 每个意图单独的最低分数
+	intentWeights     map[string]float64               // 每个意图的权重，匹配分数乘以权重后再和阈值比较
+	minMargin         float64                          // 第一名和第二名之间的最小分差，小于该值视为意图不明确
+	clarifyMinScore   float64                          // 第一名低于阈值但不低于该分数且有澄清问题时向用户确认
+	scoreMutex        *sync.RWMutex                    // 保护阈值和权重
+	messageformatting func(string) string              //消息格式化函数，将消息送入意图识别时可以用此函数去处理文字字符串以便更好识别
+	stopChan          chan struct{}                    // 停止定期保存的信号
+	stopOnce          *sync.Once
 }
 
 func NewIntentManager() *IntentMatchManager {
 	return &IntentMatchManager{
 		allIntentMatcher: make(map[string]func() IntentMatchInter),
-		IntentCache:      make(map[string]string),
-		cacheMutex:       &sync.RWMutex{},
+		groupMatchers:    make(map[string]IntentGroupMatchInter),
+		matcherMutex:     &sync.RWMutex{},
+		intentCache:      NewIntentCache(defaultIntentCacheMaxSize, 0),
+		cacheMutex:       &sync.Mutex{},
+		learnQueue:       NewIntentLearnQueue(),
 		vaildMinScore:    0.9,
+		clarifyMinScore:  defaultClarifyMinScore,
+		intentThresholds: make(map[string]float64),
+		intentWeights:    make(map[string]float64),
+		scoreMutex:       &sync.RWMutex{},
+		stopChan:         make(chan struct{}),
+		stopOnce:         &sync.Once{},
 	}
 }
 
@@ -46,8 +78,76 @@ func (i *IntentMatchManager) SetCacheFilePath(path string) {
 	i.cacheFilePath = path
 }
 
+// SetLearnFilePath 设置学习意图审核队列的保存路径，和意图缓存一起加载和保存
+func (i *IntentMatchManager) SetLearnFilePath(path string) {
+	i.learnQueue.SetFilePath(path)
+}
+
 func (i *IntentMatchManager) SetVaildMinScore(score float64) {
+	i.scoreMutex.Lock()
 	i.vaildMinScore = score
+	i.scoreMutex.Unlock()
+}
+
+// SetIntentThreshold 设置某个意图的最低分数，小于等于 0 时恢复使用全局最低分数
+func (i *IntentMatchManager) SetIntentThreshold(intentName string, score float64) {
+	i.scoreMutex.Lock()
+	defer i.scoreMutex.Unlock()
+	if score <= 0 {
+		delete(i.intentThresholds, intentName)
+		return
+	}
+	i.intentThresholds[intentName] = score
+}
+
+// SetIntentWeight 设置某个意图的权重，匹配分数乘以权重（最高为 1）后参与比较，小于等于 0 时恢复为 1
+func (i *IntentMatchManager) SetIntentWeight(intentName string, weight float64) {
+	i.scoreMutex.Lock()
+	defer i.scoreMutex.Unlock()
+	if weight <= 0 {
+		delete(i.intentWeights, intentName)
+		return
+	}
+	i.intentWeights[intentName] = weight
+}
+
+// SetClarifyMinScore 设置需要向用户确认的最低分数，第一名在该分数和自己的阈值之间且匹配器给出澄清问题时返回 IntentNeedConfirm
+func (i *IntentMatchManager) SetClarifyMinScore(score float64) {
+	i.scoreMutex.Lock()
+	i.clarifyMinScore = score
+	i.scoreMutex.Unlock()
+}
+
+// SetMinMargin 设置第一名和第二名意图之间要求的最小分差，为 0 时不检查
+func (i *IntentMatchManager) SetMinMargin(margin float64) {
+	i.scoreMutex.Lock()
+	i.minMargin = margin
+	i.scoreMutex.Unlock()
+}
+
+// threshold 获取某个意图的最低分数
+func (i *IntentMatchManager) threshold(intentName string) float64 {
+	i.scoreMutex.RLock()
+	defer i.scoreMutex.RUnlock()
+	if score, ok := i.intentThresholds[intentName]; ok {
+		return score
+	}
+	return i.vaildMinScore
+}
+
+// weightedScore 将匹配器返回的分数乘以意图权重
+func (i *IntentMatchManager) weightedScore(intentName string, score float64) float64 {
+	i.scoreMutex.RLock()
+	weight, ok := i.intentWeights[intentName]
+	i.scoreMutex.RUnlock()
+	if !ok {
+		return score
+	}
+	score *= weight
+	if score > 1 {
+		score = 1
+	}
+	return score
 }
 
 // SetMessageFormatFun 设置匹配意图前的消息格式化函数，例如去掉url 等相关内容
@@ -55,30 +155,155 @@ func (i *IntentMatchManager) SetMessageFormatFunc(formatting func(string) string
 	i.messageformatting = formatting
 }
 
+// format 使用消息格式化函数处理用户输入
+func (i *IntentMatchManager) format(content string) string {
+	if i.messageformatting != nil {
+		return i.messageformatting(content)
+	}
+	return content
+}
+
 func (i *IntentMatchManager) Register(IntentMatcher func() IntentMatchInter, IntentName string) {
+	i.matcherMutex.Lock()
+	defer i.matcherMutex.Unlock()
 	if i.allIntentMatcher[IntentName] != nil {
 		return
 	}
 	i.allIntentMatcher[IntentName] = IntentMatcher
 }
 
+// Replace 注册或替换意图匹配器，替换后新的匹配请求使用新的匹配器
+func (i *IntentMatchManager) Replace(IntentMatcher func() IntentMatchInter, IntentName string) {
+	i.matcherMutex.Lock()
+	i.allIntentMatcher[IntentName] = IntentMatcher
+	i.matcherMutex.Unlock()
+}
+
+// UnRegister 注销意图匹配器，并删除指向该意图的缓存
 func (i *IntentMatchManager) UnRegister(IntentName string) {
+	i.matcherMutex.Lock()
 	delete(i.allIntentMatcher, IntentName)
+	i.matcherMutex.Unlock()
+	if removed := i.intentCache.RemoveIf(func(entry IntentCacheEntry) bool { return entry.Intent == IntentName }); removed > 0 {
+		logger.Infof("Removed %d Intent cache records of unregistered intent %s.", removed, IntentName)
+	}
+}
+
+// RegisterGroup 注册一次匹配多个意图的匹配器，同名时替换
+func (i *IntentMatchManager) RegisterGroup(groupName string, matcher IntentGroupMatchInter) {
+	i.matcherMutex.Lock()
+	i.groupMatchers[groupName] = matcher
+	i.matcherMutex.Unlock()
+}
+
+// UnRegisterGroup 注销多意图匹配器，并删除指向已不存在意图的缓存
+func (i *IntentMatchManager) UnRegisterGroup(groupName string) {
+	i.matcherMutex.Lock()
+	delete(i.groupMatchers, groupName)
+	i.matcherMutex.Unlock()
+	i.PurgeUnregisteredCache()
+}
+
+// IsRegistered 意图是否已注册，包括多意图匹配器中的意图
+func (i *IntentMatchManager) IsRegistered(IntentName string) bool {
+	if i.matcher(IntentName) != nil {
+		return true
+	}
+	return i.groupOf(IntentName) != nil
+}
+
+// IntentNames 返回所有已注册的意图名称
+func (i *IntentMatchManager) IntentNames() []string {
+	i.matcherMutex.RLock()
+	defer i.matcherMutex.RUnlock()
+	seen := make(map[string]bool, len(i.allIntentMatcher))
+	names := make([]string, 0, len(i.allIntentMatcher))
+	for name := range i.allIntentMatcher {
+		seen[name] = true
+		names = append(names, name)
+	}
+	for _, group := range i.groupMatchers {
+		for _, name := range group.GetIntentNames() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matcher 获取意图匹配器的构造函数，未注册时返回 nil
+func (i *IntentMatchManager) matcher(IntentName string) func() IntentMatchInter {
+	i.matcherMutex.RLock()
+	defer i.matcherMutex.RUnlock()
+	return i.allIntentMatcher[IntentName]
+}
+
+// groupOf 获取包含该意图的多意图匹配器，没有时返回 nil
+func (i *IntentMatchManager) groupOf(IntentName string) IntentGroupMatchInter {
+	i.matcherMutex.RLock()
+	defer i.matcherMutex.RUnlock()
+	for _, group := range i.groupMatchers {
+		if slices.Contains(group.GetIntentNames(), IntentName) {
+			return group
+		}
+	}
+	return nil
+}
+
+// scoreIntent 只计算某一个意图的分数（已乘以权重）
+func (i *IntentMatchManager) scoreIntent(IntentName string, content string, attachments []Attachment) (float64, bool) {
+	if ctor := i.matcher(IntentName); ctor != nil {
+		return i.weightedScore(IntentName, ctor().Matching(content, attachments)), true
+	}
+	if group := i.groupOf(IntentName); group != nil {
+		for _, result := range group.MatchingAll(content, attachments) {
+			if result.IntentName == IntentName {
+				return i.weightedScore(IntentName, result.Probability), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// getAllGroupMatchers 返回所有多意图匹配器
+func (i *IntentMatchManager) getAllGroupMatchers() []IntentGroupMatchInter {
+	i.matcherMutex.RLock()
+	defer i.matcherMutex.RUnlock()
+	groups := make([]IntentGroupMatchInter, 0, len(i.groupMatchers))
+	for _, group := range i.groupMatchers {
+		groups = append(groups, group)
+	}
+	return groups
 }
 
 func (i *IntentMatchManager) GetALLNewIntentMatcher() []IntentMatchInter {
-	plugins := make([]IntentMatchInter, 0, len(i.allIntentMatcher))
+	i.matcherMutex.RLock()
+	ctors := make([]func() IntentMatchInter, 0, len(i.allIntentMatcher))
 	for _, ctor := range i.allIntentMatcher {
+		ctors = append(ctors, ctor)
+	}
+	i.matcherMutex.RUnlock()
+
+	plugins := make([]IntentMatchInter, 0, len(ctors))
+	for _, ctor := range ctors {
 		plugins = append(plugins, ctor())
 	}
 	return plugins
 }
 
-// LoadIntentCache 从文件系统加载意图缓存到内存。
+// LoadIntentCache 从文件系统加载意图缓存到内存，兼容旧版本的 map 格式。
+// 加载后会删除指向未注册意图的缓存，需要在注册完意图匹配器之后调用。
 func (i *IntentMatchManager) LoadIntentCache() {
 	i.cacheMutex.Lock()
 	defer i.cacheMutex.Unlock()
 
+	if i.learnQueue.filePath != "" {
+		i.learnQueue.Load()
+	}
+
 	data, err := os.ReadFile(i.cacheFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -89,27 +314,32 @@ func (i *IntentMatchManager) LoadIntentCache() {
 		return
 	}
 
-	if err := json.Unmarshal(data, &i.IntentCache); err != nil {
+	if err := json.Unmarshal(data, i.intentCache); err != nil {
 		logger.Errorf("Failed to unmarshal Intent cache data: %v", err)
 		return
 	}
+	i.PurgeUnregisteredCache()
 
 	// 存储数据的初始状态以避免不必要的保存
-	initialData, err := json.MarshalIndent(i.IntentCache, "", "  ")
+	initialData, err := i.intentCache.MarshalJSON()
 	if err == nil {
 		hash := md5.Sum(initialData)
 		i.lastSavedCacheMd5 = hex.EncodeToString(hash[:])
 	}
 
-	logger.Infof("Loaded %d Intent cache records.", len(i.IntentCache))
+	logger.Infof("Loaded %d Intent cache records.", i.intentCache.Len())
 }
 
 // SaveIntentCache 将当前意图缓存保存到文件系统。
 func (i *IntentMatchManager) SaveIntentCache() {
-	i.cacheMutex.RLock()
-	defer i.cacheMutex.RUnlock()
+	i.cacheMutex.Lock()
+	defer i.cacheMutex.Unlock()
+
+	if i.learnQueue.filePath != "" {
+		i.learnQueue.Save()
+	}
 
-	currentData, err := json.MarshalIndent(i.IntentCache, "", "  ")
+	currentData, err := i.intentCache.MarshalJSON()
 	if err != nil {
 		logger.Errorf("Failed to marshal Intent cache data for saving: %v", err)
 		return
@@ -130,71 +360,189 @@ func (i *IntentMatchManager) SaveIntentCache() {
 	}
 }
 
-// PeriodicCacheSave 定期保存意图缓存。
+// SetCacheMaxSize 设置意图缓存的最大条数，超出时淘汰最久未使用的条目，0 代表不限制
+func (i *IntentMatchManager) SetCacheMaxSize(size int) {
+	i.intentCache.SetMaxSize(size)
+}
+
+// SetCacheTTL 设置意图缓存的有效期，0 代表永不过期
+func (i *IntentMatchManager) SetCacheTTL(ttl time.Duration) {
+	i.intentCache.SetTTL(ttl)
+}
+
+// ListCache 按最近使用顺序返回所有意图缓存
+func (i *IntentMatchManager) ListCache() []IntentCacheEntry {
+	return i.intentCache.List()
+}
+
+// DeleteCache 删除某条用户输入的缓存，content 会先归一化
+func (i *IntentMatchManager) DeleteCache(content string) bool {
+	return i.intentCache.Delete(NormalizeContent(content))
+}
+
+// PinCache 固定或取消固定某条用户输入的缓存，固定的条目不会过期也不会被淘汰
+func (i *IntentMatchManager) PinCache(content string, pinned bool) bool {
+	return i.intentCache.Pin(NormalizeContent(content), pinned)
+}
+
+// AddCache 手动添加一条缓存，pinned 为 true 时固定该条目
+func (i *IntentMatchManager) AddCache(content string, intent string, pinned bool) {
+	i.CacheContentIntentFrom(content, intent, CacheSourceManual)
+	if pinned {
+		i.PinCache(content, true)
+	}
+}
+
+// InvalidateCache 删除意图匹配器得到的某个意图的缓存，固定的和其他来源的条目保留，用于模型更新后重新匹配
+func (i *IntentMatchManager) InvalidateCache(IntentName string) int {
+	return i.intentCache.RemoveIf(func(entry IntentCacheEntry) bool {
+		return entry.Intent == IntentName && entry.Source == CacheSourceMatcher && !entry.Pinned
+	})
+}
+
+// PurgeUnregisteredCache 删除指向未注册意图的缓存，返回删除的数量
+func (i *IntentMatchManager) PurgeUnregisteredCache() int {
+	removed := i.intentCache.RemoveIf(func(entry IntentCacheEntry) bool {
+		return !i.IsRegistered(entry.Intent)
+	})
+	if removed > 0 {
+		logger.Infof("Purged %d Intent cache records of unregistered intents.", removed)
+	}
+	return removed
+}
+
+// PeriodicCacheSave 定期保存意图缓存，直到调用 StopPeriodicCacheSave。
 func (i *IntentMatchManager) PeriodicCacheSave(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		logger.Debug("Periodic Intent cache save check")
-		i.SaveIntentCache()
+	for {
+		select {
+		case <-ticker.C:
+			logger.Debug("Periodic Intent cache save check")
+			i.SaveIntentCache()
+		case <-i.stopChan:
+			return
+		}
 	}
 }
 
+// StopPeriodicCacheSave 停止定期保存意图缓存，可以重复调用。
+func (i *IntentMatchManager) StopPeriodicCacheSave() {
+	i.stopOnce.Do(func() {
+		close(i.stopChan)
+	})
+}
+
+// IntentMatchOutcome 意图匹配的结果类型
+type IntentMatchOutcome int
+
+const (
+	IntentNoMatch     IntentMatchOutcome = iota // 没有意图达到阈值
+	IntentMatched                               // 匹配到唯一的意图
+	IntentAmbiguous                             // 多个意图达到阈值且分差小于 minMargin，需要用户选择
+	IntentNeedConfirm                           // 第一名低于阈值但不低于澄清最低分数，匹配器给出了澄清问题，需要用户确认
+)
+
+const defaultClarifyMinScore = 0.5
+
+// IntentMatchResult MatchIntent 的返回结果
+type IntentMatchResult struct {
+	Outcome            IntentMatchOutcome
+	Intent             string               // Outcome 为 IntentMatched 时的意图名称
+	Candidates         []PossibleIntentions // Outcome 为 IntentAmbiguous 时需要用户选择的意图
+	Question           string               // Outcome 为 IntentNeedConfirm 时向用户确认的问题，Intent 为待确认的意图
+	PossibleIntentions []PossibleIntentions // 分数最高的 3 个意图
+	FromCache          bool                 // 是否命中缓存
+}
+
 // FindBestIntent 首先检查该高速缓存，如果未找到，则执行匹配并缓存结果。 ifsave 参数控制是否缓存新匹配的意图。
+// 意图不明确或需要确认时返回空字符串，需要区分这些情况时使用 MatchIntent。
 func (i *IntentMatchManager) FindBestIntent(relacontent string, attachments []Attachment, ifsave bool) (string, []PossibleIntentions) {
-	content := relacontent
-	if i.messageformatting != nil {
-		content = i.messageformatting(content)
+	result := i.MatchIntent(relacontent, attachments, ifsave)
+	if result.Outcome != IntentMatched {
+		return "", result.PossibleIntentions
 	}
+	return result.Intent, result.PossibleIntentions
+}
 
-	logger.Debugf("content: %s", content)
+// MatchIntent 分阶段匹配意图：
+//  1. 查找缓存，命中直接返回；
+//  2. 并发执行所有意图匹配器，分数乘以意图权重；
+//  3. 第一名没有达到自己的阈值时，分数不低于 clarifyMinScore 且有澄清问题时返回 IntentNeedConfirm，否则返回 IntentNoMatch；
+//  4. 达到阈值且其他同样达到阈值的意图与第一名分差小于 minMargin 时返回 IntentAmbiguous；
+//  5. 否则返回 IntentMatched，ifsave 为 true 时缓存结果。
+func (i *IntentMatchManager) MatchIntent(relacontent string, attachments []Attachment, ifsave bool) IntentMatchResult {
+	content := i.format(relacontent)
 
-	i.cacheMutex.RLock()
-	cachedIntent, found := i.IntentCache[content]
-	i.cacheMutex.RUnlock()
+	logger.Debugf("content: %s", content)
 
-	if found {
-		logger.Debugf("Cache hit for content. Intent: %s", cachedIntent)
-		return cachedIntent, nil
+	if cached, found := i.intentCache.Get(NormalizeContent(content)); found {
+		logger.Debugf("Cache hit for content. Intent: %s, source: %s, hits: %d", cached.Intent, cached.Source, cached.HitCount)
+		return IntentMatchResult{Outcome: IntentMatched, Intent: cached.Intent, FromCache: true}
 	}
 
 	// 2.如果不在缓存中，则执行匹配
 	logger.Debug("Cache miss. Finding best Intent for content.")
 	allIntents := i.GetALLNewIntentMatcher()
-	if len(allIntents) == 0 {
+	allGroups := i.getAllGroupMatchers()
+	if len(allIntents) == 0 && len(allGroups) == 0 {
 		logger.Error("No Intents available for matching.")
-		return "", nil
+		return IntentMatchResult{Outcome: IntentNoMatch}
 	}
 
-	results := make(chan PossibleIntentions, len(allIntents))
+	results := make(chan []PossibleIntentions, len(allIntents)+len(allGroups))
 	var wg sync.WaitGroup
 
 	for _, exp := range allIntents {
 		wg.Add(1)
 		go func(e IntentMatchInter) {
 			defer wg.Done()
-			score := e.Matching(content, attachments)
-			results <- PossibleIntentions{IntentName: e.GetIntentName(), Probability: score, IntentDescription: e.GetIntentDesc()}
+			name := e.GetIntentName()
+			var score float64
+			var question string
+			if clarifier, ok := e.(IntentClarifyInter); ok {
+				score, question = clarifier.MatchingWithQuestion(content, attachments)
+			} else {
+				score = e.Matching(content, attachments)
+			}
+			score = i.weightedScore(name, score)
+			results <- []PossibleIntentions{{IntentName: name, Probability: score, IntentDescription: e.GetIntentDesc(), Question: question}}
 		}(exp)
 	}
+	for _, group := range allGroups {
+		wg.Add(1)
+		go func(g IntentGroupMatchInter) {
+			defer wg.Done()
+			groupResults := g.MatchingAll(content, attachments)
+			for k := range groupResults {
+				groupResults[k].Probability = i.weightedScore(groupResults[k].IntentName, groupResults[k].Probability)
+			}
+			results <- groupResults
+		}(group)
+	}
 
 	go func() {
 		wg.Wait()
 		close(results)
 	}()
 
-	bestIntent := ""
-	var maxScore = 0.0
-
-	var possibleIntentions []PossibleIntentions
-
+	// 同名意图只保留分数最高的
+	bestByName := make(map[string]PossibleIntentions)
 	for res := range results {
-		if res.Probability > maxScore {
-			maxScore = res.Probability
-			bestIntent = res.IntentName
+		for _, possible := range res {
+			if existing, ok := bestByName[possible.IntentName]; !ok || possible.Probability > existing.Probability {
+				bestByName[possible.IntentName] = possible
+			}
 		}
-		possibleIntentions = append(possibleIntentions, res)
+	}
+	if len(bestByName) == 0 {
+		logger.Error("No Intents available for matching.")
+		return IntentMatchResult{Outcome: IntentNoMatch}
+	}
+	possibleIntentions := make([]PossibleIntentions, 0, len(bestByName))
+	for _, possible := range bestByName {
+		possibleIntentions = append(possibleIntentions, possible)
 	}
 
 	// Sort possibleIntentions by Probability in descending order
@@ -202,29 +550,79 @@ func (i *IntentMatchManager) FindBestIntent(relacontent string, attachments []At
 		return possibleIntentions[k].Probability > possibleIntentions[j].Probability
 	})
 
-	// Keep only the top 3 possible intentions
-	if len(possibleIntentions) > 3 {
-		possibleIntentions = possibleIntentions[:3]
+	result := IntentMatchResult{Outcome: IntentNoMatch}
+	best := possibleIntentions[0]
+
+	// 3.第一名需要达到自己的阈值
+	if best.Probability < i.threshold(best.IntentName) {
+		logger.Debugf("No suitable Intent found, best %s with score %.4f below threshold %.4f.", best.IntentName, best.Probability, i.threshold(best.IntentName))
+		result.PossibleIntentions = topIntentions(possibleIntentions, 3)
+		logger.Debugf("较高意图概率： %v", result.PossibleIntentions)
+		i.scoreMutex.RLock()
+		clarifyMinScore := i.clarifyMinScore
+		i.scoreMutex.RUnlock()
+		if best.Question != "" && best.Probability > 0 && best.Probability >= clarifyMinScore {
+			logger.Debugf("Intent %s needs confirmation with score %.4f: %s", best.IntentName, best.Probability, best.Question)
+			result.Outcome = IntentNeedConfirm
+			result.Intent = best.IntentName
+			result.Question = best.Question
+		}
+		return result
 	}
 
-	logger.Debugf("较高意图概率： %v", possibleIntentions)
-
-	// 3.仅在找到合适的意图时进行缓存
-	if maxScore >= i.vaildMinScore {
-		logger.Debugf("Found best Intent: %s with score %d. Caching result.", bestIntent, maxScore)
-		if ifsave {
-			i.CacheContentIntent(content, bestIntent)
+	// 4.检查与其他达到阈值的意图之间的分差
+	i.scoreMutex.RLock()
+	minMargin := i.minMargin
+	i.scoreMutex.RUnlock()
+	candidates := []PossibleIntentions{best}
+	if minMargin > 0 {
+		for _, other := range possibleIntentions[1:] {
+			if best.Probability-other.Probability >= minMargin {
+				break
+			}
+			if other.Probability >= i.threshold(other.IntentName) {
+				candidates = append(candidates, other)
+			}
 		}
-		return bestIntent, possibleIntentions
+	}
+	result.PossibleIntentions = topIntentions(possibleIntentions, 3)
+	logger.Debugf("较高意图概率： %v", result.PossibleIntentions)
+
+	if len(candidates) > 1 {
+		logger.Debugf("Ambiguous Intents within margin %.4f: %v", minMargin, candidates)
+		result.Outcome = IntentAmbiguous
+		result.Candidates = candidates
+		return result
 	}
 
-	logger.Debugf("No suitable Intent found with a score >= %d .", i.vaildMinScore)
-	return "", possibleIntentions
+	// 5.仅在找到唯一合适的意图时进行缓存
+	logger.Debugf("Found best Intent: %s with score %.4f. Caching result.", best.IntentName, best.Probability)
+	if ifsave {
+		i.CacheContentIntent(content, best.IntentName)
+	}
+	result.Outcome = IntentMatched
+	result.Intent = best.IntentName
+	return result
 }
 
-// CacheContentIntent 将内容与意图关联并存储在内存中。
+// topIntentions 返回已排序意图中的前 n 个
+func topIntentions(sorted []PossibleIntentions, n int) []PossibleIntentions {
+	if len(sorted) > n {
+		return sorted[:n]
+	}
+	return sorted
+}
+
+// CacheContentIntent 将内容与意图关联并存储在内存中，来源记为意图匹配器。
 func (i *IntentMatchManager) CacheContentIntent(content string, intent string) {
-	i.cacheMutex.Lock()
-	i.IntentCache[content] = intent
-	i.cacheMutex.Unlock()
+	i.CacheContentIntentFrom(content, intent, CacheSourceMatcher)
+}
+
+// CacheContentIntentFrom 将内容与意图关联并记录来源，content 为格式化后送入意图匹配器的文本，归一化后为空时不缓存。
+func (i *IntentMatchManager) CacheContentIntentFrom(content string, intent string, source string) {
+	key := NormalizeContent(content)
+	if key == "" {
+		return
+	}
+	i.intentCache.Set(key, content, intent, source)
 }
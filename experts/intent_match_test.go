@@ -0,0 +1,279 @@
+package experts
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fixedIntent 总是返回固定分数的意图
+type fixedIntent struct {
+	name  string
+	score float64
+}
+
+func (f *fixedIntent) GetIntentName() string                 { return f.name }
+func (f *fixedIntent) GetIntentDesc() string                 { return f.name }
+func (f *fixedIntent) Matching(string, []Attachment) float64 { return f.score }
+
+// clarifyIntent 返回固定分数和澄清问题的意图
+type clarifyIntent struct {
+	fixedIntent
+	question string
+}
+
+func (c *clarifyIntent) MatchingWithQuestion(string, []Attachment) (float64, string) {
+	return c.score, c.question
+}
+
+// fixedGroup 一次返回多个意图固定分数的匹配器
+type fixedGroup struct {
+	scores []PossibleIntentions
+}
+
+func (g *fixedGroup) GetIntentNames() []string {
+	names := make([]string, 0, len(g.scores))
+	for _, score := range g.scores {
+		names = append(names, score.IntentName)
+	}
+	return names
+}
+
+func (g *fixedGroup) MatchingAll(string, []Attachment) []PossibleIntentions {
+	scores := make([]PossibleIntentions, len(g.scores))
+	copy(scores, g.scores)
+	return scores
+}
+
+func TestWeightedScore(t *testing.T) {
+	manager := NewIntentManager()
+	manager.SetIntentWeight("half", 0.5)
+	manager.SetIntentWeight("double", 2)
+	manager.SetIntentWeight("reset", 2)
+	manager.SetIntentWeight("reset", 0)
+	tests := []struct {
+		intent string
+		score  float64
+		want   float64
+	}{
+		{"none", 0.8, 0.8},
+		{"half", 0.8, 0.4},
+		{"double", 0.3, 0.6},
+		{"double", 0.8, 1}, // 最高为 1
+		{"reset", 0.8, 0.8},
+	}
+	for _, tt := range tests {
+		if got := manager.weightedScore(tt.intent, tt.score); got != tt.want {
+			t.Errorf("weightedScore(%s, %v) = %v, want %v", tt.intent, tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	manager := NewIntentManager()
+	manager.SetVaildMinScore(0.8)
+	manager.SetIntentThreshold("low", 0.5)
+	manager.SetIntentThreshold("reset", 0.5)
+	manager.SetIntentThreshold("reset", 0)
+	tests := []struct {
+		intent string
+		want   float64
+	}{
+		{"none", 0.8},
+		{"low", 0.5},
+		{"reset", 0.8},
+	}
+	for _, tt := range tests {
+		if got := manager.threshold(tt.intent); got != tt.want {
+			t.Errorf("threshold(%s) = %v, want %v", tt.intent, got, tt.want)
+		}
+	}
+}
+
+func TestMatchIntent(t *testing.T) {
+	tests := []struct {
+		name           string
+		matchers       []IntentMatchInter
+		group          []PossibleIntentions
+		thresholds     map[string]float64
+		weights        map[string]float64
+		minMargin      float64
+		wantOutcome    IntentMatchOutcome
+		wantIntent     string
+		wantCandidates []string
+		wantQuestion   string
+	}{
+		{
+			name:        "no matchers",
+			wantOutcome: IntentNoMatch,
+		},
+		{
+			name:        "matched",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.95}, &fixedIntent{"b", 0.3}},
+			wantOutcome: IntentMatched,
+			wantIntent:  "a",
+		},
+		{
+			name:        "below global threshold",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.85}},
+			wantOutcome: IntentNoMatch,
+		},
+		{
+			name:        "own lower threshold",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.6}},
+			thresholds:  map[string]float64{"a": 0.5},
+			wantOutcome: IntentMatched,
+			wantIntent:  "a",
+		},
+		{
+			// 第一名没有达到自己的阈值时不会退而选择第二名
+			name:        "best below own higher threshold",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.95}, &fixedIntent{"b", 0.92}},
+			thresholds:  map[string]float64{"a": 0.99},
+			wantOutcome: IntentNoMatch,
+		},
+		{
+			name:        "weight raises score",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.6}},
+			weights:     map[string]float64{"a": 1.6},
+			wantOutcome: IntentMatched,
+			wantIntent:  "a",
+		},
+		{
+			name:        "weight lowers score",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.98}, &fixedIntent{"b", 0.92}},
+			weights:     map[string]float64{"a": 0.5},
+			wantOutcome: IntentMatched,
+			wantIntent:  "b",
+		},
+		{
+			// 权重放大后最高为 1，和 0.95 的分差小于 minMargin
+			name:           "weight capped at 1",
+			matchers:       []IntentMatchInter{&fixedIntent{"a", 0.8}, &fixedIntent{"b", 0.95}},
+			weights:        map[string]float64{"a": 2},
+			minMargin:      0.1,
+			wantOutcome:    IntentAmbiguous,
+			wantCandidates: []string{"a", "b"},
+		},
+		{
+			name:           "ambiguous within margin",
+			matchers:       []IntentMatchInter{&fixedIntent{"a", 0.97}, &fixedIntent{"b", 0.93}, &fixedIntent{"c", 0.2}},
+			minMargin:      0.1,
+			wantOutcome:    IntentAmbiguous,
+			wantCandidates: []string{"a", "b"},
+		},
+		{
+			name:        "second below its threshold not ambiguous",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.95}, &fixedIntent{"b", 0.88}},
+			minMargin:   0.1,
+			wantOutcome: IntentMatched,
+			wantIntent:  "a",
+		},
+		{
+			name:           "second reaches its own lower threshold",
+			matchers:       []IntentMatchInter{&fixedIntent{"a", 0.95}, &fixedIntent{"b", 0.88}},
+			thresholds:     map[string]float64{"b": 0.8},
+			minMargin:      0.1,
+			wantOutcome:    IntentAmbiguous,
+			wantCandidates: []string{"a", "b"},
+		},
+		{
+			name:        "margin reached",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 1}, &fixedIntent{"b", 0.85}},
+			thresholds:  map[string]float64{"b": 0.8},
+			minMargin:   0.1,
+			wantOutcome: IntentMatched,
+			wantIntent:  "a",
+		},
+		{
+			name:        "margin disabled",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.95}, &fixedIntent{"b", 0.94}},
+			wantOutcome: IntentMatched,
+			wantIntent:  "a",
+		},
+		{
+			name:         "need confirm",
+			matchers:     []IntentMatchInter{&clarifyIntent{fixedIntent{"a", 0.7}, "是要查看构建状态吗？"}},
+			wantOutcome:  IntentNeedConfirm,
+			wantIntent:   "a",
+			wantQuestion: "是要查看构建状态吗？",
+		},
+		{
+			name:        "below clarify min score",
+			matchers:    []IntentMatchInter{&clarifyIntent{fixedIntent{"a", 0.4}, "是要查看构建状态吗？"}},
+			wantOutcome: IntentNoMatch,
+		},
+		{
+			name:        "no question",
+			matchers:    []IntentMatchInter{&clarifyIntent{fixedIntent{"a", 0.7}, ""}},
+			wantOutcome: IntentNoMatch,
+		},
+		{
+			name:        "above threshold ignores question",
+			matchers:    []IntentMatchInter{&clarifyIntent{fixedIntent{"a", 0.95}, "是要查看构建状态吗？"}},
+			wantOutcome: IntentMatched,
+			wantIntent:  "a",
+		},
+		{
+			// 同名意图取匹配器和模型中的最高分
+			name:        "group and matcher same name",
+			matchers:    []IntentMatchInter{&fixedIntent{"a", 0.5}},
+			group:       []PossibleIntentions{{IntentName: "a", Probability: 0.95}, {IntentName: "b", Probability: 0.6}},
+			wantOutcome: IntentMatched,
+			wantIntent:  "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewIntentManager()
+			for _, matcher := range tt.matchers {
+				manager.Register(func() IntentMatchInter { return matcher }, matcher.GetIntentName())
+			}
+			if tt.group != nil {
+				manager.RegisterGroup("group", &fixedGroup{scores: tt.group})
+			}
+			for intent, score := range tt.thresholds {
+				manager.SetIntentThreshold(intent, score)
+			}
+			for intent, weight := range tt.weights {
+				manager.SetIntentWeight(intent, weight)
+			}
+			manager.SetMinMargin(tt.minMargin)
+
+			result := manager.MatchIntent("查看构建状态", nil, true)
+			if result.Outcome != tt.wantOutcome || result.Intent != tt.wantIntent || result.Question != tt.wantQuestion {
+				t.Errorf("MatchIntent() = outcome %d intent %q question %q, want %d %q %q",
+					result.Outcome, result.Intent, result.Question, tt.wantOutcome, tt.wantIntent, tt.wantQuestion)
+			}
+			var candidates []string
+			for _, candidate := range result.Candidates {
+				candidates = append(candidates, candidate.IntentName)
+			}
+			if !reflect.DeepEqual(candidates, tt.wantCandidates) {
+				t.Errorf("candidates = %v, want %v", candidates, tt.wantCandidates)
+			}
+			if len(result.PossibleIntentions) > 3 {
+				t.Errorf("PossibleIntentions has %d intents, want at most 3", len(result.PossibleIntentions))
+			}
+
+			// 只缓存唯一匹配的结果，之后命中缓存
+			cached := manager.MatchIntent("查看构建状态", nil, true)
+			if got, want := cached.FromCache, tt.wantOutcome == IntentMatched; got != want {
+				t.Errorf("second MatchIntent() from cache = %v, want %v", got, want)
+			}
+			if cached.FromCache && cached.Intent != tt.wantIntent {
+				t.Errorf("cached intent = %q, want %q", cached.Intent, tt.wantIntent)
+			}
+		})
+	}
+}
+
+func TestMatchIntentWithoutSave(t *testing.T) {
+	manager := NewIntentManager()
+	manager.Register(func() IntentMatchInter { return &fixedIntent{"a", 0.95} }, "a")
+	if result := manager.MatchIntent("查看构建状态", nil, false); result.Outcome != IntentMatched {
+		t.Fatalf("MatchIntent() outcome = %d, want matched", result.Outcome)
+	}
+	if entries := manager.ListCache(); len(entries) != 0 {
+		t.Errorf("cache = %+v, want empty when ifsave is false", entries)
+	}
+}
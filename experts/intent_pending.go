@@ -0,0 +1,301 @@
+package experts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/huihui4754/expertlib/types"
+)
+
+type PendingIntent = types.PendingIntent
+
+// askIntentChoice 意图不明确时把候选意图发给用户选择，用户的下一句话由 handlePendingIntent 处理
+func (t *Expert) askIntentChoice(dialogx *DialogInfo, message *TotalMessage, candidates []PossibleIntentions) {
+	var question strings.Builder
+	question.WriteString("请问您想要进行以下哪项操作？请回复序号：")
+	for idx, candidate := range candidates {
+		desc := candidate.IntentDescription
+		if desc == "" {
+			desc = candidate.IntentName
+		}
+		fmt.Fprintf(&question, "\n%d. %s", idx+1, desc)
+	}
+
+	original := *message
+	dialogx.Pending = &PendingIntent{
+		Kind:       types.PendingChoose,
+		Candidates: candidates,
+		Message:    &original,
+	}
+	logger.Debugf("意图不明确，等待用户选择: %v", candidates)
+	t.replyToUser(dialogx, message, question.String(), candidates)
+}
+
+// askIntentConfirm 向用户发送匹配器给出的澄清问题，用户的下一句话由 handlePendingIntent 处理
+func (t *Expert) askIntentConfirm(dialogx *DialogInfo, message *TotalMessage, result IntentMatchResult) {
+	candidate := PossibleIntentions{IntentName: result.Intent, Question: result.Question}
+	for _, possible := range result.PossibleIntentions {
+		if possible.IntentName == result.Intent {
+			candidate = possible
+			break
+		}
+	}
+
+	original := *message
+	dialogx.Pending = &PendingIntent{
+		Kind:       types.PendingConfirm,
+		Candidates: []PossibleIntentions{candidate},
+		Message:    &original,
+	}
+	logger.Debugf("意图需要确认，等待用户回答: %s %s", candidate.IntentName, result.Question)
+	t.replyToUser(dialogx, message, result.Question, []PossibleIntentions{candidate})
+}
+
+// handlePendingIntent 用户回复了等待确认的意图时返回 true，返回 false 时按新消息处理
+func (t *Expert) handlePendingIntent(dialogx *DialogInfo, message *TotalMessage) bool {
+	pending := dialogx.Pending
+	if pending == nil {
+		return false
+	}
+	dialogx.Pending = nil
+
+	switch pending.Kind {
+	case types.PendingChoose:
+		intent := resolveIntentChoice(message.Messages.Content, pending.Candidates)
+		if intent == "" {
+			logger.Debug("用户没有选择候选意图，按新消息处理")
+			return false
+		}
+		logger.Debug("用户选择了意图:", intent)
+		dialogx.Program = intent
+		dialogx.Mutil = false
+		dialogx.FirstMutil = false
+		dialogx.FirstMutilContent = ""
+		original := message
+		if pending.Message != nil {
+			original = pending.Message
+		}
+		t.routeToProgram(dialogx, original)
+		return true
+	case types.PendingConfirm:
+		if len(pending.Candidates) == 0 || pending.Message == nil {
+			return false
+		}
+		switch resolveConfirmAnswer(message.Messages.Content) {
+		case confirmYes:
+			intent := pending.Candidates[0].IntentName
+			logger.Debug("用户确认了意图:", intent)
+			dialogx.Program = intent
+			dialogx.Mutil = false
+			dialogx.FirstMutil = false
+			dialogx.FirstMutilContent = ""
+			t.routeToProgram(dialogx, pending.Message)
+			return true
+		case confirmNo:
+			// 用户否认后原始消息按没有匹配到意图处理，见 fallback
+			logger.Debug("用户否认了意图:", pending.Candidates[0].IntentName)
+			t.fallback(dialogx, pending.Message, pending.Candidates)
+			return true
+		default:
+			logger.Debug("用户没有回答确认问题，按新消息处理")
+			return false
+		}
+	case types.PendingSlot:
+		if len(pending.Candidates) == 0 || pending.Message == nil {
+			return false
+		}
+		intent := pending.Candidates[0].IntentName
+		slots, missing := t.extractSlots(intent, message.Messages.Content, pending.Slots, pending.Slot)
+		if len(slots) == len(pending.Slots) {
+			logger.Debug("用户没有补充参数，按新消息处理")
+			return false
+		}
+		dialogx.Program = intent
+		if missing != nil {
+			t.askSlot(dialogx, pending.Message, slots, missing)
+			return true
+		}
+		dialogx.RoutedContent = t.intentMatch.format(pending.Message.Messages.Content)
+		routed := *pending.Message
+		routed.Slots = slots
+		t.forwardToProgram(dialogx, &routed)
+		return true
+	default:
+		logger.Warnf("未知的待确认类型: %s", pending.Kind)
+		return false
+	}
+}
+
+// resolveIntentChoice 根据用户回复的序号、意图名称或描述找到选择的意图，找不到返回空字符串
+func resolveIntentChoice(content string, candidates []PossibleIntentions) string {
+	answer := strings.TrimSpace(content)
+	if answer == "" {
+		return ""
+	}
+	if idx, err := strconv.Atoi(answer); err == nil {
+		if idx >= 1 && idx <= len(candidates) {
+			return candidates[idx-1].IntentName
+		}
+		return ""
+	}
+	for _, candidate := range candidates {
+		if strings.EqualFold(answer, candidate.IntentName) {
+			return candidate.IntentName
+		}
+		desc := strings.TrimSpace(candidate.IntentDescription)
+		if desc != "" && (strings.Contains(answer, desc) || (len([]rune(answer)) >= 2 && strings.Contains(desc, answer))) {
+			return candidate.IntentName
+		}
+	}
+	return ""
+}
+
+const (
+	confirmUnknown = iota
+	confirmYes
+	confirmNo
+)
+
+var (
+	confirmYesWords = []string{"是", "是的", "对", "对的", "好", "好的", "嗯", "嗯嗯", "确认", "确定", "可以", "没错", "要", "需要", "yes", "y", "ok"}
+	confirmNoWords  = []string{"不", "不是", "否", "不对", "不要", "不用", "不需要", "错了", "算了", "取消", "没有", "no", "n"}
+)
+
+// resolveConfirmAnswer 判断用户对确认问题的回答，只接受简短的肯定或否定，其他内容视为新的问题
+func resolveConfirmAnswer(content string) int {
+	answer := NormalizeContent(content)
+	for _, word := range confirmNoWords {
+		if answer == word {
+			return confirmNo
+		}
+	}
+	for _, word := range confirmYesWords {
+		if answer == word {
+			return confirmYes
+		}
+	}
+	// “是的，帮我看看”这种以肯定词开头的短句也视为确认
+	runes := []rune(answer)
+	if len(runes) <= 8 {
+		for _, word := range []string{"不是", "不要", "不用", "不对"} {
+			if strings.HasPrefix(answer, word) {
+				return confirmNo
+			}
+		}
+		for _, word := range []string{"是的", "对的", "好的", "确认", "没错", "可以"} {
+			if strings.HasPrefix(answer, word) {
+				return confirmYes
+			}
+		}
+	}
+	return confirmUnknown
+}
+
+// forwardToChat 把用户消息交给多轮对话识别，用户在一个场景中的第一句话会记录下来，多轮对话识别出意图后用来学习意图缓存
+func (t *Expert) forwardToChat(dialogx *DialogInfo, message *TotalMessage, possibleIntentions []PossibleIntentions) {
+	logger.Debug("分配给多轮对话识别")
+
+	dialogx.FirstMutil = !dialogx.Mutil
+	dialogx.FirstMutilContent = ""
+	if dialogx.FirstMutil {
+		dialogx.FirstMutilContent = message.Messages.Content
+	}
+	dialogx.Mutil = true
+
+	toChatMessage := *message
+	toChatMessage.PossibleIntentions = possibleIntentions
+	toChatMessage.Messages.History = types.LegacyHistory(dialogx.ChatHistory)
+	toChatMessage.Messages.HistoryEntries = dialogx.ChatHistory
+
+	msg, err := json.Marshal(toChatMessage)
+	if err != nil {
+		logger.Errorf("Failed to marshal chat message: %v", err)
+	}
+	t.chatMessageHandler(toChatMessage, string(msg))
+}
+
+// routeToProgram 把分配程序库时用户说的话交给 dialogx.Program，意图缺少必填参数时先追问用户，补充完整后再交给程序库
+func (t *Expert) routeToProgram(dialogx *DialogInfo, message *TotalMessage) {
+	slots, missing := t.extractSlots(dialogx.Program, message.Messages.Content, nil, "")
+	if missing != nil {
+		t.askSlot(dialogx, message, slots, missing)
+		return
+	}
+	dialogx.RoutedContent = t.intentMatch.format(message.Messages.Content)
+	routed := *message
+	routed.Slots = slots
+	t.forwardToProgram(dialogx, &routed)
+}
+
+// askSlot 向用户追问缺少的必填参数，补充完整之前不分配程序库，用户的下一句话由 handlePendingIntent 处理
+func (t *Expert) askSlot(dialogx *DialogInfo, message *TotalMessage, slots map[string]any, missing *compiledSlot) {
+	original := *message
+	dialogx.Pending = &PendingIntent{
+		Kind:       types.PendingSlot,
+		Candidates: []PossibleIntentions{{IntentName: dialogx.Program}},
+		Message:    &original,
+		Slots:      slots,
+		Slot:       missing.Name,
+	}
+	logger.Debugf("意图 %s 缺少参数 %s，等待用户补充", dialogx.Program, missing.Name)
+	dialogx.Program = ""
+	t.replyToUser(dialogx, message, missing.prompt(), nil)
+}
+
+// forwardToProgram 把用户消息交给 dialog 当前对接的程序库，消息中没有参数时从用户原始消息中提取
+func (t *Expert) forwardToProgram(dialogx *DialogInfo, message *TotalMessage) {
+	if t.programMessageHandler == nil {
+		logger.Warnf("没有设置返回给程序库的消息处理函数，dialog %s 不能交给程序库 %s", dialogx.DialogID, dialogx.Program)
+		dialogx.Program = ""
+		dialogx.RoutedContent = ""
+		t.replyToUser(dialogx, message, defaultFallbackReply, nil)
+		return
+	}
+	toProgramMessage := *message
+	toProgramMessage.Intention = dialogx.Program
+	if toProgramMessage.Slots == nil {
+		toProgramMessage.Slots, _ = t.extractSlots(dialogx.Program, message.Messages.Content, nil, "")
+	}
+
+	msg, err := json.Marshal(toProgramMessage)
+	if err != nil {
+		logger.Errorf("Failed to marshal client message: %v", err)
+	}
+	logger.Debug("分配到程序库:", dialogx.Program)
+	t.programMessageHandler(toProgramMessage, string(msg))
+}
+
+// replyToUser 由专家直接回复用户，并记录到dialog 历史
+func (t *Expert) replyToUser(dialogx *DialogInfo, message *TotalMessage, content string, intentions []PossibleIntentions) {
+	reply := TotalMessage{
+		EventType:          types.EventServerMessage,
+		DialogID:           message.DialogID,
+		UserId:             message.UserId,
+		MessageID:          uuid.New().String(),
+		PossibleIntentions: intentions,
+	}
+	reply.Messages.Content = content
+	t.appendHistory(dialogx, types.NewHistoryEntry(types.HistorySourceExpert, "", &reply))
+	if t.userMessageHandler == nil {
+		logger.Warnf("没有设置返回给用户的消息处理函数，丢弃 dialog %s 的回复", message.DialogID)
+		return
+	}
+
+	msg, err := json.Marshal(reply)
+	if err != nil {
+		logger.Errorf("Failed to marshal reply message: %v", err)
+	}
+	logger.Infof("【回复用户】:%s", content)
+	t.userMessageHandler(reply, string(msg))
+}
+
+// appendHistory 添加一条历史消息，最多保存 chatSaveHistoryLimit 条
+func (t *Expert) appendHistory(dialogx *DialogInfo, entry types.HistoryEntry) {
+	dialogx.ChatHistory = append(dialogx.ChatHistory, entry)
+	if len(dialogx.ChatHistory) > t.chatSaveHistoryLimit {
+		dialogx.ChatHistory = dialogx.ChatHistory[len(dialogx.ChatHistory)-t.chatSaveHistoryLimit:]
+	}
+}
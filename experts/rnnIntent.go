@@ -3,30 +3,56 @@ package experts
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 
 	ort "github.com/yalue/onnxruntime_go"
 	"github.com/yanyiwu/gojieba"
 )
 
+// RNNIntentManager 管理所有 rnn 意图识别，作为 IntentGroupMatchInter 注册到意图匹配管理器，
+// 每次匹配只分词一次，再通过有限数量的推理槽并发运行各个意图的模型
 type RNNIntentManager struct {
-	libPath         string                // onnxruntime 动态库路径
-	libonce         *sync.Once            // 只加载一次onnxruntime 动态库
-	rnnModelPath    string                // rnn模型相关文件目录
-	rnnModelIntents map[string]*RNNIntent // 活动的rnn 意图识别映射
+	libPath         string                       // onnxruntime 动态库路径
+	rnnModelPath    string                       // rnn模型相关文件目录
+	rnnModelIntents map[string]*RNNIntent        // 活动的rnn 意图识别映射
+	rnnMultiModels  map[string]*MultiClassIntent // 活动的多分类模型，key 为模型目录名称
 	rnnIntentsMutex *sync.RWMutex
+	inferSlots      chan struct{} // 同时进行的推理数量上限，由 rnnIntentsMutex 保护
 }
 
 func NewRNNIntentManager() *RNNIntentManager {
 	return &RNNIntentManager{
 		rnnModelIntents: make(map[string]*RNNIntent),
+		rnnMultiModels:  make(map[string]*MultiClassIntent),
 		rnnIntentsMutex: &sync.RWMutex{},
-		libonce:         &sync.Once{},
+		inferSlots:      make(chan struct{}, runtime.NumCPU()),
 	}
 }
 
+// SetInferConcurrency 设置同时进行的推理数量上限，默认为 cpu 核数。
+// 正在进行的匹配继续使用旧的推理槽，之后的匹配使用新的上限
+func (r *RNNIntentManager) SetInferConcurrency(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	r.rnnIntentsMutex.Lock()
+	defer r.rnnIntentsMutex.Unlock()
+	r.inferSlots = make(chan struct{}, n)
+}
+
+// getInferSlots 返回当前的推理槽，一次匹配的获取和释放必须使用同一个通道
+func (r *RNNIntentManager) getInferSlots() chan struct{} {
+	r.rnnIntentsMutex.RLock()
+	defer r.rnnIntentsMutex.RUnlock()
+	return r.inferSlots
+}
+
 func (r *RNNIntentManager) SetLibPath(path string) {
 	r.libPath = path
 }
@@ -35,6 +61,12 @@ func (r *RNNIntentManager) SetRNNModelPath(path string) {
 	r.rnnModelPath = path
 }
 
+// rnnWeightFile weight.json 的内容，weight 会乘到匹配分数上，threshold 为该意图单独的最低分数
+type rnnWeightFile struct {
+	Weight    *float32 `json:"weight"`
+	Threshold float64  `json:"threshold,omitempty"`
+}
+
 // LoadPersistedRemoteIntents 扫描rnnModelPath目录，加载意图识别
 func (r *RNNIntentManager) LoadRNNModelIntents() {
 	r.InitializeONNX()
@@ -56,27 +88,19 @@ func (r *RNNIntentManager) LoadRNNModelIntents() {
 			intentDir := filepath.Join(r.rnnModelPath, intentName)
 			logger.Infof("Found persisted intent: %s. Attempting to reload.", intentName)
 
-			// Read description from README.md if it exists
-			var description string
-			readmePath := filepath.Join(intentDir, "README.md")
-			if readmeBytes, err := os.ReadFile(readmePath); err == nil {
-				description = string(readmeBytes)
-			}
-
-			// Read weight from weight.json
-			var weight float32 = 1.0 // Default weight
-			weightPath := filepath.Join(intentDir, "weight.json")
-			if weightBytes, err := os.ReadFile(weightPath); err == nil {
-				var weightData map[string]float32
-				if json.Unmarshal(weightBytes, &weightData) == nil {
-					if w, ok := weightData["weight"]; ok {
-						weight = w
-					}
+			if isMultiClassDir(intentDir) {
+				if err := r.loadMultiClassIntent(intentName); err != nil {
+					logger.Errorf("Failed to load multi-class model '%s': %v", intentName, err)
+				} else {
+					count++
 				}
+				continue
 			}
 
+			description, weight, threshold := readRNNIntentMeta(intentDir)
+
 			// Use the existing registration logic to load the intent
-			if err := r.loadRNNIntent(intentName, description, weight); err != nil {
+			if err := r.loadRNNIntent(intentName, description, weight, threshold); err != nil {
 				logger.Errorf("Failed to reload persisted intent '%s': %v", intentName, err)
 			} else {
 				count++
@@ -88,13 +112,44 @@ func (r *RNNIntentManager) LoadRNNModelIntents() {
 	}
 }
 
+// readRNNIntentMeta 读取意图目录下 README.md 中的描述和 weight.json 中的权重、阈值
+func readRNNIntentMeta(intentDir string) (string, float32, float64) {
+	var description string
+	readmePath := filepath.Join(intentDir, "README.md")
+	if readmeBytes, err := os.ReadFile(readmePath); err == nil {
+		description = string(readmeBytes)
+	}
+
+	var weight float32 = 1.0 // Default weight
+	var threshold float64    // 0 代表使用全局最低分数
+	weightPath := filepath.Join(intentDir, "weight.json")
+	if weightBytes, err := os.ReadFile(weightPath); err == nil {
+		var weightData rnnWeightFile
+		if json.Unmarshal(weightBytes, &weightData) == nil {
+			if weightData.Weight != nil {
+				weight = *weightData.Weight
+			}
+			threshold = weightData.Threshold
+		}
+	}
+	return description, weight, threshold
+}
+
+// onnxOnce rnn 意图和 embedding 意图共用一个 onnxruntime 环境，整个进程只初始化一次
+var onnxOnce sync.Once
+
 // InitializeONNX 负责设置共享库路径。
 // 无论此函数被调用多少次，实际的设置操作都只会执行一次。
 func (r *RNNIntentManager) InitializeONNX() {
-	r.libonce.Do(func() {
+	initializeONNX(r.libPath)
+}
+
+// initializeONNX 使用第一次调用时的动态库路径初始化 onnxruntime 环境
+func initializeONNX(libPath string) {
+	onnxOnce.Do(func() {
 		// 在这里放置你的 .so 文件路径
 		// 你可以从环境变量、配置文件或固定路径读取
-		ort.SetSharedLibraryPath(r.libPath)
+		ort.SetSharedLibraryPath(libPath)
 		err := ort.InitializeEnvironment()
 		if err != nil {
 			logger.Errorf("Error initializing ONNX Runtime: %v", err)
@@ -103,7 +158,7 @@ func (r *RNNIntentManager) InitializeONNX() {
 }
 
 // LoadRNNIntent 创建、加载并注册一个新的rnn 意图识别
-func (r *RNNIntentManager) loadRNNIntent(name, description string, weight float32) error {
+func (r *RNNIntentManager) loadRNNIntent(name, description string, weight float32, threshold float64) error {
 	r.rnnIntentsMutex.Lock()
 	defer r.rnnIntentsMutex.Unlock()
 
@@ -111,23 +166,168 @@ func (r *RNNIntentManager) loadRNNIntent(name, description string, weight float3
 		return fmt.Errorf("remote intent with name '%s' already exists", name)
 	}
 
+	newIntent, err := r.newRNNIntent(name, description, weight, threshold)
+	if err != nil {
+		logger.Errorf("加载远程意图 '%s' 失败: %v", name, err)
+		return err
+	}
+
+	r.rnnModelIntents[name] = newIntent
+	logger.Infof("成功加载并注册了新的远程意图: %s", name)
+	return nil
+}
+
+// loadMultiClassIntent 创建、加载并注册一个多分类模型
+func (r *RNNIntentManager) loadMultiClassIntent(name string) error {
+	r.rnnIntentsMutex.Lock()
+	defer r.rnnIntentsMutex.Unlock()
+
+	if _, exists := r.rnnMultiModels[name]; exists {
+		return fmt.Errorf("multi-class model with name '%s' already exists", name)
+	}
+	model, err := r.newMultiClassIntent(name)
+	if err != nil {
+		return err
+	}
+	r.rnnMultiModels[name] = model
+	return nil
+}
+
+// newRNNIntent 创建并加载 rnn 意图识别，加载后用一次推理验证模型可用，失败时释放资源
+func (r *RNNIntentManager) newRNNIntent(name, description string, weight float32, threshold float64) (*RNNIntent, error) {
 	newIntent := &RNNIntent{
 		intentName:    name,
 		intentDesc:    description,
 		Weight:        weight,
+		Threshold:     threshold,
 		modelDataPath: r.rnnModelPath,
+		mu:            &sync.RWMutex{},
 	}
 
-	// LoadModel 会处理 ONNX/Jieba 初始化和向 expert/core 的注册
 	if err := newIntent.LoadModel(); err != nil {
-		logger.Errorf("加载远程意图 '%s' 失败: %v", name, err)
 		newIntent.Close()
-		return err
+		return nil, err
+	}
+	if err := newIntent.smokeTest(); err != nil {
+		newIntent.Close()
+		return nil, err
 	}
+	return newIntent, nil
+}
 
-	r.rnnModelIntents[name] = newIntent
-	logger.Infof("成功加载并注册了新的远程意图: %s", name)
-	return nil
+// ReloadModel 重新加载某个模型目录，有 labels.json 时按多分类模型加载，否则按二分类模型加载。
+// 新模型验证通过后替换并关闭旧模型，返回新模型提供的意图；加载失败时保留旧模型并返回错误。
+func (r *RNNIntentManager) ReloadModel(name string) ([]RNNIntentSetting, error) {
+	r.InitializeONNX()
+	dir := filepath.Join(r.rnnModelPath, name)
+
+	var settings []RNNIntentSetting
+	var newIntent *RNNIntent
+	var newModel *MultiClassIntent
+	if isMultiClassDir(dir) {
+		model, err := r.newMultiClassIntent(name)
+		if err != nil {
+			return nil, err
+		}
+		newModel = model
+		settings = model.settings()
+	} else {
+		description, weight, threshold := readRNNIntentMeta(dir)
+		intent, err := r.newRNNIntent(name, description, weight, threshold)
+		if err != nil {
+			return nil, err
+		}
+		newIntent = intent
+		settings = []RNNIntentSetting{{Name: name, Model: name, Weight: weight, Threshold: threshold}}
+	}
+
+	// 同一个目录可能在二分类和多分类之间切换，两边的旧模型都要替换
+	r.rnnIntentsMutex.Lock()
+	oldIntent, oldModel := r.rnnModelIntents[name], r.rnnMultiModels[name]
+	delete(r.rnnModelIntents, name)
+	delete(r.rnnMultiModels, name)
+	if newIntent != nil {
+		r.rnnModelIntents[name] = newIntent
+	} else {
+		r.rnnMultiModels[name] = newModel
+	}
+	r.rnnIntentsMutex.Unlock()
+
+	// Close 会等待正在使用旧模型的推理完成
+	if oldIntent != nil {
+		oldIntent.Close()
+	}
+	if oldModel != nil {
+		oldModel.Close()
+	}
+	return settings, nil
+}
+
+// HasModel 模型目录是否已加载（二分类或多分类）
+func (r *RNNIntentManager) HasModel(name string) bool {
+	r.rnnIntentsMutex.RLock()
+	defer r.rnnIntentsMutex.RUnlock()
+	_, binary := r.rnnModelIntents[name]
+	_, multi := r.rnnMultiModels[name]
+	return binary || multi
+}
+
+// ModelNames 返回已加载的所有模型目录名称
+func (r *RNNIntentManager) ModelNames() []string {
+	r.rnnIntentsMutex.RLock()
+	defer r.rnnIntentsMutex.RUnlock()
+	names := make([]string, 0, len(r.rnnModelIntents)+len(r.rnnMultiModels))
+	for name := range r.rnnModelIntents {
+		names = append(names, name)
+	}
+	for name := range r.rnnMultiModels {
+		names = append(names, name)
+	}
+	return names
+}
+
+// UnloadModel 卸载模型目录对应的二分类或多分类模型
+func (r *RNNIntentManager) UnloadModel(name string) {
+	r.rnnIntentsMutex.Lock()
+	intent, model := r.rnnModelIntents[name], r.rnnMultiModels[name]
+	delete(r.rnnModelIntents, name)
+	delete(r.rnnMultiModels, name)
+	r.rnnIntentsMutex.Unlock()
+	if intent != nil {
+		intent.Close()
+	}
+	if model != nil {
+		model.Close()
+	}
+	logger.Infof("Unloaded rnn model %s.", name)
+}
+
+// Settings 返回所有已加载模型提供的意图及其权重和阈值
+func (r *RNNIntentManager) Settings() []RNNIntentSetting {
+	r.rnnIntentsMutex.RLock()
+	defer r.rnnIntentsMutex.RUnlock()
+	settings := make([]RNNIntentSetting, 0, len(r.rnnModelIntents))
+	for name, intent := range r.rnnModelIntents {
+		settings = append(settings, RNNIntentSetting{Name: name, Model: name, Weight: intent.Weight, Threshold: intent.Threshold})
+	}
+	for _, model := range r.rnnMultiModels {
+		settings = append(settings, model.settings()...)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		if settings[i].Name != settings[j].Name {
+			return settings[i].Name < settings[j].Name
+		}
+		return settings[i].Model < settings[j].Model
+	})
+	return settings
+}
+
+// GetRNNIntent 获取已加载的 rnn 意图识别
+func (r *RNNIntentManager) GetRNNIntent(name string) (*RNNIntent, bool) {
+	r.rnnIntentsMutex.RLock()
+	defer r.rnnIntentsMutex.RUnlock()
+	intent, ok := r.rnnModelIntents[name]
+	return intent, ok
 }
 
 // UnloadRNNIntent 清理rnn 意图识别实例
@@ -160,15 +360,120 @@ func (r *RNNIntentManager) UnloadALLRNNIntent(intentName string) error {
 		// 5.释放Intent实例持有的ONNX和其他资源
 		remoteIntent.Close()
 	}
+	for _, model := range r.rnnMultiModels {
+		model.Close()
+	}
 
 	r.rnnModelIntents = make(map[string]*RNNIntent)
+	r.rnnMultiModels = make(map[string]*MultiClassIntent)
 
 	logger.Infof("成功注销并清理所有了远程意图: %s", intentName)
 	return nil
 }
 
+// GetAllRNNIntents 返回已加载的 rnn 意图识别的副本
 func (r *RNNIntentManager) GetAllRNNIntents() map[string]*RNNIntent {
-	return r.rnnModelIntents
+	r.rnnIntentsMutex.RLock()
+	defer r.rnnIntentsMutex.RUnlock()
+	intents := make(map[string]*RNNIntent, len(r.rnnModelIntents))
+	for name, intent := range r.rnnModelIntents {
+		intents[name] = intent
+	}
+	return intents
+}
+
+// GetAllMultiClassIntents 返回已加载的多分类模型的副本
+func (r *RNNIntentManager) GetAllMultiClassIntents() map[string]*MultiClassIntent {
+	r.rnnIntentsMutex.RLock()
+	defer r.rnnIntentsMutex.RUnlock()
+	models := make(map[string]*MultiClassIntent, len(r.rnnMultiModels))
+	for name, model := range r.rnnMultiModels {
+		models[name] = model
+	}
+	return models
+}
+
+// GetIntentNames 返回已加载的所有 rnn 意图名称，包括多分类模型中的意图
+func (r *RNNIntentManager) GetIntentNames() []string {
+	r.rnnIntentsMutex.RLock()
+	defer r.rnnIntentsMutex.RUnlock()
+	seen := make(map[string]bool, len(r.rnnModelIntents))
+	names := make([]string, 0, len(r.rnnModelIntents))
+	for name := range r.rnnModelIntents {
+		seen[name] = true
+		names = append(names, name)
+	}
+	for _, model := range r.rnnMultiModels {
+		for _, name := range model.IntentNames() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// MatchingAll 分词一次，然后并发推理所有 rnn 意图和多分类模型，并发数量受推理槽限制。
+// 多分类模型一次推理得到多个意图的分数，同一个意图出现多次时由意图匹配管理器取最高分。
+func (r *RNNIntentManager) MatchingAll(content string, attachments []Attachment) []PossibleIntentions {
+	intents := r.GetAllRNNIntents()
+	models := r.GetAllMultiClassIntents()
+	if len(intents) == 0 && len(models) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(intents))
+	for name := range intents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	modelNames := make([]string, 0, len(models))
+	for name := range models {
+		modelNames = append(modelNames, name)
+	}
+	sort.Strings(modelNames)
+
+	slots := r.getInferSlots()
+	tokens := Tokenize(content)
+	results := make([]PossibleIntentions, len(names))
+	modelResults := make([][]PossibleIntentions, len(modelNames))
+	var wg sync.WaitGroup
+	for idx, name := range names {
+		intent := intents[name]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			probability, err := intent.inferTokens(tokens)
+			if err != nil {
+				logger.Errorf("%v", err)
+			}
+			results[idx] = PossibleIntentions{
+				IntentName:        name,
+				Probability:       probability,
+				IntentDescription: intent.GetIntentDesc(),
+			}
+		}()
+	}
+	for idx, name := range modelNames {
+		model := models[name]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			modelResults[idx] = model.matchingTokens(tokens)
+		}()
+	}
+	wg.Wait()
+	for _, modelResult := range modelResults {
+		results = append(results, modelResult...)
+	}
+	logger.Debugf("rnn intents for text %s: %v", content, results)
+	return results
 }
 
 type RNNIntent struct {
@@ -176,89 +481,102 @@ type RNNIntent struct {
 	jieba         *gojieba.Jieba
 	vocab         map[string]int64
 	session       *ort.DynamicAdvancedSession
-	Weight        float32
+	Weight        float32 // 由 IntentMatchManager 乘到匹配分数上
+	Threshold     float64 // 单独的最低分数，0 代表使用全局最低分数
 	intentName    string
 	intentDesc    string
+	mu            *sync.RWMutex // 推理时读锁，Close 时写锁，保证替换模型时不会释放正在使用的 session
 }
 
-// Close释放与RNNIntent关联的资源。
+// Close释放与RNNIntent关联的资源，会等待正在进行的推理完成。
 func (r *RNNIntent) Close() {
-	if r.jieba != nil {
-		r.jieba.Free()
-	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jieba = nil // 共享的 jieba 不在这里释放
 	if r.session != nil {
 		r.session.Destroy()
+		r.session = nil
 	}
 }
 
 // textToIndices根据词汇表将文本转换为int64索引片段。
 func TextToIndices(text string, vocab map[string]int64, jieba *gojieba.Jieba) []int64 {
 	// Tokenize using jieba
-	tokens := jieba.Cut(text, true)
-
-	// Convert tokens to indices
-	indices := make([]int64, 0, len(tokens))
-	for _, token := range tokens {
-		if index, found := vocab[token]; found {
-			indices = append(indices, index)
-		} else {
-			// Use the index for <UNK> token if word is not in vocab
-			indices = append(indices, vocab["<UNK>"])
-		}
-	}
-	return indices
+	return TokensToIndices(jieba.Cut(text, true), vocab)
 }
 
 func (r *RNNIntent) Matching(content string, attachments []Attachment) float64 {
+	probability, err := r.infer(content)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return 0.0
+	}
+
+	logger.Infof("<<<<<<<<<  Text: %s Intent: %s Probability: %.4f", content, r.intentName, probability)
+
+	// 权重和阈值由 IntentMatchManager 统一处理
+	return probability
+}
+
+// smokeTest 加载模型后用一句话推理一次，检查模型的输入输出是否可用
+func (r *RNNIntent) smokeTest() error {
+	probability, err := r.infer("你好")
+	if err != nil {
+		return fmt.Errorf("smoke inference failed: %w", err)
+	}
+	if math.IsNaN(probability) || probability < 0 || probability > 1 {
+		return fmt.Errorf("smoke inference returned invalid probability %v", probability)
+	}
+	return nil
+}
+
+// infer 分词后推理并返回正类的概率
+func (r *RNNIntent) infer(content string) (float64, error) {
+	return r.inferTokens(Tokenize(content))
+}
 
-	indices := TextToIndices(content, r.vocab, r.jieba)
+// inferTokens 使用已经分好的词推理，多个意图匹配同一句话时共享分词结果
+func (r *RNNIntent) inferTokens(tokens []string) (float64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.session == nil {
+		return 0.0, fmt.Errorf("intent %s model is closed", r.intentName)
+	}
+
+	indices := TokensToIndices(tokens, r.vocab)
 	if len(indices) == 0 {
-		logger.Errorf("Skipping empty input for text: '%s'", content)
-		return 0.0
+		return 0.0, fmt.Errorf("skipping empty input for intent %s", r.intentName)
+	}
+	probabilities, err := runRNNSession(r.session, indices, 2)
+	if err != nil {
+		return 0.0, fmt.Errorf("intent %s text '%s': %w", r.intentName, strings.Join(tokens, ""), err)
 	}
+	return float64(probabilities[1]), nil // Assuming index 1 is the 'positive' class
+}
 
+// runRNNSession 使用分词后的索引运行一次推理，返回形状为 (1, outputSize) 的输出
+func runRNNSession(session *ort.DynamicAdvancedSession, indices []int64, outputSize int) ([]float32, error) {
 	// 使用正确的形状和类型创建输入张量（int64）
 	inputShape := ort.NewShape(1, int64(len(indices)))
 	inputTensor, err := ort.NewTensor(inputShape, indices)
 	if err != nil {
-		logger.Errorf("Failed to create input tensor for text '%s': %v", content, err)
-		return 0.0
+		return nil, fmt.Errorf("failed to create input tensor: %w", err)
 	}
+	defer inputTensor.Destroy()
 
 	// 创建具有固定形状的空输出张量。
-	outputShape := ort.NewShape(1, 2)
+	outputShape := ort.NewShape(1, int64(outputSize))
 	outputTensor, err := ort.NewEmptyTensor[float32](outputShape)
 	if err != nil {
-		logger.Errorf("Failed to create output tensor for text '%s': %v", content, err)
-		return 0.0
+		return nil, fmt.Errorf("failed to create output tensor: %w", err)
 	}
-
-	// 清理本次迭代的张量
-	defer inputTensor.Destroy()
 	defer outputTensor.Destroy()
 
 	// 通过将张量传递给Run方法来运行推理。
-	err = r.session.Run([]ort.Value{inputTensor}, []ort.Value{outputTensor})
-	if err != nil {
-		logger.Errorf("Inference failed for text '%s': %v", content, err)
-	} else {
-		// Get output probabilities
-		probabilities := outputTensor.GetData()
-		positiveProbability := probabilities[1] // Assuming index 1 is the 'positive' class
-
-		logger.Infof("<<<<<<<<<  Text: %s Probability: %.4f", content, positiveProbability)
-
-		// --- 4. 业务逻辑 ---
-		if (positiveProbability * r.Weight) > 0.90 {
-			logger.Debug("意图匹配成功")
-			return float64(positiveProbability)
-		} else {
-			logger.Debug("意图: 其他")
-			return float64(positiveProbability)
-		}
+	if err := session.Run([]ort.Value{inputTensor}, []ort.Value{outputTensor}); err != nil {
+		return nil, fmt.Errorf("inference failed: %w", err)
 	}
-
-	return 0.0
+	return append([]float32(nil), outputTensor.GetData()...), nil
 }
 
 func (r *RNNIntent) GetIntentName() string {
@@ -275,28 +593,53 @@ func (r *RNNIntent) GetIntentExpertMatch() IntentMatchInter {
 
 func (r *RNNIntent) LoadModel() error {
 
-	r.jieba = gojieba.NewJieba()
+	r.jieba = sharedJieba()
 
-	vocabFilePath := filepath.Join(r.modelDataPath, r.intentName, "vocab_rnn.json")
+	vocab, err := loadRNNVocab(filepath.Join(r.modelDataPath, r.intentName, "vocab_rnn.json"))
+	if err != nil {
+		return err
+	}
+	r.vocab = vocab
+
+	r.session, err = newRNNSession(filepath.Join(r.modelDataPath, r.intentName, "model_rnn.onnx"))
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("rnn意图识别 %s -- 注册成功", r.intentName)
+	return nil
+}
+
+// loadRNNVocab 读取 vocab_rnn.json 词表
+func loadRNNVocab(vocabFilePath string) (map[string]int64, error) {
 	vocabFile, err := os.Open(vocabFilePath)
 	if err != nil {
-		return fmt.Errorf("failed to open vocab file '%s': %w", vocabFilePath, err)
+		return nil, fmt.Errorf("failed to open vocab file '%s': %w", vocabFilePath, err)
 	}
 	defer vocabFile.Close()
 
-	if err := json.NewDecoder(vocabFile).Decode(&(r.vocab)); err != nil {
-		return fmt.Errorf("failed to decode vocab file: %w", err)
+	var vocab map[string]int64
+	if err := json.NewDecoder(vocabFile).Decode(&vocab); err != nil {
+		return nil, fmt.Errorf("failed to decode vocab file: %w", err)
 	}
+	return vocab, nil
+}
 
-	// --- 2. Create a Dynamic AdvancedSession ---
-	// Use DynamicAdvancedSession for models with dynamic input shapes.
-	modelPath := filepath.Join(r.modelDataPath, r.intentName, "model_rnn.onnx")
-	r.session, err = ort.NewDynamicAdvancedSession(modelPath,
-		[]string{"input"}, []string{"output"}, nil)
+// newRNNSession 创建输入为 input、输出为 output 的动态形状 session
+func newRNNSession(modelPath string) (*ort.DynamicAdvancedSession, error) {
+	// 并发由 RNNIntentManager 的推理槽控制，每个 session 只使用一个线程，避免多个意图同时推理时线程过多
+	options, err := ort.NewSessionOptions()
 	if err != nil {
-		return fmt.Errorf("failed to create dynamic session for model '%s': %w", modelPath, err)
+		return nil, fmt.Errorf("failed to create session options: %w", err)
 	}
-
-	logger.Infof("rnn意图识别 %s -- 注册成功", r.intentName)
-	return nil
+	defer options.Destroy()
+	if err := options.SetIntraOpNumThreads(1); err != nil {
+		return nil, fmt.Errorf("failed to set session threads: %w", err)
+	}
+	session, err := ort.NewDynamicAdvancedSession(modelPath,
+		[]string{"input"}, []string{"output"}, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic session for model '%s': %w", modelPath, err)
+	}
+	return session, nil
 }
@@ -0,0 +1,48 @@
+package experts
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetInferConcurrencyDuringMatch(t *testing.T) {
+	manager := NewRNNIntentManager()
+	manager.SetInferConcurrency(1)
+
+	// 模拟 MatchingAll 中的推理：获取前取到推理槽，释放时使用同一个通道
+	slots := manager.getInferSlots()
+	slots <- struct{}{}
+	manager.SetInferConcurrency(2)
+
+	released := make(chan struct{})
+	go func() {
+		<-slots
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("release from old infer slots blocked")
+	}
+	if got := cap(manager.getInferSlots()); got != 2 {
+		t.Errorf("infer slots cap = %d, want 2", got)
+	}
+
+	// 并发设置和读取推理槽，配合 -race 检查数据竞争
+	var wg sync.WaitGroup
+	for n := 1; n <= 8; n++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			manager.SetInferConcurrency(n)
+		}()
+		go func() {
+			defer wg.Done()
+			slots := manager.getInferSlots()
+			slots <- struct{}{}
+			<-slots
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,254 @@
+package experts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// rnnLabelsFile 模型目录中存在该文件时按多分类模型加载
+const rnnLabelsFile = "labels.json"
+
+// multiClassLabel labels.json 中的一项，顺序与模型输出的维度一一对应。
+// 可以直接写意图名称字符串，也可以写对象单独设置描述、权重和阈值；名称为空的类别（例如“其他”）不注册为意图。
+type multiClassLabel struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Weight      *float32 `json:"weight,omitempty"`    // 为空时使用目录下 weight.json 的权重
+	Threshold   float64  `json:"threshold,omitempty"` // 为 0 时使用目录下 weight.json 的阈值
+}
+
+func (l *multiClassLabel) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*l = multiClassLabel{Name: name}
+		return nil
+	}
+	type label multiClassLabel
+	return json.Unmarshal(data, (*label)(l))
+}
+
+// RNNIntentSetting rnn 模型提供的一个意图以及它在意图匹配管理器中的权重和阈值
+type RNNIntentSetting struct {
+	Name       string
+	Model      string // 模型目录名称，二分类模型与意图名称相同
+	MultiClass bool
+	Weight     float32
+	Threshold  float64
+}
+
+// isMultiClassDir 模型目录中有 labels.json 时为多分类模型
+func isMultiClassDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, rnnLabelsFile))
+	return err == nil
+}
+
+// MultiClassIntent 一个多分类模型，一次推理得到 labels.json 中所有意图的分数。
+// 目录结构与二分类模型相同：<rnn 模型目录>/<模型名称>/ 下放 model_rnn.onnx、vocab_rnn.json、labels.json，
+// 可选 weight.json 作为所有意图的默认权重和阈值。
+type MultiClassIntent struct {
+	modelName     string
+	modelDataPath string
+	labels        []multiClassLabel
+	vocab         map[string]int64
+	session       *ort.DynamicAdvancedSession
+	Weight        float32       // 默认权重
+	Threshold     float64       // 默认阈值
+	mu            *sync.RWMutex // 推理时读锁，Close 时写锁
+}
+
+// newMultiClassIntent 创建并加载多分类模型，加载后用一次推理验证模型可用，失败时释放资源
+func (r *RNNIntentManager) newMultiClassIntent(name string) (*MultiClassIntent, error) {
+	dir := filepath.Join(r.rnnModelPath, name)
+	_, weight, threshold := readRNNIntentMeta(dir)
+	model := &MultiClassIntent{
+		modelName:     name,
+		modelDataPath: r.rnnModelPath,
+		Weight:        weight,
+		Threshold:     threshold,
+		mu:            &sync.RWMutex{},
+	}
+	if err := model.LoadModel(); err != nil {
+		model.Close()
+		return nil, err
+	}
+	if err := model.smokeTest(); err != nil {
+		model.Close()
+		return nil, err
+	}
+	return model, nil
+}
+
+func (m *MultiClassIntent) LoadModel() error {
+	sharedJieba() // 与二分类模型共用分词器，提前初始化
+
+	dir := filepath.Join(m.modelDataPath, m.modelName)
+	labelsBytes, err := os.ReadFile(filepath.Join(dir, rnnLabelsFile))
+	if err != nil {
+		return fmt.Errorf("failed to read labels file: %w", err)
+	}
+	if err := json.Unmarshal(labelsBytes, &m.labels); err != nil {
+		return fmt.Errorf("failed to decode labels file: %w", err)
+	}
+	if len(m.labels) < 2 {
+		return fmt.Errorf("multi-class model %s needs at least 2 labels, got %d", m.modelName, len(m.labels))
+	}
+	seen := make(map[string]bool, len(m.labels))
+	for _, label := range m.labels {
+		if label.Name == "" {
+			continue
+		}
+		if seen[label.Name] {
+			return fmt.Errorf("duplicate label %s in multi-class model %s", label.Name, m.modelName)
+		}
+		seen[label.Name] = true
+	}
+
+	m.vocab, err = loadRNNVocab(filepath.Join(dir, "vocab_rnn.json"))
+	if err != nil {
+		return err
+	}
+	m.session, err = newRNNSession(filepath.Join(dir, "model_rnn.onnx"))
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("rnn多分类意图识别 %s -- 注册成功，意图: %v", m.modelName, m.IntentNames())
+	return nil
+}
+
+// Close 释放模型资源，会等待正在进行的推理完成
+func (m *MultiClassIntent) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.session != nil {
+		m.session.Destroy()
+		m.session = nil
+	}
+}
+
+// IntentNames 返回模型提供的意图名称，不包括名称为空的类别
+func (m *MultiClassIntent) IntentNames() []string {
+	names := make([]string, 0, len(m.labels))
+	for _, label := range m.labels {
+		if label.Name != "" {
+			names = append(names, label.Name)
+		}
+	}
+	return names
+}
+
+// settings 返回每个意图的权重和阈值，标签中没有设置时使用模型的默认值
+func (m *MultiClassIntent) settings() []RNNIntentSetting {
+	settings := make([]RNNIntentSetting, 0, len(m.labels))
+	for _, label := range m.labels {
+		if label.Name == "" {
+			continue
+		}
+		setting := RNNIntentSetting{
+			Name:       label.Name,
+			Model:      m.modelName,
+			MultiClass: true,
+			Weight:     m.Weight,
+			Threshold:  m.Threshold,
+		}
+		if label.Weight != nil {
+			setting.Weight = *label.Weight
+		}
+		if label.Threshold > 0 {
+			setting.Threshold = label.Threshold
+		}
+		settings = append(settings, setting)
+	}
+	return settings
+}
+
+// inferTokens 推理一次，返回每个类别的概率，顺序与 labels.json 相同
+func (m *MultiClassIntent) inferTokens(tokens []string) ([]float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.session == nil {
+		return nil, fmt.Errorf("multi-class model %s is closed", m.modelName)
+	}
+
+	indices := TokensToIndices(tokens, m.vocab)
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("skipping empty input for multi-class model %s", m.modelName)
+	}
+	output, err := runRNNSession(m.session, indices, len(m.labels))
+	if err != nil {
+		return nil, fmt.Errorf("multi-class model %s text '%s': %w", m.modelName, strings.Join(tokens, ""), err)
+	}
+	return toProbabilities(output), nil
+}
+
+// matchingTokens 推理一次并展开为每个意图的匹配结果
+func (m *MultiClassIntent) matchingTokens(tokens []string) []PossibleIntentions {
+	probabilities, err := m.inferTokens(tokens)
+	if err != nil {
+		logger.Errorf("%v", err)
+		probabilities = make([]float64, len(m.labels))
+	}
+	results := make([]PossibleIntentions, 0, len(m.labels))
+	for idx, label := range m.labels {
+		if label.Name == "" {
+			continue
+		}
+		results = append(results, PossibleIntentions{
+			IntentName:        label.Name,
+			Probability:       probabilities[idx],
+			IntentDescription: label.Description,
+		})
+	}
+	return results
+}
+
+// smokeTest 加载模型后用一句话推理一次，检查输出维度和 labels.json 是否一致
+func (m *MultiClassIntent) smokeTest() error {
+	probabilities, err := m.inferTokens(Tokenize("你好"))
+	if err != nil {
+		return fmt.Errorf("smoke inference failed: %w", err)
+	}
+	for _, probability := range probabilities {
+		if math.IsNaN(probability) || probability < 0 || probability > 1 {
+			return fmt.Errorf("smoke inference returned invalid probabilities %v", probabilities)
+		}
+	}
+	return nil
+}
+
+// toProbabilities 模型输出已经是概率分布（都在 [0,1] 且和为 1）时直接使用，否则当作 logits 计算 softmax
+func toProbabilities(output []float32) []float64 {
+	probabilities := make([]float64, len(output))
+	sum := 0.0
+	isDistribution := true
+	maxLogit := math.Inf(-1)
+	for idx, value := range output {
+		v := float64(value)
+		probabilities[idx] = v
+		sum += v
+		if v < 0 || v > 1 {
+			isDistribution = false
+		}
+		maxLogit = math.Max(maxLogit, v)
+	}
+	if isDistribution && math.Abs(sum-1) < 1e-3 {
+		return probabilities
+	}
+
+	sum = 0
+	for idx, v := range probabilities {
+		probabilities[idx] = math.Exp(v - maxLogit)
+		sum += probabilities[idx]
+	}
+	for idx := range probabilities {
+		probabilities[idx] /= sum
+	}
+	return probabilities
+}
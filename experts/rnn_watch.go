@@ -0,0 +1,162 @@
+package experts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// rnnReloadDelay 意图目录最后一次变化后等待多久再重新加载，避免文件还没复制完
+const rnnReloadDelay = 2 * time.Second
+
+// rnnGroupName rnn 意图在意图匹配管理器中注册的名称
+const rnnGroupName = "rnn"
+
+// rnnIntentFiles 意图目录中变化后需要重新加载的文件
+var rnnIntentFiles = map[string]bool{
+	"model_rnn.onnx": true,
+	"vocab_rnn.json": true,
+	"README.md":      true,
+	"weight.json":    true,
+	rnnLabelsFile:    true,
+}
+
+// SetWatchRNNIntentPath 设置是否监听 rnn 模型目录，意图目录中的模型、词表、描述或权重文件变化后自动重新加载该意图，
+// 目录删除后注销该意图。需要在 Run 之前设置。
+func (t *Expert) SetWatchRNNIntentPath(watch bool) {
+	t.watchRNNIntent = watch
+	logger.Info("Watch rnn intent path set to:", watch)
+}
+
+// watchRNNIntentPath 监听 rnn 模型目录和其中的每个意图目录，直到专家关闭
+func (t *Expert) watchRNNIntentPath() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Errorf("Failed to create rnn intent watcher: %v", err)
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(t.rnnIntentPath); err != nil {
+		logger.Errorf("Failed to watch rnn intent path '%s': %v", t.rnnIntentPath, err)
+		return
+	}
+	if entries, err := os.ReadDir(t.rnnIntentPath); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				t.addRNNIntentWatch(watcher, entry.Name())
+			}
+		}
+	}
+	logger.Info("Watching rnn intent path:", t.rnnIntentPath)
+
+	timers := make(map[string]*time.Timer)
+	due := make(chan string)
+	schedule := func(name string) {
+		if timer, ok := timers[name]; ok {
+			timer.Reset(rnnReloadDelay)
+			return
+		}
+		timers[name] = time.AfterFunc(rnnReloadDelay, func() {
+			select {
+			case due <- name:
+			case <-t.stopChan:
+			}
+		})
+	}
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			rel, err := filepath.Rel(t.rnnIntentPath, event.Name)
+			if err != nil {
+				continue
+			}
+			parts := strings.Split(rel, string(filepath.Separator))
+			switch len(parts) {
+			case 1: // 意图目录本身创建或删除
+				if event.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err != nil || !info.IsDir() {
+						continue
+					}
+					t.addRNNIntentWatch(watcher, parts[0])
+				} else if !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+				schedule(parts[0])
+			case 2: // 意图目录中的文件变化
+				if rnnIntentFiles[parts[1]] && !event.Has(fsnotify.Chmod) {
+					schedule(parts[0])
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Errorf("rnn intent watcher error: %v", err)
+		case name := <-due:
+			delete(timers, name)
+			t.reloadRNNIntent(name)
+		case <-t.stopChan:
+			return
+		}
+	}
+}
+
+func (t *Expert) addRNNIntentWatch(watcher *fsnotify.Watcher, name string) {
+	if err := watcher.Add(filepath.Join(t.rnnIntentPath, name)); err != nil {
+		logger.Errorf("Failed to watch rnn intent dir '%s': %v", name, err)
+	}
+}
+
+// reloadRNNIntent 重新加载单个 rnn 模型目录：模型不存在时注销，否则加载新模型并推理验证，通过后替换，失败时保留旧模型。
+// 目录中有 labels.json 时按多分类模型加载，labels.json 中删除的意图会一并注销。
+func (t *Expert) reloadRNNIntent(name string) {
+	t.rnnReloadMutex.Lock()
+	defer t.rnnReloadMutex.Unlock()
+
+	dir := filepath.Join(t.rnnIntentPath, name)
+	loaded := t.rnnIntent.HasModel(name)
+	if _, err := os.Stat(filepath.Join(dir, "model_rnn.onnx")); err != nil {
+		if loaded {
+			logger.Infof("RNN model %s removed, unregistering.", name)
+			t.rnnIntent.UnloadModel(name)
+			t.intentMatch.PurgeUnregisteredCache() // 删除指向已注销意图的缓存
+		}
+		return
+	}
+	if !loaded && !isMultiClassDir(dir) && t.intentMatch.matcher(name) != nil {
+		logger.Warnf("Intent %s is registered by code, skip loading rnn model with the same name.", name)
+		return
+	}
+
+	settings, err := t.rnnIntent.ReloadModel(name)
+	if err != nil {
+		logger.Errorf("Failed to reload rnn model %s, keeping the current model: %v", name, err)
+		return
+	}
+	// rnn 意图管理器中已经替换，新的匹配请求使用新模型
+	for _, setting := range settings {
+		if t.intentMatch.matcher(setting.Name) != nil {
+			continue // 代码注册的同名意图保留自己的权重和阈值
+		}
+		t.intentMatch.SetIntentWeight(setting.Name, float64(setting.Weight))
+		t.intentMatch.SetIntentThreshold(setting.Name, setting.Threshold)
+		if removed := t.intentMatch.InvalidateCache(setting.Name); removed > 0 {
+			logger.Infof("Invalidated %d Intent cache records of reloaded intent %s.", removed, setting.Name)
+		}
+	}
+	t.intentMatch.PurgeUnregisteredCache()
+	logger.Infof("RNN model %s reloaded.", name)
+}
@@ -0,0 +1,273 @@
+package experts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleIntentSpec 规则意图文件的内容，一个文件定义一个意图，支持 yaml 和 json
+type RuleIntentSpec struct {
+	Name        string     `json:"name" yaml:"name"`
+	Description string     `json:"description" yaml:"description"`
+	Exclude     []string   `json:"exclude,omitempty" yaml:"exclude,omitempty"` // 任意一个正则匹配时意图分数为 0
+	Rules       []RuleSpec `json:"rules" yaml:"rules"`
+	Slots       []SlotSpec `json:"slots,omitempty" yaml:"slots,omitempty"` // 意图需要的参数，见 SlotSpec
+}
+
+// RuleSpec 一条规则，所有条件都满足时规则命中，意图分数取命中规则中最高的置信度
+type RuleSpec struct {
+	Include     []string   `json:"include,omitempty" yaml:"include,omitempty"`         // 所有正则都要匹配
+	Exclude     []string   `json:"exclude,omitempty" yaml:"exclude,omitempty"`         // 任意一个正则匹配时跳过此规则
+	Keywords    [][]string `json:"keywords,omitempty" yaml:"keywords,omitempty"`       // 每组关键词至少出现一个，不区分大小写
+	Attachments []string   `json:"attachments,omitempty" yaml:"attachments,omitempty"` // 需要的附件类型，每种至少有一个
+	Certainty   float64    `json:"certainty" yaml:"certainty"`                         // 命中时的分数，0 到 1
+	Question    string     `json:"question,omitempty" yaml:"question,omitempty"`       // 用于低置信度匹配时向用户确认的澄清问题
+}
+
+type compiledRule struct {
+	include     []*regexp.Regexp
+	exclude     []*regexp.Regexp
+	keywords    [][]string
+	attachments []string
+	certainty   float64
+	question    string
+}
+
+// RuleIntent 由规则文件定义的意图匹配器，不需要写代码和重新编译
+type RuleIntent struct {
+	intentName string
+	intentDesc string
+	exclude    []*regexp.Regexp
+	rules      []compiledRule
+	slots      []SlotSpec
+}
+
+// NewRuleIntent 编译规则，正则错误或规则没有任何条件时返回错误
+func NewRuleIntent(spec RuleIntentSpec) (*RuleIntent, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("rule intent name is required")
+	}
+	if len(spec.Rules) == 0 {
+		return nil, fmt.Errorf("rule intent %s has no rules", spec.Name)
+	}
+	exclude, err := compilePatterns(spec.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("rule intent %s exclude: %w", spec.Name, err)
+	}
+	if _, err := compileSlots(spec.Slots); err != nil {
+		return nil, fmt.Errorf("rule intent %s slots: %w", spec.Name, err)
+	}
+	intent := &RuleIntent{
+		intentName: spec.Name,
+		intentDesc: spec.Description,
+		exclude:    exclude,
+		slots:      spec.Slots,
+	}
+	for idx, ruleSpec := range spec.Rules {
+		if len(ruleSpec.Include) == 0 && len(ruleSpec.Keywords) == 0 && len(ruleSpec.Attachments) == 0 {
+			return nil, fmt.Errorf("rule intent %s rule %d has no include, keywords or attachments", spec.Name, idx)
+		}
+		if ruleSpec.Certainty < 0 || ruleSpec.Certainty > 1 {
+			return nil, fmt.Errorf("rule intent %s rule %d certainty %v is out of [0, 1]", spec.Name, idx, ruleSpec.Certainty)
+		}
+		rule := compiledRule{
+			attachments: ruleSpec.Attachments,
+			certainty:   ruleSpec.Certainty,
+			question:    ruleSpec.Question,
+		}
+		if rule.include, err = compilePatterns(ruleSpec.Include); err != nil {
+			return nil, fmt.Errorf("rule intent %s rule %d include: %w", spec.Name, idx, err)
+		}
+		if rule.exclude, err = compilePatterns(ruleSpec.Exclude); err != nil {
+			return nil, fmt.Errorf("rule intent %s rule %d exclude: %w", spec.Name, idx, err)
+		}
+		for _, group := range ruleSpec.Keywords {
+			lowered := make([]string, 0, len(group))
+			for _, keyword := range group {
+				if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+					lowered = append(lowered, keyword)
+				}
+			}
+			if len(lowered) == 0 {
+				return nil, fmt.Errorf("rule intent %s rule %d has an empty keyword set", spec.Name, idx)
+			}
+			rule.keywords = append(rule.keywords, lowered)
+		}
+		intent.rules = append(intent.rules, rule)
+	}
+	return intent, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// LoadRuleIntentFile 读取一个规则文件，按扩展名（.yaml .yml .json）选择格式
+func LoadRuleIntentFile(path string) (*RuleIntent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec RuleIntentSpec
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &spec)
+	case ".json":
+		err = json.Unmarshal(data, &spec)
+	default:
+		return nil, fmt.Errorf("unsupported rule file format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rule file '%s': %w", path, err)
+	}
+	intent, err := NewRuleIntent(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule file '%s': %w", path, err)
+	}
+	return intent, nil
+}
+
+// LoadRuleIntents 读取规则文件，path 为目录时读取其中所有 .yaml .yml .json 文件（不递归），按文件名排序
+func LoadRuleIntents(path string) ([]*RuleIntent, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+		sort.Strings(files)
+	}
+
+	intents := make([]*RuleIntent, 0, len(files))
+	seen := make(map[string]string, len(files))
+	for _, file := range files {
+		intent, err := LoadRuleIntentFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if previous, ok := seen[intent.intentName]; ok {
+			return nil, fmt.Errorf("rule intent %s is defined in both '%s' and '%s'", intent.intentName, previous, file)
+		}
+		seen[intent.intentName] = file
+		intents = append(intents, intent)
+	}
+	return intents, nil
+}
+
+func (r *RuleIntent) GetIntentName() string {
+	return r.intentName
+}
+
+func (r *RuleIntent) GetIntentDesc() string {
+	return r.intentDesc
+}
+
+// Slots 实现 IntentSlotInter，返回规则文件中定义的参数
+func (r *RuleIntent) Slots() []SlotSpec {
+	return r.slots
+}
+
+func (r *RuleIntent) Matching(content string, attachments []Attachment) float64 {
+	certainty, _ := r.MatchingWithQuestion(content, attachments)
+	return certainty
+}
+
+// MatchingWithQuestion 返回命中规则中最高的置信度，以及该规则的澄清问题（没有时为空）
+func (r *RuleIntent) MatchingWithQuestion(content string, attachments []Attachment) (float64, string) {
+	for _, re := range r.exclude {
+		if re.MatchString(content) {
+			return 0, ""
+		}
+	}
+	lowered := strings.ToLower(content)
+	best, question := 0.0, ""
+	for _, rule := range r.rules {
+		if rule.certainty > best && rule.matches(content, lowered, attachments) {
+			best, question = rule.certainty, rule.question
+		}
+	}
+	return best, question
+}
+
+func (c *compiledRule) matches(content string, lowered string, attachments []Attachment) bool {
+	for _, re := range c.exclude {
+		if re.MatchString(content) {
+			return false
+		}
+	}
+	for _, re := range c.include {
+		if !re.MatchString(content) {
+			return false
+		}
+	}
+	for _, group := range c.keywords {
+		found := false
+		for _, keyword := range group {
+			if strings.Contains(lowered, keyword) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, attachmentType := range c.attachments {
+		found := false
+		for _, attachment := range attachments {
+			if attachment.Type == attachmentType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadRuleIntents 从规则文件或目录加载意图并通过 Register 注册，已经注册的同名意图会跳过
+func (t *Expert) LoadRuleIntents(path string) error {
+	intents, err := LoadRuleIntents(path)
+	if err != nil {
+		return err
+	}
+	for _, intent := range intents {
+		if t.intentMatch.IsRegistered(intent.intentName) {
+			logger.Warnf("Intent %s is already registered, skip rule intent with the same name.", intent.intentName)
+			continue
+		}
+		t.Register(func() IntentMatchInter { return intent }, intent.intentName)
+		logger.Infof("Rule intent %s registered with %d rules.", intent.intentName, len(intent.rules))
+	}
+	return nil
+}
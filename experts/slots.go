@@ -0,0 +1,273 @@
+package experts
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SlotURL   = "url"   // http/https 链接
+	SlotTag   = "tag"   // 版本标签，例如 v1.2、release-v1.2.3
+	SlotDate  = "date"  // 日期，统一转换为 2006-01-02，支持 今天/明天/昨天
+	SlotEnum  = "enum"  // 可选值之一，支持别名
+	SlotRegex = "regex" // 自定义正则，有分组时取第一个分组
+	SlotText  = "text"  // 任意文本，只能在专家追问时由用户的回答填写
+)
+
+// SlotSpec 意图需要的一个参数，提取到的值放在发给程序库的 TotalMessage.Slots 中
+type SlotSpec struct {
+	Name     string            `json:"name" yaml:"name"`
+	Type     string            `json:"type" yaml:"type"`                             // 见 SlotURL 等
+	Required bool              `json:"required,omitempty" yaml:"required,omitempty"` // 缺少时专家先向用户追问，再交给程序库
+	Prompt   string            `json:"prompt,omitempty" yaml:"prompt,omitempty"`     // 追问用户的问题，为空时使用默认问题
+	Pattern  string            `json:"pattern,omitempty" yaml:"pattern,omitempty"`   // 覆盖 url、tag、regex 类型的正则
+	Values   []string          `json:"values,omitempty" yaml:"values,omitempty"`     // enum 类型的可选值
+	Aliases  map[string]string `json:"aliases,omitempty" yaml:"aliases,omitempty"`   // enum 类型的别名到可选值的映射
+}
+
+// IntentSlotInter 可选接口，匹配器实现后声明意图需要的参数，SetIntentSlots 设置的参数优先
+type IntentSlotInter interface {
+	Slots() []SlotSpec
+}
+
+var (
+	slotURLRegex = regexp.MustCompile(`https?://[^\s，。；、]+`)
+	slotTagRegex = regexp.MustCompile(`(?:[A-Za-z0-9_]+-)?v\d+(?:\.\d+)+`)
+
+	slotDateRegexes = []*regexp.Regexp{
+		regexp.MustCompile(`(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})`),
+		regexp.MustCompile(`(\d{4})年(\d{1,2})月(\d{1,2})[日号]`),
+		regexp.MustCompile(`()(\d{1,2})月(\d{1,2})[日号]`),
+	}
+	slotRelativeDates = []struct {
+		word string
+		days int
+	}{{"大后天", 3}, {"后天", 2}, {"明天", 1}, {"今天", 0}, {"大前天", -3}, {"前天", -2}, {"昨天", -1}}
+)
+
+// compiledSlot 编译好正则的参数定义
+type compiledSlot struct {
+	SlotSpec
+	pattern *regexp.Regexp
+}
+
+func compileSlot(spec SlotSpec) (compiledSlot, error) {
+	slot := compiledSlot{SlotSpec: spec}
+	if spec.Name == "" {
+		return slot, fmt.Errorf("slot name is required")
+	}
+	switch spec.Type {
+	case SlotURL:
+		slot.pattern = slotURLRegex
+	case SlotTag:
+		slot.pattern = slotTagRegex
+	case SlotDate, SlotText:
+	case SlotEnum:
+		if len(spec.Values) == 0 {
+			return slot, fmt.Errorf("enum slot %s has no values", spec.Name)
+		}
+	case SlotRegex:
+		if spec.Pattern == "" {
+			return slot, fmt.Errorf("regex slot %s has no pattern", spec.Name)
+		}
+	default:
+		return slot, fmt.Errorf("unknown slot type %q of slot %s", spec.Type, spec.Name)
+	}
+	if spec.Pattern != "" {
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return slot, fmt.Errorf("slot %s pattern: %w", spec.Name, err)
+		}
+		slot.pattern = re
+	}
+	return slot, nil
+}
+
+// extract 从文本中提取参数值，answer 为 true 时文本是用户对追问该参数的回答，text 类型取整句话
+func (s *compiledSlot) extract(content string, answer bool, now time.Time) (any, bool) {
+	switch s.Type {
+	case SlotText:
+		content = strings.TrimSpace(content)
+		return content, answer && content != ""
+	case SlotDate:
+		return extractDate(content, now)
+	case SlotEnum:
+		lowered := strings.ToLower(content)
+		for _, value := range s.Values {
+			if strings.Contains(lowered, strings.ToLower(value)) {
+				return value, true
+			}
+		}
+		for alias, value := range s.Aliases {
+			if strings.Contains(lowered, strings.ToLower(alias)) {
+				return value, true
+			}
+		}
+		return nil, false
+	default:
+		match := s.pattern.FindStringSubmatch(content)
+		if match == nil {
+			return nil, false
+		}
+		if len(match) > 1 {
+			return match[1], true
+		}
+		return match[0], true
+	}
+}
+
+func extractDate(content string, now time.Time) (any, bool) {
+	for _, re := range slotDateRegexes {
+		match := re.FindStringSubmatch(content)
+		if match == nil {
+			continue
+		}
+		year := now.Year()
+		if match[1] != "" {
+			year, _ = strconv.Atoi(match[1])
+		}
+		month, _ := strconv.Atoi(match[2])
+		day, _ := strconv.Atoi(match[3])
+		date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, now.Location())
+		if date.Month() != time.Month(month) || date.Day() != day {
+			continue // 不存在的日期，例如 2月30日
+		}
+		return date.Format(time.DateOnly), true
+	}
+	for _, relative := range slotRelativeDates {
+		if strings.Contains(content, relative.word) {
+			return now.AddDate(0, 0, relative.days).Format(time.DateOnly), true
+		}
+	}
+	return nil, false
+}
+
+// prompt 缺少参数时追问用户的问题
+func (s *compiledSlot) prompt() string {
+	if s.Prompt != "" {
+		return s.Prompt
+	}
+	if s.Type == SlotEnum {
+		return fmt.Sprintf("请提供%s（%s）", s.Name, strings.Join(s.Values, "/"))
+	}
+	return fmt.Sprintf("请提供%s", s.Name)
+}
+
+// SlotRegistry 按意图名称保存参数定义
+type SlotRegistry struct {
+	mu    *sync.RWMutex
+	slots map[string][]compiledSlot
+}
+
+func NewSlotRegistry() *SlotRegistry {
+	return &SlotRegistry{
+		mu:    &sync.RWMutex{},
+		slots: make(map[string][]compiledSlot),
+	}
+}
+
+// Set 设置意图的参数定义，specs 为空时删除
+func (r *SlotRegistry) Set(intent string, specs []SlotSpec) error {
+	compiled, err := compileSlots(specs)
+	if err != nil {
+		return fmt.Errorf("intent %s: %w", intent, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(compiled) == 0 {
+		delete(r.slots, intent)
+		return nil
+	}
+	r.slots[intent] = compiled
+	return nil
+}
+
+func (r *SlotRegistry) get(intent string) ([]compiledSlot, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	slots, ok := r.slots[intent]
+	return slots, ok
+}
+
+func compileSlots(specs []SlotSpec) ([]compiledSlot, error) {
+	compiled := make([]compiledSlot, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("duplicate slot %s", spec.Name)
+		}
+		seen[spec.Name] = true
+		slot, err := compileSlot(spec)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, slot)
+	}
+	return compiled, nil
+}
+
+// SetIntentSlots 设置意图需要的参数，覆盖匹配器通过 IntentSlotInter 声明的参数，specs 为空时恢复使用匹配器声明的参数
+func (t *Expert) SetIntentSlots(intent string, specs []SlotSpec) error {
+	if err := t.slots.Set(intent, specs); err != nil {
+		return err
+	}
+	logger.Infof("Intent %s slots set: %d.", intent, len(specs))
+	return nil
+}
+
+// intentSlots 返回意图的参数定义，先查 SetIntentSlots 设置的，再查匹配器声明的
+func (t *Expert) intentSlots(intent string) []compiledSlot {
+	if slots, ok := t.slots.get(intent); ok {
+		return slots
+	}
+	newMatcher := t.intentMatch.matcher(intent)
+	if newMatcher == nil {
+		return nil
+	}
+	declarer, ok := newMatcher().(IntentSlotInter)
+	if !ok {
+		return nil
+	}
+	slots, err := compileSlots(declarer.Slots())
+	if err != nil {
+		logger.Errorf("Invalid slots of intent %s: %v", intent, err)
+		return nil
+	}
+	return slots
+}
+
+// extractSlots 从用户原始消息（格式化之前）中提取意图的参数，filled 中已有的参数不再提取，asking 为正在追问的参数名称，
+// 返回合并后的参数和第一个缺少的必填参数
+func (t *Expert) extractSlots(intent string, content string, filled map[string]any, asking string) (map[string]any, *compiledSlot) {
+	slots := t.intentSlots(intent)
+	if len(slots) == 0 {
+		return filled, nil
+	}
+	values := make(map[string]any, len(slots))
+	for name, value := range filled {
+		values[name] = value
+	}
+	now := time.Now()
+	var missing *compiledSlot
+	for idx := range slots {
+		slot := &slots[idx]
+		if _, ok := values[slot.Name]; ok {
+			continue
+		}
+		if value, ok := slot.extract(content, slot.Name == asking, now); ok {
+			values[slot.Name] = value
+			continue
+		}
+		if slot.Required && missing == nil {
+			missing = slot
+		}
+	}
+	if len(values) == 0 {
+		values = nil
+	}
+	return values, missing
+}
@@ -0,0 +1,244 @@
+package experts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+func TestExtractDate(t *testing.T) {
+	now := time.Date(2026, 10, 17, 15, 4, 5, 0, time.Local)
+	tests := []struct {
+		content string
+		want    any
+		ok      bool
+	}{
+		{"查看 2026-10-20 的构建", "2026-10-20", true},
+		{"2026/1/5", "2026-01-05", true},
+		{"2026.3.9", "2026-03-09", true},
+		{"2025年12月31日", "2025-12-31", true},
+		{"10月1号的构建", "2026-10-01", true},
+		{"2024年2月29日", "2024-02-29", true},
+		{"2025年2月29日", nil, false}, // 不是闰年
+		{"2月30日", nil, false},
+		{"2026-13-01", nil, false},
+		{"2月30日或者明天", "2026-10-18", true}, // 日期不存在时继续查找相对日期
+		{"今天", "2026-10-17", true},
+		{"明天", "2026-10-18", true},
+		{"后天", "2026-10-19", true},
+		{"大后天", "2026-10-20", true},
+		{"昨天", "2026-10-16", true},
+		{"前天", "2026-10-15", true},
+		{"大前天", "2026-10-14", true},
+		{"查看构建状态", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := extractDate(tt.content, now)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("extractDate(%q) = %v, %v, want %v, %v", tt.content, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompileSlot(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    SlotSpec
+		wantErr bool
+	}{
+		{"url", SlotSpec{Name: "repo", Type: SlotURL}, false},
+		{"tag", SlotSpec{Name: "tag", Type: SlotTag}, false},
+		{"date", SlotSpec{Name: "date", Type: SlotDate}, false},
+		{"text", SlotSpec{Name: "note", Type: SlotText}, false},
+		{"enum", SlotSpec{Name: "env", Type: SlotEnum, Values: []string{"dev", "prod"}}, false},
+		{"regex", SlotSpec{Name: "id", Type: SlotRegex, Pattern: `#(\d+)`}, false},
+		{"url with pattern", SlotSpec{Name: "repo", Type: SlotURL, Pattern: `git@\S+`}, false},
+		{"no name", SlotSpec{Type: SlotURL}, true},
+		{"unknown type", SlotSpec{Name: "x", Type: "number"}, true},
+		{"enum without values", SlotSpec{Name: "env", Type: SlotEnum}, true},
+		{"regex without pattern", SlotSpec{Name: "id", Type: SlotRegex}, true},
+		{"invalid pattern", SlotSpec{Name: "id", Type: SlotRegex, Pattern: `(`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileSlot(tt.spec); (err != nil) != tt.wantErr {
+				t.Errorf("compileSlot() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := compileSlots([]SlotSpec{{Name: "a", Type: SlotText}, {Name: "a", Type: SlotURL}}); err == nil {
+		t.Error("compileSlots() with duplicate names error = nil")
+	}
+}
+
+func TestCompiledSlotExtract(t *testing.T) {
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		spec    SlotSpec
+		content string
+		answer  bool
+		want    any
+		ok      bool
+	}{
+		{"url", SlotSpec{Name: "repo", Type: SlotURL}, "发布仓 https://git.x/a.git，tag 为 v1.0", false, "https://git.x/a.git", true},
+		{"tag", SlotSpec{Name: "tag", Type: SlotTag}, "tag 为 x64-v2.0.1 的构建", false, "x64-v2.0.1", true},
+		{"enum value", SlotSpec{Name: "env", Type: SlotEnum, Values: []string{"dev", "prod"}}, "发布到 PROD", false, "prod", true},
+		{"enum alias", SlotSpec{Name: "env", Type: SlotEnum, Values: []string{"dev", "prod"}, Aliases: map[string]string{"生产": "prod"}}, "生产环境", false, "prod", true},
+		{"enum missing", SlotSpec{Name: "env", Type: SlotEnum, Values: []string{"dev", "prod"}}, "测试环境", false, nil, false},
+		{"regex group", SlotSpec{Name: "id", Type: SlotRegex, Pattern: `#(\d+)`}, "看一下 #42", false, "42", true},
+		{"text not answer", SlotSpec{Name: "note", Type: SlotText}, "备注", false, "备注", false},
+		{"text answer", SlotSpec{Name: "note", Type: SlotText}, " 备注 ", true, "备注", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slot, err := compileSlot(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := slot.extract(tt.content, tt.answer, now)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("extract(%q) = %v, %v, want %v, %v", tt.content, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// sentMessage 专家通过处理函数发出的完整消息
+type sentMessage struct {
+	to      string
+	message TotalMessage
+}
+
+func newSlotTestExpert(t *testing.T, specs []SlotSpec) (*Expert, *[]sentMessage) {
+	t.Helper()
+	sent := make([]sentMessage, 0)
+	expert := NewExpert()
+	status := &keywordIntent{name: "status", keyword: "状态"}
+	expert.Register(func() IntentMatchInter { return status }, status.name)
+	if err := expert.SetIntentSlots(status.name, specs); err != nil {
+		t.Fatal(err)
+	}
+	record := func(to string) func(TotalMessage, string) {
+		return func(message TotalMessage, raw string) {
+			sent = append(sent, sentMessage{to: to, message: message})
+		}
+	}
+	expert.SetToUserMessageHandler(record("user"))
+	expert.SetToProgramMessageHandler(record("program"))
+	expert.SetToChatMessageHandler(record("chat"))
+	dialogx := expert.lockDialog("d1", newTestDialog, true)
+	dialogx.RWMutex.Unlock()
+	return expert, &sent
+}
+
+func TestPendingSlotFlow(t *testing.T) {
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(time.DateOnly)
+	specs := []SlotSpec{
+		{Name: "repo", Type: SlotURL, Required: true, Prompt: "请提供发布仓地址"},
+		{Name: "date", Type: SlotDate},
+		{Name: "env", Type: SlotEnum, Required: true, Values: []string{"dev", "prod"}, Aliases: map[string]string{"生产": "prod"}},
+		{Name: "note", Type: SlotText},
+	}
+
+	// step 用户的一句话和之后专家发出的消息、dialog 的状态
+	type step struct {
+		content     string
+		to          string         // 发出消息的目标
+		reply       string         // 发给用户的追问
+		slots       map[string]any // 发给程序库的参数
+		program     string
+		pendingSlot string // 正在追问的参数
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "ask then answer then forward",
+			steps: []step{
+				{content: "查看状态 明天", to: "user", reply: "请提供发布仓地址", pendingSlot: "repo"},
+				{content: "https://git.x/a.git", to: "user", reply: "请提供env（dev/prod）", pendingSlot: "env"},
+				{content: "生产", to: "program", program: "status",
+					slots: map[string]any{"repo": "https://git.x/a.git", "date": tomorrow, "env": "prod"}},
+			},
+		},
+		{
+			name: "all slots in first message",
+			steps: []step{
+				{content: "查看 https://git.x/a.git 在 dev 的状态", to: "program", program: "status",
+					slots: map[string]any{"repo": "https://git.x/a.git", "env": "dev"}},
+			},
+		},
+		{
+			name: "text slot only filled by answer",
+			steps: []step{
+				{content: "查看状态 https://git.x/a.git", to: "user", reply: "请提供env（dev/prod）", pendingSlot: "env"},
+				// 追问 env 时回答的整句话不会填入 text 类型的 note
+				{content: "dev 环境", to: "program", program: "status",
+					slots: map[string]any{"repo": "https://git.x/a.git", "env": "dev"}},
+			},
+		},
+		{
+			name: "answer without slot is a new message",
+			steps: []step{
+				{content: "查看状态", to: "user", reply: "请提供发布仓地址", pendingSlot: "repo"},
+				{content: "你好", to: "chat"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expert, sent := newSlotTestExpert(t, specs)
+			for idx, st := range tt.steps {
+				before := len(*sent)
+				expert.handleDialogEvent(fromUser, stateMessage(types.EventUserMessage, st.content))
+				if len(*sent) != before+1 {
+					t.Fatalf("step %d sent %d messages, want 1", idx, len(*sent)-before)
+				}
+				got := (*sent)[before]
+				if got.to != st.to {
+					t.Fatalf("step %d sent to %s, want %s", idx, got.to, st.to)
+				}
+				switch st.to {
+				case "user":
+					if got.message.Messages.Content != st.reply {
+						t.Errorf("step %d reply = %q, want %q", idx, got.message.Messages.Content, st.reply)
+					}
+				case "program":
+					// 交给程序库的是分配意图时用户说的第一句话
+					if want := tt.steps[0].content; got.message.Messages.Content != want {
+						t.Errorf("step %d program content = %q, want %q", idx, got.message.Messages.Content, want)
+					}
+					if got.message.Intention != st.program {
+						t.Errorf("step %d intention = %q, want %q", idx, got.message.Intention, st.program)
+					}
+					if !reflect.DeepEqual(got.message.Slots, st.slots) {
+						t.Errorf("step %d slots = %v, want %v", idx, got.message.Slots, st.slots)
+					}
+				}
+
+				dialogx := dialogInMemory(expert, "d1")
+				dialogx.RWMutex.RLock()
+				program, pending := dialogx.Program, dialogx.Pending
+				routed := dialogx.RoutedContent
+				dialogx.RWMutex.RUnlock()
+				if program != st.program {
+					t.Errorf("step %d Program = %q, want %q", idx, program, st.program)
+				}
+				pendingSlot := ""
+				if pending != nil && pending.Kind == types.PendingSlot {
+					pendingSlot = pending.Slot
+				}
+				if pendingSlot != st.pendingSlot {
+					t.Errorf("step %d pending slot = %q, want %q", idx, pendingSlot, st.pendingSlot)
+				}
+				if st.to == "program" && routed != tt.steps[0].content {
+					t.Errorf("step %d RoutedContent = %q, want %q", idx, routed, tt.steps[0].content)
+				}
+			}
+		})
+	}
+}
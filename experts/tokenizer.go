@@ -0,0 +1,54 @@
+package experts
+
+import (
+	"os"
+	"sync"
+
+	"github.com/yanyiwu/gojieba"
+)
+
+var (
+	jiebaOnce     sync.Once
+	jiebaInstance *gojieba.Jieba // 所有 rnn 意图和训练数据导出共享的 jieba，进程退出前不释放
+	jiebaUserDict string
+)
+
+// SetJiebaUserDict 设置 jieba 自定义词典路径，需要在第一次分词（加载 rnn 模型）之前调用
+func SetJiebaUserDict(path string) {
+	jiebaUserDict = path
+}
+
+// sharedJieba 返回共享的 jieba 实例，第一次调用时加载词典
+func sharedJieba() *gojieba.Jieba {
+	jiebaOnce.Do(func() {
+		userDict := jiebaUserDict
+		if userDict != "" {
+			if _, err := os.Stat(userDict); err != nil {
+				logger.Errorf("Jieba user dict '%s' not available, using default dict: %v", userDict, err)
+				userDict = ""
+			}
+		}
+		// 空字符串使用 gojieba 自带的词典
+		jiebaInstance = gojieba.NewJieba("", "", userDict)
+		logger.Info("Jieba tokenizer initialized, user dict:", userDict)
+	})
+	return jiebaInstance
+}
+
+// Tokenize 使用共享的 jieba 精确模式（HMM）分词，rnn 意图识别和训练数据导出都使用这种分词方式
+func Tokenize(text string) []string {
+	return sharedJieba().Cut(text, true)
+}
+
+// TokensToIndices 根据词汇表将分词结果转换为索引，不在词表中的词使用 <UNK>
+func TokensToIndices(tokens []string, vocab map[string]int64) []int64 {
+	indices := make([]int64, 0, len(tokens))
+	for _, token := range tokens {
+		if index, found := vocab[token]; found {
+			indices = append(indices, index)
+		} else {
+			indices = append(indices, vocab["<UNK>"])
+		}
+	}
+	return indices
+}
@@ -0,0 +1,386 @@
+package experts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+const (
+	SampleSourceOutcome     = "outcome"      // 程序库结束（2002）为正样本，不支持（2003）为负样本
+	SampleSourceCache       = "cache"        // 意图缓存中的条目，作为正样本
+	SampleSourceOtherIntent = "other_intent" // 其他意图的正样本，作为负样本
+	SampleSourceHistory     = "history"      // 历史记录中交给多轮对话回复的用户消息，作为所有意图的负样本
+
+	trainingSamplesFile = "trainingSamples.jsonl"
+)
+
+// TrainingSample 一条带标签的训练数据
+type TrainingSample struct {
+	Text     string    `json:"text"`
+	Intent   string    `json:"intent"`
+	Label    int       `json:"label"` // 1 正样本，0 负样本
+	Source   string    `json:"source"`
+	DialogID string    `json:"dialog_id,omitempty"`
+	Time     time.Time `json:"time,omitzero"`
+}
+
+// trainingRecorder 把程序库处理结果追加写入 jsonl 文件
+type trainingRecorder struct {
+	mu       sync.Mutex
+	enabled  bool
+	filePath string
+}
+
+func (r *trainingRecorder) record(sample TrainingSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.enabled || r.filePath == "" || strings.TrimSpace(sample.Text) == "" {
+		return
+	}
+	data, err := json.Marshal(sample)
+	if err != nil {
+		logger.Errorf("Failed to marshal training sample: %v", err)
+		return
+	}
+	file, err := os.OpenFile(r.filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Errorf("Failed to open training sample file: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		logger.Errorf("Failed to write training sample: %v", err)
+	}
+}
+
+// recordOutcome 程序库结束或不支持时，把分配该程序库时用户说的话记录为对应意图的正样本或负样本
+func (t *Expert) recordOutcome(dialogx *DialogInfo, label int) {
+	if dialogx.Program == "" || dialogx.RoutedContent == "" {
+		return
+	}
+	t.trainingRecorder.record(TrainingSample{
+		Text:     dialogx.RoutedContent,
+		Intent:   dialogx.Program,
+		Label:    label,
+		Source:   SampleSourceOutcome,
+		DialogID: dialogx.DialogID,
+		Time:     time.Now(),
+	})
+	dialogx.RoutedContent = ""
+}
+
+// TrainingExportReport 导出结果，每个意图的正负样本数量
+type TrainingExportReport struct {
+	Positive map[string]int `json:"positive"`
+	Negative map[string]int `json:"negative"`
+}
+
+// exportedSample 导出到 jsonl 中的一行，tokens 使用 Tokenize 分词，indices 对应同目录下的 vocab_rnn.json
+type exportedSample struct {
+	Text    string   `json:"text"`
+	Tokens  []string `json:"tokens"`
+	Indices []int64  `json:"indices"`
+	Label   int      `json:"label"`
+	Source  string   `json:"source"`
+}
+
+// sourcePriority 同一句话在同一个意图下有多个来源时，优先使用优先级高的标签
+var sourcePriority = map[string]int{
+	SampleSourceOutcome:     3,
+	SampleSourceCache:       2,
+	SampleSourceOtherIntent: 1,
+	SampleSourceHistory:     0,
+}
+
+// ExportTrainingData 从专家的数据目录读取程序库处理结果、意图缓存和 dialog 历史记录，
+// 为每个意图在 outDir/<意图名称>/ 下生成 positive.jsonl、negative.jsonl 和 vocab_rnn.json，
+// 分词方式（包括 SetJiebaUserDict 设置的自定义词典）和词表格式与 rnn 意图识别一致（<PAD> 为 0，<UNK> 为 1），可直接用于训练 model_rnn.onnx。
+// 样本文本和意图识别分词的文本相同，即经过 SetMessageFormatFunc 处理的用户输入：程序库处理结果和意图缓存记录的就是处理后的文本，
+// 历史记录保存的是原文，这里没有格式化函数，设置了 SetMessageFormatFunc 时用 Expert.ExportTrainingData 导出。
+// dialog 历史记录从数据目录的 dialogs 文件存储和旧版 dailoginfo.json 中读取，使用其他 dialog 存储时用 Expert.ExportTrainingData。
+func ExportTrainingData(dataDir string, outDir string) (*TrainingExportReport, error) {
+	return exportTrainingData(dataDir, outDir, NewFileDialogStore(filepath.Join(dataDir, "dialogs")), nil)
+}
+
+// exportTrainingData format 为消息格式化函数，用于处理历史记录中的原文，为空时使用原文
+func exportTrainingData(dataDir string, outDir string, store DialogStore, format func(string) string) (*TrainingExportReport, error) {
+	samples, err := readTrainingSamples(filepath.Join(dataDir, trainingSamplesFile))
+	if err != nil {
+		return nil, err
+	}
+	cacheSamples, err := readCacheSamples(filepath.Join(dataDir, "intentMatchCache.json"))
+	if err != nil {
+		return nil, err
+	}
+	samples = append(samples, cacheSamples...)
+	historyTexts, err := readHistoryNegatives(filepath.Join(dataDir, "dailoginfo.json"), store, format)
+	if err != nil {
+		return nil, err
+	}
+
+	// 意图名称 -> 归一化文本 -> 样本
+	datasets := make(map[string]map[string]TrainingSample)
+	put := func(sample TrainingSample) {
+		key := NormalizeContent(sample.Text)
+		if key == "" || sample.Intent == "" {
+			return
+		}
+		dataset := datasets[sample.Intent]
+		if dataset == nil {
+			dataset = make(map[string]TrainingSample)
+			datasets[sample.Intent] = dataset
+		}
+		if existing, ok := dataset[key]; ok && sourcePriority[existing.Source] > sourcePriority[sample.Source] {
+			return
+		}
+		dataset[key] = sample
+	}
+	for _, sample := range samples {
+		put(sample)
+	}
+
+	intents := make([]string, 0, len(datasets))
+	for intent := range datasets {
+		intents = append(intents, intent)
+	}
+	sort.Strings(intents)
+	// 其他意图的正样本和多轮对话回复的消息作为负样本
+	for _, intent := range intents {
+		for _, other := range intents {
+			if other == intent {
+				continue
+			}
+			for _, sample := range datasets[other] {
+				if sample.Label == 1 && sample.Source != SampleSourceOtherIntent {
+					put(TrainingSample{Text: sample.Text, Intent: intent, Label: 0, Source: SampleSourceOtherIntent})
+				}
+			}
+		}
+		for _, text := range historyTexts {
+			put(TrainingSample{Text: text, Intent: intent, Label: 0, Source: SampleSourceHistory})
+		}
+	}
+
+	report := &TrainingExportReport{Positive: make(map[string]int), Negative: make(map[string]int)}
+	for _, intent := range intents {
+		positive, negative, err := writeIntentDataset(filepath.Join(outDir, intent), datasets[intent])
+		if err != nil {
+			return report, fmt.Errorf("failed to export intent '%s': %w", intent, err)
+		}
+		report.Positive[intent] = positive
+		report.Negative[intent] = negative
+		logger.Infof("Exported training data for intent %s: %d positive, %d negative.", intent, positive, negative)
+	}
+	return report, nil
+}
+
+// ExportTrainingData 先保存 dialog 信息和意图缓存，再从数据目录和 dialog 存储导出训练数据，见 ExportTrainingData
+func (t *Expert) ExportTrainingData(outDir string) (*TrainingExportReport, error) {
+	if t.dataFilePath == "" {
+		return nil, fmt.Errorf("data file path is not set")
+	}
+	t.saveDialogs()
+	t.intentMatch.SaveIntentCache()
+	t.trainingRecorder.mu.Lock()
+	defer t.trainingRecorder.mu.Unlock()
+	store := t.dialogStore
+	if store == nil {
+		store = NewFileDialogStore(t.defaultDialogStorePath())
+	}
+	return exportTrainingData(t.dataFilePath, outDir, store, t.intentMatch.format)
+}
+
+func writeIntentDataset(dir string, dataset map[string]TrainingSample) (int, int, error) {
+	keys := make([]string, 0, len(dataset))
+	for key := range dataset {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// 按词频从高到低生成词表，词频相同时按字典序
+	tokenized := make(map[string][]string, len(keys))
+	frequency := make(map[string]int)
+	for _, key := range keys {
+		tokens := Tokenize(dataset[key].Text)
+		for _, token := range tokens {
+			frequency[token]++
+		}
+		tokenized[key] = tokens
+	}
+	words := make([]string, 0, len(frequency))
+	for word := range frequency {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if frequency[words[i]] != frequency[words[j]] {
+			return frequency[words[i]] > frequency[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	vocab := map[string]int64{"<PAD>": 0, "<UNK>": 1}
+	for _, word := range words {
+		if _, exists := vocab[word]; !exists {
+			vocab[word] = int64(len(vocab))
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, 0, err
+	}
+	vocabFile, err := os.Create(filepath.Join(dir, "vocab_rnn.json"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer vocabFile.Close()
+	vocabEncoder := json.NewEncoder(vocabFile)
+	vocabEncoder.SetEscapeHTML(false) // 保持 <PAD> <UNK> 原样
+	vocabEncoder.SetIndent("", "  ")
+	if err := vocabEncoder.Encode(vocab); err != nil {
+		return 0, 0, err
+	}
+
+	positiveFile, err := os.Create(filepath.Join(dir, "positive.jsonl"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer positiveFile.Close()
+	negativeFile, err := os.Create(filepath.Join(dir, "negative.jsonl"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer negativeFile.Close()
+
+	positiveEncoder := json.NewEncoder(positiveFile)
+	negativeEncoder := json.NewEncoder(negativeFile)
+	positive, negative := 0, 0
+	for _, key := range keys {
+		sample := dataset[key]
+		tokens := tokenized[key]
+		indices := make([]int64, 0, len(tokens))
+		for _, token := range tokens {
+			indices = append(indices, vocab[token])
+		}
+		line := exportedSample{
+			Text:    sample.Text,
+			Tokens:  tokens,
+			Indices: indices,
+			Label:   sample.Label,
+			Source:  sample.Source,
+		}
+		if sample.Label == 1 {
+			err = positiveEncoder.Encode(line)
+			positive++
+		} else {
+			err = negativeEncoder.Encode(line)
+			negative++
+		}
+		if err != nil {
+			return positive, negative, err
+		}
+	}
+	return positive, negative, nil
+}
+
+// readTrainingSamples 读取记录的程序库处理结果，同一句话在同一个意图下以最后一次结果为准
+func readTrainingSamples(path string) ([]TrainingSample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	samples := make([]TrainingSample, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var sample TrainingSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			logger.Warnf("Skipping invalid training sample: %v", err)
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// readCacheSamples 意图缓存中未过期的条目作为正样本，旧版本的条目没有记录送入意图匹配器的文本，使用归一化后的内容
+func readCacheSamples(path string) ([]TrainingSample, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cache := NewIntentCache(0, 0)
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, err
+	}
+	entries := cache.List()
+	samples := make([]TrainingSample, 0, len(entries))
+	for _, entry := range entries {
+		text := entry.Text
+		if text == "" {
+			text = entry.Content
+		}
+		samples = append(samples, TrainingSample{
+			Text:   text,
+			Intent: entry.Intent,
+			Label:  1,
+			Source: SampleSourceCache,
+			Time:   entry.CreatedAt,
+		})
+	}
+	return samples, nil
+}
+
+// readHistoryNegatives 历史记录中紧接着由多轮对话回复的用户消息，说明没有程序库能处理。
+// 读取旧版 dailoginfo.json 和 dialog 存储中的所有 dialog，同一个 dialog 以存储中的为准，format 不为空时用它处理原文
+func readHistoryNegatives(legacyPath string, store DialogStore, format func(string) string) ([]string, error) {
+	dialogs, err := readLegacyDialogs(legacyPath)
+	if err != nil {
+		return nil, err
+	}
+	if dialogs == nil {
+		dialogs = make(map[string]*DialogInfo)
+	}
+	ctx := context.Background()
+	ids, err := store.IDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		dialogx, err := store.Get(ctx, id)
+		if err != nil {
+			continue // 读取 id 之后过期或被删除
+		}
+		dialogs[id] = dialogx
+	}
+	texts := make([]string, 0)
+	for _, dialogx := range dialogs {
+		history := dialogx.ChatHistory
+		for i := 0; i+1 < len(history); i++ {
+			if history[i].Source == types.HistorySourceUser && history[i+1].Source == types.HistorySourceChat {
+				text := history[i].Content
+				if format != nil {
+					text = format(text)
+				}
+				texts = append(texts, text)
+			}
+		}
+	}
+	return texts, nil
+}
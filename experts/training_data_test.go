@@ -0,0 +1,105 @@
+package experts
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/huihui4754/expertlib/types"
+)
+
+func readExportedTexts(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	texts := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line exportedSample
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		texts = append(texts, line.Text)
+	}
+	sort.Strings(texts)
+	return texts
+}
+
+// 三种来源的样本都使用经过消息格式化函数处理后的文本，和意图识别分词的文本相同
+func TestExportTrainingDataFormattedText(t *testing.T) {
+	dataDir := t.TempDir()
+	var sent []outbound
+	expert := newStateTestExpert(&sent)
+	expert.SetDataFilePath(dataDir)
+	expert.SetDialogStore(NewFileDialogStore(filepath.Join(dataDir, "dialogs")))
+	expert.SetRecordTrainingSamples(true)
+	expert.trainingRecorder.filePath = filepath.Join(dataDir, trainingSamplesFile)
+	urlPattern := regexp.MustCompile(`https?://\S+`)
+	expert.SetMessageFormatFunc(func(content string) string {
+		return strings.TrimSpace(urlPattern.ReplaceAllString(content, ""))
+	})
+
+	// 匹配到 status 后程序库结束，记录为正样本，同时写入意图缓存
+	newDialog := func(id string) func() *DialogInfo {
+		return func() *DialogInfo {
+			return &DialogInfo{DialogID: id, UserID: "user-1", ChatHistory: make([]types.HistoryEntry, 0)}
+		}
+	}
+	dialogx := expert.lockDialog("d1", newDialog("d1"), true)
+	dialogx.RWMutex.Unlock()
+	expert.handleDialogEvent(fromUser, stateMessage(types.EventUserMessage, "查看 CI 状态！ https://git.x/a.git"))
+	expert.handleDialogEvent(fromProgram, stateMessage(types.EventToolFinish, "构建成功"))
+
+	// 交给多轮对话回复的消息作为负样本
+	dialogx = expert.lockDialog("d2", newDialog("d2"), true)
+	dialogx.RWMutex.Unlock()
+	chatMessage := stateMessage(types.EventUserMessage, "你好，在吗？ https://git.x/b.git")
+	chatMessage.DialogID = "d2"
+	expert.handleDialogEvent(fromUser, chatMessage)
+	chatReply := stateMessage(types.EventServerMessage, "在的")
+	chatReply.DialogID = "d2"
+	expert.handleDialogEvent(fromChat, chatReply)
+
+	entries := expert.ListIntentCache()
+	if len(entries) != 1 || entries[0].Text != "查看 CI 状态！" {
+		t.Fatalf("intent cache = %+v, want text 查看 CI 状态！", entries)
+	}
+
+	outDir := t.TempDir()
+	report, err := expert.ExportTrainingData(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Positive["status"] != 1 || report.Negative["status"] != 1 {
+		t.Errorf("report = %+v, want 1 positive and 1 negative for status", report)
+	}
+	if got := readExportedTexts(t, filepath.Join(outDir, "status", "positive.jsonl")); len(got) != 1 || got[0] != "查看 CI 状态！" {
+		t.Errorf("positive texts = %q, want formatted text", got)
+	}
+	if got := readExportedTexts(t, filepath.Join(outDir, "status", "negative.jsonl")); len(got) != 1 || got[0] != "你好，在吗？" {
+		t.Errorf("negative texts = %q, want formatted text", got)
+	}
+}
+
+func TestReadCacheSamplesLegacyEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "intentMatchCache.json")
+	if err := os.WriteFile(path, []byte(`{"查看 CI 状态！": "status"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	samples, err := readCacheSamples(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 旧版本的条目没有记录原文，只能使用归一化后的内容
+	if len(samples) != 1 || samples[0].Text != "查看ci状态" {
+		t.Errorf("samples = %+v, want normalized content", samples)
+	}
+}
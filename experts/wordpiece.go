@@ -0,0 +1,131 @@
+package experts
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const wordPieceMaxWordChars = 100 // 超过该长度的词直接作为 [UNK]
+
+// WordPieceTokenizer bert 类句向量模型使用的分词器，读取模型目录中的 vocab.txt（每行一个词，行号为索引）
+type WordPieceTokenizer struct {
+	vocab     map[string]int64
+	lowerCase bool
+	unkToken  string
+	clsToken  string
+	sepToken  string
+	maxLength int // 包括 [CLS] 和 [SEP]
+}
+
+func NewWordPieceTokenizer(vocabPath string, lowerCase bool, maxLength int) (*WordPieceTokenizer, error) {
+	file, err := os.Open(vocabPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vocab file '%s': %w", vocabPath, err)
+	}
+	defer file.Close()
+
+	vocab := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	var idx int64
+	for scanner.Scan() {
+		token := strings.TrimRight(scanner.Text(), "\r")
+		if _, exists := vocab[token]; !exists {
+			vocab[token] = idx
+		}
+		idx++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vocab file '%s': %w", vocabPath, err)
+	}
+
+	tokenizer := &WordPieceTokenizer{
+		vocab:     vocab,
+		lowerCase: lowerCase,
+		unkToken:  "[UNK]",
+		clsToken:  "[CLS]",
+		sepToken:  "[SEP]",
+		maxLength: maxLength,
+	}
+	for _, special := range []string{tokenizer.unkToken, tokenizer.clsToken, tokenizer.sepToken} {
+		if _, ok := vocab[special]; !ok {
+			return nil, fmt.Errorf("vocab file '%s' has no %s token", vocabPath, special)
+		}
+	}
+	return tokenizer, nil
+}
+
+// Encode 返回 [CLS] 文本 [SEP] 的索引，超过最大长度时截断文本部分
+func (w *WordPieceTokenizer) Encode(text string) []int64 {
+	ids := []int64{w.vocab[w.clsToken]}
+	for _, word := range w.basicTokenize(text) {
+		for _, piece := range w.wordPiece(word) {
+			ids = append(ids, w.vocab[piece])
+		}
+	}
+	if w.maxLength > 2 && len(ids) > w.maxLength-1 {
+		ids = ids[:w.maxLength-1]
+	}
+	return append(ids, w.vocab[w.sepToken])
+}
+
+// basicTokenize 按空白和标点切分，每个中文字符单独成词
+func (w *WordPieceTokenizer) basicTokenize(text string) []string {
+	if w.lowerCase {
+		text = strings.ToLower(text)
+	}
+	words := make([]string, 0)
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			words = append(words, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case r == 0 || r == unicode.ReplacementChar || unicode.IsControl(r) && !unicode.IsSpace(r):
+			continue
+		case unicode.IsSpace(r):
+			flush()
+		case unicode.Is(unicode.Han, r) || unicode.IsPunct(r) || unicode.IsSymbol(r):
+			flush()
+			words = append(words, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+// wordPiece 从左到右贪心匹配词表中最长的子词，后续子词带 ## 前缀，无法切分时整个词作为 [UNK]
+func (w *WordPieceTokenizer) wordPiece(word string) []string {
+	runes := []rune(word)
+	if len(runes) > wordPieceMaxWordChars {
+		return []string{w.unkToken}
+	}
+	pieces := make([]string, 0, 1)
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		matched := ""
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start > 0 {
+				piece = "##" + piece
+			}
+			if _, ok := w.vocab[piece]; ok {
+				matched = piece
+				break
+			}
+		}
+		if matched == "" {
+			return []string{w.unkToken}
+		}
+		pieces = append(pieces, matched)
+		start = end
+	}
+	return pieces
+}
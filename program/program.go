@@ -1,11 +1,14 @@
 package programs
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os/user"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/huihui4754/expertlib/types"
@@ -23,7 +26,8 @@ func SetLogger(level loglevel.Level) {
 	logger.SetLevel(level)
 }
 
-type program struct {
+// Program 程序库实例，负责把专家分配的意图交给对应的 nodejs 程序处理
+type Program struct {
 	dataFilePath           string
 	programPath            string
 	expertMessageHandler   func(TotalMessage, string)
@@ -33,13 +37,18 @@ type program struct {
 	dataStorage            *StorageManager
 	saveInterval           time.Duration
 	port                   string
+	stopChan               chan struct{}   // 关闭信号，Shutdown 时关闭
+	stopOnce               *sync.Once      // 保证关闭信号只发送一次
+	runDone                chan struct{}   // Run 退出后关闭
+	running                atomic.Bool     // Run 是否已经启动
+	handlerWG              *sync.WaitGroup // 正在执行的消息处理协程
 }
 
-func NewTool() *program {
+func NewTool() *Program {
 	defalutProgramPath := ""
 	defalutDataPath := ""
 	defalutPort := "8765"
-	currentUser, err := user.Current() // todo 后续支持从配置文件读取配置
+	currentUser, err := user.Current() // 从配置文件读取配置见 config 包
 	if err != nil {
 		fmt.Printf("获取用户信息失败：%v\n", err)
 	} else {
@@ -52,39 +61,58 @@ func NewTool() *program {
 	dataStorage.SaveInterval = defalutSaveInterval
 
 	toExpertChan := make(chan *TotalMessage)
+	// 停止后 Run 仍会转发处理中的消息，所以会话在 Run 退出（runDone）后才放弃发送，而不是 stopChan 关闭时
+	runDone := make(chan struct{})
 
-	return &program{
+	return &Program{
 		dataFilePath:           defalutDataPath,
 		programPath:            defalutProgramPath,
 		expertMessageInChan:    make(chan *TotalMessage),
 		toExpertMessageOutChan: toExpertChan,
-		sessionManager:         NewSessionManager(toExpertChan),
+		sessionManager:         NewSessionManager(toExpertChan, runDone),
 		dataStorage:            dataStorage,
 		saveInterval:           defalutSaveInterval,
 		port:                   defalutPort,
+		stopChan:               make(chan struct{}),
+		stopOnce:               &sync.Once{},
+		runDone:                runDone,
+		handlerWG:              &sync.WaitGroup{},
 	}
 }
 
-func (p *program) SetDataFilePath(path string) {
+func (p *Program) SetDataFilePath(path string) {
 	p.dataFilePath = path
 	p.dataStorage.DataDirPath = path
 	logger.Info("Data file path set to:", path)
 	// Update storage manager with new path if it's already initialized
 }
 
-func (p *program) SetSaveIntervalTime(interval time.Duration) {
+func (p *Program) SetSaveIntervalTime(interval time.Duration) {
 	p.saveInterval = interval
 	p.dataStorage.SaveInterval = interval
 	logger.Info("Save interval time set to:", interval)
 }
 
-func (p *program) SetProgramPath(path string) {
+func (p *Program) SetProgramPath(path string) {
 	p.programPath = path
 	p.sessionManager.ProgramBasePath = path
 	logger.Info("Program path set to:", path)
 }
 
-func (p *program) HandleExpertRequestMessage(message any) {
+// SetStoragePort 设置给 nodejs 程序提供数据存储的 http 端口，需要在 RunStroageUserData 前设置
+func (p *Program) SetStoragePort(port string) {
+	p.port = port
+	p.dataStorage.Port = port
+	logger.Info("Storage port set to:", port)
+}
+
+// SetIdleTimeout 设置 nodejs 程序无消息往来多久后被关闭
+func (p *Program) SetIdleTimeout(timeout time.Duration) {
+	p.sessionManager.IdleTimeout = timeout
+	logger.Info("Idle timeout set to:", timeout)
+}
+
+func (p *Program) HandleExpertRequestMessage(message any) {
 	logger.Debugf("Handling Expert request message: %v", message)
 	var messagePointer *TotalMessage
 	var err error
@@ -122,16 +150,20 @@ func (p *program) HandleExpertRequestMessage(message any) {
 		logger.Error("不支持的消息结构")
 	}
 	if p.expertMessageInChan != nil && messagePointer != nil && err == nil {
-		p.expertMessageInChan <- messagePointer
+		select {
+		case <-p.stopChan:
+			logger.Warnf("Program 已关闭，丢弃消息 dialog: %s", messagePointer.DialogID)
+		case p.expertMessageInChan <- messagePointer:
+		}
 	}
 }
 
-func (p *program) SetToExpertMessageHandler(handler func(TotalMessage, string)) {
+func (p *Program) SetToExpertMessageHandler(handler func(TotalMessage, string)) {
 	p.expertMessageHandler = handler
 	logger.Info("ExpertMessageHandler set")
 }
 
-func (p *program) handleFromExpertMessage(message *TotalMessage) {
+func (p *Program) handleFromExpertMessage(message *TotalMessage) {
 
 	switch message.EventType {
 	case types.EventUserMessage: // 1001
@@ -163,62 +195,123 @@ func (p *program) handleFromExpertMessage(message *TotalMessage) {
 
 }
 
-func (p *program) sendToolNotFound(originalMsg *TotalMessage) {
+func (p *Program) sendToolNotFound(originalMsg *TotalMessage) {
 	// Create a EventToolNotFound message and send it back
 	notSupportMsg := &TotalMessage{
 		EventType: types.EventToolNotFound,
 		DialogID:  originalMsg.DialogID,
 		UserId:    originalMsg.UserId,
 	}
-	p.toExpertMessageOutChan <- notSupportMsg
+	p.sessionManager.sendToExpert(notSupportMsg)
 }
 
-func (p *program) sendProgramEnd(originalMsg *TotalMessage) {
+func (p *Program) sendProgramEnd(originalMsg *TotalMessage) {
 	// Create a ToolNotSupport message and send it back
 	endMsg := &TotalMessage{
 		EventType: types.EventToolFinish,
 		DialogID:  originalMsg.DialogID,
 		UserId:    originalMsg.UserId,
 	}
-	p.toExpertMessageOutChan <- endMsg
+	p.sessionManager.sendToExpert(endMsg)
 }
 
-func (p *program) Run() {
+// Run 前台占用启动程序库实例，调用 Shutdown 后等待正在处理的消息完成再返回
+func (p *Program) Run() {
+	select {
+	case <-p.stopChan:
+		logger.Warn("Program has been shut down, Run ignored.")
+		return
+	default:
+	}
+	p.running.Store(true)
+	defer close(p.runDone)
 
 	logger.Info("Program instance running")
 
 	for {
 		select {
 		case expertMsg := <-p.expertMessageInChan:
-			go p.handleFromExpertMessage(expertMsg)
+			p.handlerWG.Add(1)
+			go func() {
+				defer p.handlerWG.Done()
+				p.handleFromExpertMessage(expertMsg)
+			}()
 		case toExpertMsg := <-p.toExpertMessageOutChan:
+			p.handlerWG.Add(1)
 			go func() {
-				// The message from session manager is already a complete TotalMessage
-				// We just need to marshal it for the handler
-				msgBytes, err := json.Marshal(toExpertMsg)
-				if err != nil {
-					logger.Errorf("Failed to marshal outgoing message: %v", err)
+				defer p.handlerWG.Done()
+				p.forwardToExpert(toExpertMsg)
+			}()
+		case <-p.stopChan:
+			// 等待正在处理的消息结束，期间继续转发程序返回的消息
+			handlersDone := make(chan struct{})
+			go func() {
+				p.handlerWG.Wait()
+				close(handlersDone)
+			}()
+			for {
+				select {
+				case toExpertMsg := <-p.toExpertMessageOutChan:
+					p.forwardToExpert(toExpertMsg)
+				case <-handlersDone:
+					logger.Info("Program instance stopped")
 					return
 				}
-				if p.expertMessageHandler != nil {
-					p.expertMessageHandler(*toExpertMsg, string(msgBytes))
-				}
-			}()
+			}
+		}
+	}
+
+}
+
+func (p *Program) forwardToExpert(toExpertMsg *TotalMessage) {
+	// The message from session manager is already a complete TotalMessage
+	// We just need to marshal it for the handler
+	msgBytes, err := json.Marshal(toExpertMsg)
+	if err != nil {
+		logger.Errorf("Failed to marshal outgoing message: %v", err)
+		return
+	}
+	if p.expertMessageHandler != nil {
+		p.expertMessageHandler(*toExpertMsg, string(msgBytes))
+	}
+}
+
+// Shutdown 停止接收新消息，等待正在处理的消息完成后结束所有 nodejs 进程，并将程序数据写入磁盘。
+// ctx 超时后仍会结束进程并保存数据，并返回 ctx 的错误。
+func (p *Program) Shutdown(ctx context.Context) error {
+	logger.Info("Program instance shutting down")
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+
+	var waitErr error
+	if p.running.Load() {
+		select {
+		case <-p.runDone:
+		case <-ctx.Done():
+			waitErr = ctx.Err()
+			logger.Warnf("Program shutdown wait interrupted: %v, cleaning up anyway.", waitErr)
 		}
 	}
 
+	p.sessionManager.CloseAllSessions(types.EventToolFinish)
+	if err := p.dataStorage.Shutdown(ctx); err != nil && waitErr == nil {
+		waitErr = err
+	}
+	logger.Info("Program instance shut down")
+	return waitErr
 }
 
-func (p *program) GetProgramNames() []string {
+func (p *Program) GetProgramNames() []string {
 	logger.Debug("Getting all program names")
 	// Placeholder for actual logic
 	return p.sessionManager.GetAllProgramName()
 }
 
-func (p *program) GetStroageHandler() func(w http.ResponseWriter, r *http.Request) {
+func (p *Program) GetStroageHandler() func(w http.ResponseWriter, r *http.Request) {
 	return p.dataStorage.GetStroageHandler()
 }
 
-func (p *program) RunStroageUserData() {
+func (p *Program) RunStroageUserData() {
 	go p.dataStorage.RunHTTPServer() // Start the HTTP server for storage
 }
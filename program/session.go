@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -26,6 +27,7 @@ const (
 type Session struct {
 	DialogID          string
 	UserID            string
+	Intent            string // 程序库名称
 	Cmd               *exec.Cmd
 	NodeJSProgramPath string
 	SocketPath        string
@@ -37,19 +39,27 @@ type Session struct {
 	listener          net.Listener
 	conn              net.Conn
 	connMu            sync.Mutex
+	background        bool // 程序发送 2005 交还前台后在后台运行，不再接收用户消息
 }
 
 type SessionManager struct {
-	sessions               map[string]*Session
+	sessions               map[string]*Session   // 前台会话，dialog_id 到会话的映射
+	backgrounds            map[*Session]struct{} // 交还前台后仍在运行的会话，一个 dialog 可以有多个
 	mu                     sync.RWMutex
 	toExpertMessageOutChan chan *types.TotalMessage
+	outDone                <-chan struct{} // 不再有人接收 toExpertMessageOutChan 时关闭，之后发送的消息丢弃
 	ProgramBasePath        string
+	IdleTimeout            time.Duration // nodejs 程序无消息往来多久后关闭
 }
 
-func NewSessionManager(toExpertMessageOutChan chan *types.TotalMessage) *SessionManager {
+// NewSessionManager outDone 关闭后不再向 toExpertMessageOutChan 发送消息，避免会话协程在没有接收方时一直阻塞
+func NewSessionManager(toExpertMessageOutChan chan *types.TotalMessage, outDone <-chan struct{}) *SessionManager {
 	return &SessionManager{
 		sessions:               make(map[string]*Session),
+		backgrounds:            make(map[*Session]struct{}),
 		toExpertMessageOutChan: toExpertMessageOutChan,
+		outDone:                outDone,
+		IdleTimeout:            IdleTimeout,
 	}
 }
 
@@ -64,7 +74,8 @@ func (m *SessionManager) GetOrCreateSession(dialogID string, userID string, inte
 	}
 
 	logger.Infof("Creating new session for dialog_id: %s", dialogID)
-	socketPath := filepath.Join(SocketDir, fmt.Sprintf("%s.sock", dialogID))
+	// 同一个 dialog 可能还有后台会话，套接字文件名加上时间避免冲突
+	socketPath := filepath.Join(SocketDir, fmt.Sprintf("%s-%s.sock", dialogID, strconv.FormatInt(time.Now().UnixNano(), 36)))
 	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
 		logger.Warnf("Could not remove old socket file %s: %v", socketPath, err)
 	}
@@ -78,6 +89,7 @@ func (m *SessionManager) GetOrCreateSession(dialogID string, userID string, inte
 	session := &Session{
 		DialogID:          dialogID,
 		UserID:            userID,
+		Intent:            intent,
 		NodeJSProgramPath: NodeJSProgramPath,
 		SocketPath:        socketPath,
 		LastAccess:        time.Now(),
@@ -96,22 +108,67 @@ func (m *SessionManager) GetOrCreateSession(dialogID string, userID string, inte
 	return session, nil
 }
 
+// CloseSession 结束 dialog 的前台会话，后台会话不受影响
 func (m *SessionManager) CloseSession(dialogID string, reason int) {
+	m.mu.RLock()
+	session, exists := m.sessions[dialogID]
+	m.mu.RUnlock()
+	if !exists {
+		return
+	}
+	m.closeSession(session, reason)
+}
 
+// closeSession 结束会话及其 nodejs 进程，前台和后台会话都可以，重复调用时只结束一次
+func (m *SessionManager) closeSession(session *Session, reason int) {
 	m.mu.Lock()
-	session, exists := m.sessions[dialogID]
+	_, background := m.backgrounds[session]
+	foreground := m.sessions[session.DialogID] == session
+	if background {
+		delete(m.backgrounds, session)
+	}
+	if foreground {
+		delete(m.sessions, session.DialogID)
+	}
 	m.mu.Unlock()
-	if !exists {
+	if !background && !foreground {
 		return
 	}
-	logger.Infof("Closing session for dialog_id: %s with reason: %d", dialogID, reason)
+	logger.Infof("Closing session for dialog_id: %s (%s) with reason: %d, background: %v", session.DialogID, session.Intent, reason, background)
 	session.close()
+	logger.Infof("Session %s closed.", session.DialogID)
+}
 
+// releaseForeground 程序交还前台后把会话移到后台，dialog 的下一条用户消息会新建前台会话
+func (m *SessionManager) releaseForeground(session *Session) {
 	m.mu.Lock()
-	delete(m.sessions, dialogID)
-	m.mu.Unlock()
+	defer m.mu.Unlock()
+	if m.sessions[session.DialogID] != session {
+		return
+	}
+	delete(m.sessions, session.DialogID)
+	m.backgrounds[session] = struct{}{}
+	session.mu.Lock()
+	session.background = true
+	session.mu.Unlock()
+	logger.Infof("Session for dialog_id %s (%s) released foreground, running in background.", session.DialogID, session.Intent)
+}
 
-	logger.Infof("Session %s closed.", dialogID)
+// CloseAllSessions 结束所有存活的前台和后台会话及其 nodejs 进程
+func (m *SessionManager) CloseAllSessions(reason int) {
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.sessions)+len(m.backgrounds))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	for session := range m.backgrounds {
+		sessions = append(sessions, session)
+	}
+	m.mu.RUnlock()
+
+	for _, session := range sessions {
+		m.closeSession(session, reason)
+	}
 }
 
 func (m *SessionManager) GetAllProgramName() []string {
@@ -218,20 +275,49 @@ func (s *Session) handleConnection(conn net.Conn) {
 
 		logger.Debugf("Received message from tool for dialog %s, event: %d", s.DialogID, totalMsg.EventType)
 
+		if s.isBackground() {
+			// 后台会话的消息标记出来，专家只转发给用户，不改变 dialog 的路由
+			totalMsg.Background = true
+			totalMsg.Intention = s.Intent
+			switch totalMsg.EventType {
+			case types.EventServerMessage:
+				s.manager.sendToExpert(&totalMsg)
+			case types.EventToolFinish, types.EventToolNotFound, types.EventToolNotSupport:
+				s.manager.sendToExpert(&totalMsg)
+				s.manager.closeSession(s, totalMsg.EventType)
+				return
+			default:
+				logger.Warnf("后台会话 dialog %s 返回不支持的消息，忽略: %d", s.DialogID, totalMsg.EventType)
+			}
+			continue
+		}
+
 		switch totalMsg.EventType {
 		case types.EventServerMessage:
-			s.manager.toExpertMessageOutChan <- &totalMsg
+			s.manager.sendToExpert(&totalMsg)
+		case types.EventToolBackground:
+			s.manager.sendToExpert(&totalMsg)
+			s.manager.releaseForeground(s)
 		case types.EventToolFinish, types.EventToolNotFound, types.EventToolNotSupport:
-			s.manager.toExpertMessageOutChan <- &totalMsg
-			s.manager.CloseSession(s.DialogID, totalMsg.EventType)
+			s.manager.sendToExpert(&totalMsg)
+			s.manager.closeSession(s, totalMsg.EventType)
 			return
 		default:
 			logger.Errorf("返回不支持的消息 ： %v", totalMsg)
-			s.manager.toExpertMessageOutChan <- &totalMsg
+			s.manager.sendToExpert(&totalMsg)
 		}
 	}
 }
 
+// sendToExpert 把程序的消息交给程序库转发给专家，程序库已经停止转发时丢弃
+func (m *SessionManager) sendToExpert(message *types.TotalMessage) {
+	select {
+	case m.toExpertMessageOutChan <- message:
+	case <-m.outDone:
+		logger.Warnf("程序库已经停止，丢弃 dialog %s 的消息 event: %d", message.DialogID, message.EventType)
+	}
+}
+
 func (s *Session) waitForProcess() {
 	err := s.Cmd.Wait()
 	if err != nil {
@@ -239,7 +325,13 @@ func (s *Session) waitForProcess() {
 	} else {
 		logger.Infof("Node.js process for dialog %s exited gracefully.", s.DialogID)
 	}
-	s.manager.CloseSession(s.DialogID, types.EventToolFinish)
+	s.manager.closeSession(s, types.EventToolFinish)
+}
+
+func (s *Session) isBackground() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.background
 }
 
 func (s *Session) close() {
@@ -334,9 +426,9 @@ func (s *Session) resetTimeout() {
 	if s.timer != nil {
 		s.timer.Stop()
 	}
-	s.timer = time.AfterFunc(IdleTimeout, func() {
+	s.timer = time.AfterFunc(s.manager.IdleTimeout, func() {
 		logger.Infof("Session for dialog_id %s timed out due to inactivity.", s.DialogID)
-		s.manager.CloseSession(s.DialogID, types.EventToolFinish)
+		s.manager.closeSession(s, types.EventToolFinish)
 	})
 	s.LastAccess = time.Now()
 }
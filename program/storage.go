@@ -1,9 +1,11 @@
 package programs
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -28,6 +30,9 @@ type StorageManager struct {
 	DataDirPath  string
 	Port         string
 	SaveInterval time.Duration
+	server       *http.Server
+	stopChan     chan struct{} // 停止定期保存的信号
+	stopOnce     sync.Once
 }
 
 func NewStorage(dataDirPath string, port string) *StorageManager {
@@ -37,6 +42,7 @@ func NewStorage(dataDirPath string, port string) *StorageManager {
 		mu:           sync.RWMutex{},
 		Port:         port,
 		SaveInterval: 10 * time.Minute,
+		stopChan:     make(chan struct{}),
 	}
 
 	return storage
@@ -48,13 +54,35 @@ func (s *StorageManager) RunHTTPServer() {
 		panic("无法创建存储目录")
 	}
 	go s.periodicPersist()
-	http.HandleFunc("/memory", s.memoryHandler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/memory", s.memoryHandler)
+	server := &http.Server{Addr: fmt.Sprintf(":%s", s.Port), Handler: mux}
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
 	logger.Printf("Starting HTTP server on port %s", s.Port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%s", s.Port), nil); err != nil {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Fatalf("HTTP server failed: %v", err)
 	}
 }
 
+// Shutdown 停止定期保存和 HTTP 服务，并将所有dialog 数据写入磁盘
+func (s *StorageManager) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+
+	var err error
+	s.mu.RLock()
+	server := s.server
+	s.mu.RUnlock()
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+	s.persistAll()
+	return err
+}
+
 func (s *StorageManager) GetStroageHandler() func(w http.ResponseWriter, r *http.Request) {
 	return s.memoryHandler
 }
@@ -63,10 +91,28 @@ func (s *StorageManager) periodicPersist() {
 	ticker := time.NewTicker(s.SaveInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		for dialogID := range s.data {
-			s.persistDialogData(dialogID)
+	for {
+		select {
+		case <-ticker.C:
+			s.persistAll()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// persistAll 保存所有在内存中的dialog 数据
+func (s *StorageManager) persistAll() {
+	s.mu.RLock()
+	dialogIDs := make([]string, 0, len(s.data))
+	for dialogID := range s.data {
+		dialogIDs = append(dialogIDs, dialogID)
+	}
+	s.mu.RUnlock()
 
+	for _, dialogID := range dialogIDs {
+		if err := s.persistDialogData(dialogID); err != nil {
+			logger.Errorf("Failed to persist data for dialog %s: %v", dialogID, err)
 		}
 	}
 }
@@ -132,14 +178,16 @@ func (s *StorageManager) getOrCreateDialogData(dialogID string) *DialogData {
 		}
 	}
 
-	s.mu.RLock()
+	s.mu.Lock()
 	s.data[dialogID] = dialog
-	s.mu.RUnlock()
+	s.mu.Unlock()
 	return dialog
 }
 
 func (s *StorageManager) persistDialogData(dialogID string) error {
-	dialog := s.data[dialogID] // This is safe because periodicPersist holds the lock on s.mu
+	s.mu.RLock()
+	dialog := s.data[dialogID]
+	s.mu.RUnlock()
 
 	dialog.mu.Lock()
 	defer dialog.mu.Unlock()
@@ -1,16 +1,67 @@
 package types
 
-import "sync"
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDialogNotFound dialog 存储中没有该 dialog 或已经过期
+var ErrDialogNotFound = errors.New("dialog not found")
 
 // 存储对话相关信息的结构体
 type DialogInfo struct {
-	UserID      string       `json:"user_id"`              // 自建平台认证用户id
-	UserAgent   string       `json:"user_agent,omitempty"` // user_agent 是客户端的标识，用于区分不同的客户端
-	Platform    string       `json:"platform,omitempty"`   // platform 是客户端平台的标识 如 lark ,web ,speaker,等
-	DialogID    string       `json:"dialog_id"`            // 目前的对话ID
-	Program     string       `json:"program"`              // 目前对接的程序库，为空字符串代表现在没对接专家,需要小壮分析用户需求来分配一个程序库，
-	Mutil       bool         `json:"Mutil"`                // 多轮对话控制,是否在多轮对话
-	FirstMutil  bool         `json:"FirstMutil"`           // 是否是多轮对话的第一句话
-	ChatHistory []string     `json:"chat_history"`         // 当前dialog的历史消息记录
-	RWMutex     sync.RWMutex `json:"-"`
+	UserID            string         `json:"user_id"`                       // 自建平台认证用户id
+	UserAgent         string         `json:"user_agent,omitempty"`          // user_agent 是客户端的标识，用于区分不同的客户端
+	Platform          string         `json:"platform,omitempty"`            // platform 是客户端平台的标识 如 lark ,web ,speaker,等
+	DialogID          string         `json:"dialog_id"`                     // 目前的对话ID
+	Program           string         `json:"program"`                       // 目前对接的程序库，为空字符串代表现在没对接专家,需要小壮分析用户需求来分配一个程序库，
+	Mutil             bool           `json:"Mutil"`                         // 多轮对话控制,是否在多轮对话
+	FirstMutil        bool           `json:"FirstMutil"`                    // 是否是多轮对话的第一句话
+	FirstMutilContent string         `json:"first_mutil_content,omitempty"` // 进入多轮对话的第一句话，多轮对话识别出意图后用来学习意图缓存
+	RoutedContent     string         `json:"routed_content,omitempty"`      // 分配当前程序库时用户说的话（经过消息格式化函数处理，和意图识别分词的文本相同），程序库结束或不支持时记录为训练数据
+	ChatHistory       []HistoryEntry `json:"chat_history"`                  // 当前dialog的历史消息记录，兼容旧版的字符串格式
+	Pending           *PendingIntent `json:"pending,omitempty"`             // 等待用户回复确认的意图，用户下一句话会先用来确认
+	Human             bool           `json:"human,omitempty"`               // 是否由人工接管，接管期间用户消息只交给人工
+	UpdatedAt         time.Time      `json:"updated_at,omitzero"`           // 最后一次处理该 dialog 消息的时间
+	RWMutex           sync.RWMutex   `json:"-"`
+}
+
+const (
+	DialogStateIdle      = "idle"       // 没有对接程序库，用户的下一句话重新识别意图
+	DialogStateProgram   = "program"    // 对接程序库中，用户消息直接交给程序库
+	DialogStateMultiTurn = "multi_turn" // 多轮对话中，识别不到意图的消息交给多轮对话
+	DialogStateAwaiting  = "awaiting"   // 等待用户回答专家的选择、确认问题或补充参数
+	DialogStateHuman     = "human"      // 人工接管中，用户消息只交给人工
+)
+
+// State 由 Human、Pending、Program、Mutil 推导出 dialog 当前的状态，见 DialogStateIdle 等
+func (d *DialogInfo) State() string {
+	switch {
+	case d.Human:
+		return DialogStateHuman
+	case d.Pending != nil:
+		return DialogStateAwaiting
+	case d.Program != "":
+		return DialogStateProgram
+	case d.Mutil:
+		return DialogStateMultiTurn
+	default:
+		return DialogStateIdle
+	}
+}
+
+const (
+	PendingChoose  = "choose"  // 多个意图分数接近，等待用户选择
+	PendingConfirm = "confirm" // 意图分数处于中间区间，等待用户回答澄清问题
+	PendingSlot    = "slot"    // 意图缺少必填参数，等待用户补充
+)
+
+// PendingIntent 等待用户确认的意图
+type PendingIntent struct {
+	Kind       string               `json:"kind"`                 // 确认类型，见 PendingChoose 等
+	Candidates []PossibleIntentions `json:"candidates,omitempty"` // 候选意图
+	Message    *TotalMessage        `json:"message,omitempty"`    // 触发确认的原始用户消息，确认后交给程序库
+	Slots      map[string]any       `json:"slots,omitempty"`      // PendingSlot 时已经提取到的参数
+	Slot       string               `json:"slot,omitempty"`       // PendingSlot 时正在追问的参数名称
 }
@@ -0,0 +1,170 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	HistoryRoleUser      = "user"      // 用户说的话
+	HistoryRoleAssistant = "assistant" // 程序库、多轮对话或专家回复用户的话
+)
+
+const (
+	HistorySourceUser    = "user"    // 客户端
+	HistorySourceProgram = "program" // 程序库，Intent 为程序库名称
+	HistorySourceChat    = "chat"    // 多轮对话
+	HistorySourceExpert  = "expert"  // 专家直接回复，例如确认问题和追问参数
+	HistorySourceHuman   = "human"   // 人工接管后的回复
+)
+
+// legacyHistoryPrefixes 旧版 dailoginfo.json 中历史消息字符串的前缀
+var legacyHistoryPrefixes = []struct {
+	prefix string
+	source string
+}{
+	{"User: ", HistorySourceUser},
+	{"Progarm: ", HistorySourceProgram},
+	{"Program: ", HistorySourceProgram},
+	{"Chat: ", HistorySourceChat},
+	{"Expert: ", HistorySourceExpert},
+	{"Human: ", HistorySourceHuman},
+}
+
+// HistoryEntry dialog 中的一条历史消息
+type HistoryEntry struct {
+	Role        string       `json:"role"`                  // 见 HistoryRoleUser 等
+	Source      string       `json:"source"`                // 消息来自哪个模块，见 HistorySourceUser 等
+	Intent      string       `json:"intent,omitempty"`      // 记录时 dialog 对接的程序库
+	Content     string       `json:"content"`               // 消息正文
+	Attachments []Attachment `json:"attachments,omitempty"` // 消息附件
+	MessageID   string       `json:"message_id,omitempty"`  // 消息的唯一标识符
+	Time        time.Time    `json:"time,omitzero"`         // 记录时间，旧版数据为空
+}
+
+// NewHistoryEntry 由消息创建一条历史记录，Role 由 source 决定
+func NewHistoryEntry(source string, intent string, message *TotalMessage) HistoryEntry {
+	return HistoryEntry{
+		Role:        historyRole(source),
+		Source:      source,
+		Intent:      intent,
+		Content:     message.Messages.Content,
+		Attachments: message.Messages.Attachments,
+		MessageID:   message.MessageID,
+		Time:        time.Now(),
+	}
+}
+
+func historyRole(source string) string {
+	if source == HistorySourceUser {
+		return HistoryRoleUser
+	}
+	return HistoryRoleAssistant
+}
+
+// UnmarshalJSON 兼容旧版的 "User: xxx" 等带前缀的字符串
+func (h *HistoryEntry) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		*h = legacyHistoryEntry(legacy)
+		return nil
+	}
+	type entry HistoryEntry
+	return json.Unmarshal(data, (*entry)(h))
+}
+
+func legacyHistoryEntry(legacy string) HistoryEntry {
+	for _, item := range legacyHistoryPrefixes {
+		if content, ok := strings.CutPrefix(legacy, item.prefix); ok {
+			return HistoryEntry{Role: historyRole(item.source), Source: item.source, Content: content}
+		}
+	}
+	return HistoryEntry{Role: HistoryRoleUser, Source: HistorySourceUser, Content: legacy}
+}
+
+// Legacy 渲染为旧版的 "User: xxx" 格式，程序库的前缀沿用旧版的 "Progarm: "，外部模块可能按它解析
+func (h HistoryEntry) Legacy() string {
+	switch h.Source {
+	case HistorySourceUser:
+		return "User: " + h.Content
+	case HistorySourceProgram:
+		return "Progarm: " + h.Content
+	case HistorySourceChat:
+		return "Chat: " + h.Content
+	case HistorySourceExpert:
+		return "Expert: " + h.Content
+	case HistorySourceHuman:
+		return "Human: " + h.Content
+	}
+	return h.Content
+}
+
+// LegacyHistory 把历史消息渲染为旧版的字符串列表，放在消息的 messages.history 中兼容外部的多轮对话和人工模块
+func LegacyHistory(history []HistoryEntry) []string {
+	if len(history) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(history))
+	for _, entry := range history {
+		lines = append(lines, entry.Legacy())
+	}
+	return lines
+}
+
+// ParseLegacyHistory 把旧版的字符串列表转换为历史消息，用于只发送 messages.history 的旧版专家
+func ParseLegacyHistory(lines []string) []HistoryEntry {
+	if len(lines) == 0 {
+		return nil
+	}
+	history := make([]HistoryEntry, 0, len(lines))
+	for _, line := range lines {
+		history = append(history, legacyHistoryEntry(line))
+	}
+	return history
+}
+
+// String 渲染为一行文本，例如 "[10:02:03] 程序库(checkAutoStatus): 构建成功 [附件: image 截图.png]"
+func (h HistoryEntry) String() string {
+	var builder strings.Builder
+	if !h.Time.IsZero() {
+		builder.WriteString(h.Time.Format("[01-02 15:04:05] "))
+	}
+	switch h.Source {
+	case HistorySourceUser:
+		builder.WriteString("用户")
+	case HistorySourceProgram:
+		builder.WriteString("程序库")
+		if h.Intent != "" {
+			fmt.Fprintf(&builder, "(%s)", h.Intent)
+		}
+	case HistorySourceChat:
+		builder.WriteString("多轮对话")
+	case HistorySourceExpert:
+		builder.WriteString("专家")
+	case HistorySourceHuman:
+		builder.WriteString("人工")
+	default:
+		builder.WriteString(h.Role)
+	}
+	builder.WriteString(": ")
+	builder.WriteString(h.Content)
+	if len(h.Attachments) > 0 {
+		names := make([]string, 0, len(h.Attachments))
+		for _, attachment := range h.Attachments {
+			names = append(names, strings.TrimSpace(attachment.Type+" "+attachment.Name))
+		}
+		fmt.Fprintf(&builder, " [附件: %s]", strings.Join(names, ", "))
+	}
+	return builder.String()
+}
+
+// RenderHistory 把历史消息渲染为每行一条的文本，用于拼接大模型提示词
+func RenderHistory(history []HistoryEntry) string {
+	lines := make([]string, 0, len(history))
+	for _, entry := range history {
+		lines = append(lines, entry.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,74 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLegacyHistoryRoundTrip(t *testing.T) {
+	history := []HistoryEntry{
+		{Role: HistoryRoleUser, Source: HistorySourceUser, Content: "帮我触发编译"},
+		{Role: HistoryRoleAssistant, Source: HistorySourceProgram, Intent: "triggerAutoBuild", Content: "马上处理"},
+		{Role: HistoryRoleAssistant, Source: HistorySourceChat, Content: "你好"},
+		{Role: HistoryRoleAssistant, Source: HistorySourceExpert, Content: "是要查看状态吗？"},
+		{Role: HistoryRoleAssistant, Source: HistorySourceHuman, Content: "人工客服为您服务"},
+	}
+	lines := LegacyHistory(history)
+	want := []string{"User: 帮我触发编译", "Progarm: 马上处理", "Chat: 你好", "Expert: 是要查看状态吗？", "Human: 人工客服为您服务"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("LegacyHistory() = %q, want %q", lines, want)
+	}
+
+	parsed := ParseLegacyHistory(lines)
+	for i, entry := range parsed {
+		if entry.Role != history[i].Role || entry.Source != history[i].Source || entry.Content != history[i].Content {
+			t.Errorf("ParseLegacyHistory()[%d] = %+v, want %+v", i, entry, history[i])
+		}
+	}
+}
+
+func TestMessageHistoryWireFormat(t *testing.T) {
+	message := TotalMessage{EventType: EventUserMessage, DialogID: "d1"}
+	message.Messages.Content = "查看状态"
+	history := []HistoryEntry{{Role: HistoryRoleUser, Source: HistorySourceUser, Content: "查看状态"}}
+	message.Messages.History = LegacyHistory(history)
+	message.Messages.HistoryEntries = history
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire struct {
+		Messages struct {
+			History        []string          `json:"history"`
+			HistoryEntries []json.RawMessage `json:"history_entries"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		t.Fatalf("history is not a string list: %v", err)
+	}
+	if !reflect.DeepEqual(wire.Messages.History, []string{"User: 查看状态"}) {
+		t.Errorf("history = %q, want [User: 查看状态]", wire.Messages.History)
+	}
+	if len(wire.Messages.HistoryEntries) != 1 {
+		t.Errorf("history_entries has %d entries, want 1", len(wire.Messages.HistoryEntries))
+	}
+}
+
+func TestHistoryEntryUnmarshalLegacy(t *testing.T) {
+	var history []HistoryEntry
+	data := `["User: 你好", "Program: 好的", {"role":"assistant","source":"chat","content":"结构化"}, "没有前缀"]`
+	if err := json.Unmarshal([]byte(data), &history); err != nil {
+		t.Fatal(err)
+	}
+	want := []HistoryEntry{
+		{Role: HistoryRoleUser, Source: HistorySourceUser, Content: "你好"},
+		{Role: HistoryRoleAssistant, Source: HistorySourceProgram, Content: "好的"},
+		{Role: HistoryRoleAssistant, Source: HistorySourceChat, Content: "结构化"},
+		{Role: HistoryRoleUser, Source: HistorySourceUser, Content: "没有前缀"},
+	}
+	if !reflect.DeepEqual(history, want) {
+		t.Errorf("Unmarshal() = %+v, want %+v", history, want)
+	}
+}
@@ -7,13 +7,20 @@ const (
 	EventToolFinish         = 2002
 	EventToolNotSupport     = 2003
 	EventToolNotFound       = 2004
+	EventToolBackground     = 2005 // 程序交还前台，继续在后台运行，之后的消息带 background 标记
+	EventChatDelta          = 2006 // 多轮对话流式回复的增量片段，同一次回复的片段 message_id 相同
+	EventChatDone           = 2007 // 多轮对话流式回复结束，content 为完整的回复
 	EventSpecialInstruction = 3000
+	EventHumanTakeover      = 4001 // 人工接管 dialog，之后用户消息只交给人工
+	EventHumanReply         = 4002 // 人工回复用户，专家以 2001 转发给用户
+	EventHumanRelease       = 4003 // 人工交还 dialog，intention 不为空时分配该程序库
 )
 
 type PossibleIntentions struct {
 	IntentName        string  `json:"intent_name"`
 	IntentDescription string  `json:"intent_description"`
 	Probability       float64 `json:"probability"`
+	Question          string  `json:"question,omitempty"` // 匹配器给出的澄清问题，分数处于中间区间时专家用来向用户确认
 }
 
 type Attachment struct {
@@ -29,12 +36,17 @@ type TotalMessage struct {
 	DialogID           string               `json:"dialog_id"`
 	UserId             string               `json:"user_id"`
 	MessageID          string               `json:"message_id,omitempty"`
+	Platform           string               `json:"platform,omitempty"`            // 客户端平台，例如 lark、web、speaker，用户发给专家时可选，记录在 dialog 中
+	UserAgent          string               `json:"user_agent,omitempty"`          // 客户端标识，用户发给专家时可选，记录在 dialog 中
 	Intention          string               `json:"intention,omitempty"`           // 专家告诉程序库匹配的意图,专家发给程序库才有此字段
 	PossibleIntentions []PossibleIntentions `json:"possible_intentions,omitempty"` // 专家告诉多轮会话可能匹配的意图,专家发给多轮对话才有此字段
+	Slots              map[string]any       `json:"slots,omitempty"`               // 专家从用户原始消息中提取的意图参数,专家发给程序库才有此字段
+	Background         bool                 `json:"background,omitempty"`          // 消息来自交还前台后在后台运行的程序，intention 为该程序库，程序库发给专家才有此字段
 	Messages           struct {
-		Content     string       `json:"content"`
-		Attachments []Attachment `json:"attachments"`
-		History     []string     `json:"history,omitempty"` // 当前dialog的历史消息记录,专家发给多轮对话才有此字段
+		Content        string         `json:"content"`
+		Attachments    []Attachment   `json:"attachments"`
+		History        []string       `json:"history,omitempty"`         // 当前dialog的历史消息记录,专家发给多轮对话才有此字段，旧版 "User: xxx" 格式，见 LegacyHistory
+		HistoryEntries []HistoryEntry `json:"history_entries,omitempty"` // 和 History 相同的历史消息，带来源、程序库、附件和时间
 
 	} `json:"messages,omitzero"`
 }
@@ -72,9 +84,10 @@ type ExpertToChatMessage struct {
 	UserId             string               `json:"user_id"`
 	PossibleIntentions []PossibleIntentions `json:"possible_intentions"`
 	Messages           struct {
-		Content     string       `json:"content"`
-		Attachments []Attachment `json:"attachments"`
-		History     []string     `json:"history,omitempty"`
+		Content        string         `json:"content"`
+		Attachments    []Attachment   `json:"attachments"`
+		History        []string       `json:"history,omitempty"`
+		HistoryEntries []HistoryEntry `json:"history_entries,omitempty"`
 	} `json:"messages"`
 }
 